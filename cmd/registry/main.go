@@ -5,6 +5,9 @@ import (
 
 	"github.com/distribution/distribution/v3/registry"
 	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	_ "github.com/distribution/distribution/v3/registry/auth/mtls"
+	_ "github.com/distribution/distribution/v3/registry/auth/rbac"
+	_ "github.com/distribution/distribution/v3/registry/auth/robot"
 	_ "github.com/distribution/distribution/v3/registry/auth/silly"
 	_ "github.com/distribution/distribution/v3/registry/auth/token"
 	_ "github.com/distribution/distribution/v3/registry/proxy"
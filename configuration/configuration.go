@@ -149,6 +149,13 @@ type Configuration struct {
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			// Credentials configures the admin API for robot credentials,
+			// served when the robot access controller is in use.
+			Credentials struct {
+				// Token is the bearer token admin requests must present.
+				// The admin API is not served without one.
+				Token string `yaml:"token,omitempty"`
+			} `yaml:"credentials,omitempty"`
 		} `yaml:"debug,omitempty"`
 
 		// HTTP2 configuration options
@@ -246,7 +253,128 @@ type Configuration struct {
 			// the class in authorized resources.
 			Classes []string `yaml:"classes"`
 		} `yaml:"repository,omitempty"`
+
+		// Tags configures policies for tags
+		Tags struct {
+			// Immutable lists rules making tags immutable. Once pushed, a
+			// matching tag cannot be moved to another manifest or deleted.
+			Immutable []ImmutableTagRule `yaml:"immutable,omitempty"`
+		} `yaml:"tags,omitempty"`
 	} `yaml:"policy,omitempty"`
+
+	// RateLimit configures per-client rate limits on requests.
+	RateLimit RateLimit `yaml:"ratelimit,omitempty"`
+
+	// Audit configures the audit log of authorization decisions and
+	// mutating API calls.
+	Audit Audit `yaml:"audit,omitempty"`
+
+	// Admission configures webhooks reviewing manifest pushes.
+	Admission Admission `yaml:"admission,omitempty"`
+
+	// Signatures configures policies requiring manifests to be signed.
+	Signatures Signatures `yaml:"signatures,omitempty"`
+}
+
+// Signatures configures signature verification of manifests.
+type Signatures struct {
+	// Policies are matched in order against the repository of a manifest.
+	// The first matching policy applies.
+	Policies []SignaturePolicy `yaml:"policies,omitempty"`
+}
+
+// SignaturePolicy requires the manifests of a set of repositories to be
+// signed before they are tagged or served.
+type SignaturePolicy struct {
+	// Repositories lists glob patterns of the repositories the policy
+	// applies to.
+	Repositories []string `yaml:"repositories"`
+
+	// Mode is "enforce" (the default), rejecting unsigned manifests, or
+	// "audit", only logging them.
+	Mode string `yaml:"mode,omitempty"`
+
+	// Keys lists the paths of PEM encoded public keys trusted to sign
+	// manifests.
+	Keys []string `yaml:"keys"`
+}
+
+// ImmutableTagRule makes the tags matching a regular expression immutable in
+// the repositories matching a set of glob patterns.
+type ImmutableTagRule struct {
+	// Repositories lists glob patterns of repository names.
+	Repositories []string `yaml:"repositories"`
+
+	// Tags lists regular expressions (https://godoc.org/regexp/syntax)
+	// which must match the whole tag.
+	Tags []string `yaml:"tags"`
+}
+
+// Admission configures admission control of manifest pushes.
+type Admission struct {
+	// Webhooks are called in order before a manifest is stored. Any of
+	// them may reject the push.
+	Webhooks []AdmissionWebhook `yaml:"webhooks,omitempty"`
+}
+
+// AdmissionWebhook configures a validating webhook.
+type AdmissionWebhook struct {
+	Name         string        `yaml:"name"`         // identifies the webhook in logs and errors
+	Disabled     bool          `yaml:"disabled"`     // disables the webhook
+	URL          string        `yaml:"url"`          // post url for admission requests
+	Headers      http.Header   `yaml:"headers"`      // static headers that should be added to all requests
+	Timeout      time.Duration `yaml:"timeout"`      // HTTP timeout
+	FailOpen     bool          `yaml:"failopen"`     // allow pushes when the webhook fails
+	Repositories []string      `yaml:"repositories"` // glob patterns of the repositories reviewed, all if empty
+}
+
+// Audit configures the audit log.
+type Audit struct {
+	// Enabled enables the audit log.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Sink is the name of the sink records are written to. It defaults to
+	// "file".
+	Sink string `yaml:"sink,omitempty"`
+
+	// Options are passed to the sink, such as the path of the file sink.
+	Options Parameters `yaml:"options,omitempty"`
+}
+
+// RateLimit configures token bucket rate limits, each with its own budget.
+// Requests exceeding a limit are rejected with TOOMANYREQUESTS.
+type RateLimit struct {
+	// Enabled enables rate limiting.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Backend is where the state of the buckets is kept, "inmemory" (the
+	// default) or "redis", which shares limits between registry instances.
+	Backend string `yaml:"backend,omitempty"`
+
+	// Manifests limits manifest GET requests.
+	Manifests RateLimitBucket `yaml:"manifests,omitempty"`
+
+	// BlobBytes limits the number of bytes of blobs served.
+	BlobBytes RateLimitBucket `yaml:"blobbytes,omitempty"`
+
+	// Uploads limits the number of blob uploads started.
+	Uploads RateLimitBucket `yaml:"uploads,omitempty"`
+}
+
+// RateLimitBucket configures a single rate limit. A limit with no rate is
+// disabled.
+type RateLimitBucket struct {
+	// Key selects what clients are limited by: "user" (the default), "ip"
+	// or "repository". Anonymous clients are limited by IP when keyed by
+	// user.
+	Key string `yaml:"key,omitempty"`
+
+	// Rate is the number of tokens added to each bucket every second.
+	Rate float64 `yaml:"rate,omitempty"`
+
+	// Burst is the number of tokens a bucket holds when full. It defaults
+	// to the rate, rounded up.
+	Burst int64 `yaml:"burst,omitempty"`
 }
 
 // LogHook is composed of hook Level and Type.
@@ -427,6 +555,8 @@ func (storage Storage) Type() string {
 			// allow configuration of delete
 		case "redirect":
 			// allow configuration of redirect
+		case "metadata":
+			// allow configuration of the metadata store
 		default:
 			storageType = append(storageType, k)
 		}
@@ -468,6 +598,8 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 					// allow configuration of delete
 				case "redirect":
 					// allow configuration of redirect
+				case "metadata":
+					// allow configuration of the metadata store
 				default:
 					types = append(types, k)
 				}
@@ -568,6 +700,15 @@ type Notifications struct {
 	// respond to webhook notifications. In the future, we may allow other
 	// kinds of endpoints, such as external queues.
 	Endpoints []Endpoint `yaml:"endpoints,omitempty"`
+	// Recent configures an in-process buffer of recent events, queryable
+	// through the debug server.
+	Recent RecentEvents `yaml:"recent,omitempty"`
+}
+
+// RecentEvents configures the in-process buffer of recent events.
+type RecentEvents struct {
+	Enabled bool `yaml:"enabled,omitempty"` // enables the buffer
+	Size    int  `yaml:"size,omitempty"`    // maximum number of events retained
 }
 
 // Endpoint describes the configuration of an http webhook notification
@@ -98,6 +98,9 @@ var configStruct = Configuration{
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			Credentials struct {
+				Token string `yaml:"token,omitempty"`
+			} `yaml:"credentials,omitempty"`
 		} `yaml:"debug,omitempty"`
 		HTTP2 struct {
 			Disabled bool `yaml:"disabled,omitempty"`
@@ -430,6 +433,23 @@ func (suite *ConfigSuite) TestParseEnvVarImplicitMaps(c *C) {
 	c.Assert(config, DeepEquals, suite.expectedConfig)
 }
 
+// TestParseMetadataStore validates that a metadata store can be configured
+// alongside the storage driver.
+func (suite *ConfigSuite) TestParseMetadataStore(c *C) {
+	suite.expectedConfig.Storage["metadata"] = Parameters{
+		"database": "bolt",
+		"path":     "/var/lib/registry/metadata.db",
+	}
+
+	os.Setenv("REGISTRY_STORAGE_METADATA_DATABASE", "bolt")
+	os.Setenv("REGISTRY_STORAGE_METADATA_PATH", "/var/lib/registry/metadata.db")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	c.Assert(err, IsNil)
+	c.Assert(config, DeepEquals, suite.expectedConfig)
+	c.Assert(config.Storage.Type(), Equals, "s3")
+}
+
 // TestParseEnvWrongTypeMap validates that incorrectly attempting to unmarshal a
 // string over existing map fails.
 func (suite *ConfigSuite) TestParseEnvWrongTypeMap(c *C) {
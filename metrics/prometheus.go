@@ -13,4 +13,10 @@ var (
 
 	// NotificationsNamespace is the prometheus namespace of notification related metrics
 	NotificationsNamespace = metrics.NewNamespace(NamespacePrefix, "notifications", nil)
+
+	// AuthNamespace is the prometheus namespace of authentication related metrics
+	AuthNamespace = metrics.NewNamespace(NamespacePrefix, "auth", nil)
+
+	// RateLimitNamespace is the prometheus namespace of rate limiting related metrics
+	RateLimitNamespace = metrics.NewNamespace(NamespacePrefix, "ratelimit", nil)
 )
@@ -0,0 +1,192 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+// DefaultRecentEventsSize is the number of events retained by a RecentEvents
+// sink when no size is configured.
+const DefaultRecentEventsSize = 1000
+
+// RecentEvents is a sink that keeps the most recent events in a fixed size
+// ring buffer so they can be queried in-process. Once the buffer is full, the
+// oldest event is overwritten by each new one.
+type RecentEvents struct {
+	mu     sync.Mutex
+	events []Event
+	next   int  // index of the slot the next event is written to
+	full   bool // true once the ring buffer has wrapped
+	closed bool
+}
+
+var _ events.Sink = &RecentEvents{}
+
+// NewRecentEvents returns a RecentEvents sink retaining up to size events. If
+// size is not positive, DefaultRecentEventsSize is used.
+func NewRecentEvents(size int) *RecentEvents {
+	if size <= 0 {
+		size = DefaultRecentEventsSize
+	}
+
+	return &RecentEvents{
+		events: make([]Event, size),
+	}
+}
+
+// Write records the event, evicting the oldest event if the buffer is full.
+func (re *RecentEvents) Write(event events.Event) error {
+	e, ok := event.(Event)
+	if !ok {
+		return fmt.Errorf("recent events: unexpected event type %T", event)
+	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if re.closed {
+		return ErrSinkClosed
+	}
+
+	re.events[re.next] = e
+	re.next = (re.next + 1) % len(re.events)
+	if re.next == 0 {
+		re.full = true
+	}
+
+	return nil
+}
+
+// Close stops the sink from accepting new events. Events already recorded
+// remain queryable.
+func (re *RecentEvents) Close() error {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if re.closed {
+		return fmt.Errorf("recent events: already closed")
+	}
+
+	re.closed = true
+	return nil
+}
+
+// EventFilter selects events from a RecentEvents sink. Zero valued fields
+// match every event.
+type EventFilter struct {
+	// Repository matches the target repository of the event.
+	Repository string
+
+	// Action matches the action of the event, such as "push" or "delete".
+	Action string
+
+	// Actor matches the name of the actor that initiated the event.
+	Actor string
+
+	// Since excludes events that occurred before this time.
+	Since time.Time
+
+	// Until excludes events that occurred after this time.
+	Until time.Time
+}
+
+// Match returns true if the event satisfies every criteria of the filter.
+func (f EventFilter) Match(event Event) bool {
+	if f.Repository != "" && event.Target.Repository != f.Repository {
+		return false
+	}
+
+	if f.Action != "" && event.Action != f.Action {
+		return false
+	}
+
+	if f.Actor != "" && event.Actor.Name != f.Actor {
+		return false
+	}
+
+	if !f.Since.IsZero() && event.Timestamp.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && event.Timestamp.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// Query returns the retained events matching the filter, oldest first.
+func (re *RecentEvents) Query(filter EventFilter) []Event {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	var start, n int
+	if re.full {
+		start, n = re.next, len(re.events)
+	} else {
+		start, n = 0, re.next
+	}
+
+	matched := make([]Event, 0)
+	for i := 0; i < n; i++ {
+		event := re.events[(start+i)%len(re.events)]
+		if filter.Match(event) {
+			matched = append(matched, event)
+		}
+	}
+
+	return matched
+}
+
+// ServeHTTP serves the retained events as a json envelope. The result may be
+// filtered with the "repository", "action", "actor", "since" and "until"
+// query parameters, where the time bounds are formatted as RFC 3339.
+func (re *RecentEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := EventFilter{
+		Repository: q.Get("repository"),
+		Action:     q.Get("action"),
+		Actor:      q.Get("actor"),
+	}
+
+	for param, bound := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		value := q.Get(param)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s parameter: %v", param, err), http.StatusBadRequest)
+			return
+		}
+		*bound = t
+	}
+
+	matched := re.Query(filter)
+	envelope := Envelope{
+		Events: make([]events.Event, len(matched)),
+	}
+	for i, event := range matched {
+		envelope.Events[i] = event
+	}
+
+	w.Header().Set("Content-Type", EventsMediaType)
+	if err := json.NewEncoder(w).Encode(envelope); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
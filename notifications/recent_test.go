@@ -0,0 +1,130 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecentEventsRingBuffer(t *testing.T) {
+	re := NewRecentEvents(3)
+
+	for _, repo := range []string{"a", "b", "c", "d", "e"} {
+		if err := re.Write(createTestEvent("push", repo, layerMediaType)); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+
+	all := re.Query(EventFilter{})
+	if len(all) != 3 {
+		t.Fatalf("unexpected number of events: %d != 3", len(all))
+	}
+
+	for i, expected := range []string{"c", "d", "e"} {
+		if all[i].Target.Repository != expected {
+			t.Fatalf("unexpected repository at %d: %q != %q", i, all[i].Target.Repository, expected)
+		}
+	}
+
+	if err := re.Close(); err != nil {
+		t.Fatalf("unexpected error closing sink: %v", err)
+	}
+
+	if err := re.Write(createTestEvent("push", "f", layerMediaType)); err != ErrSinkClosed {
+		t.Fatalf("expected ErrSinkClosed writing to closed sink, got %v", err)
+	}
+
+	if len(re.Query(EventFilter{})) != 3 {
+		t.Fatalf("events should remain queryable after close")
+	}
+}
+
+func TestRecentEventsFilter(t *testing.T) {
+	re := NewRecentEvents(0)
+	now := time.Now()
+
+	for i, action := range []string{"push", "pull", "delete", "push"} {
+		event := createTestEvent(action, "library/test", layerMediaType)
+		event.Timestamp = now.Add(time.Duration(i) * time.Minute)
+		event.Actor.Name = "alice"
+		if i%2 == 1 {
+			event.Actor.Name = "bob"
+			event.Target.Repository = "library/other"
+		}
+
+		if err := re.Write(event); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+
+	for _, testcase := range []struct {
+		filter   EventFilter
+		expected int
+	}{
+		{EventFilter{}, 4},
+		{EventFilter{Action: "push"}, 2},
+		{EventFilter{Repository: "library/other"}, 2},
+		{EventFilter{Actor: "alice", Action: "push"}, 1},
+		{EventFilter{Since: now.Add(time.Minute)}, 3},
+		{EventFilter{Until: now.Add(time.Minute)}, 2},
+		{EventFilter{Since: now.Add(time.Minute), Until: now.Add(2 * time.Minute)}, 2},
+		{EventFilter{Actor: "mallory"}, 0},
+	} {
+		if matched := re.Query(testcase.filter); len(matched) != testcase.expected {
+			t.Errorf("unexpected number of events for %+v: %d != %d", testcase.filter, len(matched), testcase.expected)
+		}
+	}
+}
+
+func TestRecentEventsHTTP(t *testing.T) {
+	re := NewRecentEvents(10)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for i, repo := range []string{"library/test", "library/other", "library/test"} {
+		event := createTestEvent("push", repo, layerMediaType)
+		event.Timestamp = now.Add(time.Duration(i) * time.Minute)
+		if err := re.Write(event); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+
+	server := httptest.NewServer(re)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?repository=library/test&since=" + now.Add(time.Minute).Format(time.RFC3339))
+	if err != nil {
+		t.Fatalf("unexpected error querying events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %v", resp.Status)
+	}
+
+	var envelope struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+
+	if len(envelope.Events) != 1 {
+		t.Fatalf("unexpected number of events: %d != 1", len(envelope.Events))
+	}
+
+	if !envelope.Events[0].Timestamp.Equal(now.Add(2 * time.Minute)) {
+		t.Fatalf("unexpected event returned: %#v", envelope.Events[0])
+	}
+
+	resp, err = http.Get(server.URL + "?until=yesterday")
+	if err != nil {
+		t.Fatalf("unexpected error querying events: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request for invalid time bound, got %v", resp.Status)
+	}
+}
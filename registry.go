@@ -2,6 +2,7 @@ package distribution
 
 import (
 	"context"
+	"time"
 
 	"github.com/distribution/distribution/v3/reference"
 )
@@ -59,6 +60,28 @@ type RepositoryRemover interface {
 	Remove(ctx context.Context, name reference.Named) error
 }
 
+// RepositoryDescriber describes the contents of repositories
+type RepositoryDescriber interface {
+	Describe(ctx context.Context, name reference.Named) (RepositoryDescription, error)
+}
+
+// RepositoryDescription summarizes the contents of a repository.
+type RepositoryDescription struct {
+	// Name is the name of the repository.
+	Name string `json:"name"`
+
+	// TagCount is the number of tags in the repository.
+	TagCount int `json:"tagCount"`
+
+	// LastPush is the last time a manifest or tag was written to the
+	// repository.
+	LastPush time.Time `json:"lastPush"`
+
+	// Size is the total size of the manifests and blobs linked into the
+	// repository, counting each blob once.
+	Size int64 `json:"size"`
+}
+
 // ManifestServiceOption is a function argument for Manifest Service methods
 type ManifestServiceOption interface {
 	Apply(ManifestService) error
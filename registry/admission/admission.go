@@ -0,0 +1,104 @@
+// Package admission calls validating webhooks which may reject manifests
+// before they are stored.
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/opencontainers/go-digest"
+)
+
+// Request is posted to webhooks to review a manifest push.
+type Request struct {
+	// RequestID identifies the push in the logs of the registry.
+	RequestID string `json:"requestID,omitempty"`
+
+	Repository string        `json:"repository"`
+	Tag        string        `json:"tag,omitempty"`
+	Digest     digest.Digest `json:"digest"`
+	MediaType  string        `json:"mediaType"`
+
+	// Manifest is the manifest being pushed, as received.
+	Manifest json.RawMessage `json:"manifest"`
+
+	// References are the descriptors referenced by the manifest, such as
+	// its config and layers or the manifests of an index.
+	References []distribution.Descriptor `json:"references"`
+
+	Actor Actor `json:"actor"`
+}
+
+// Actor is the client pushing the manifest.
+type Actor struct {
+	// Name is the authenticated user, if any.
+	Name string `json:"name,omitempty"`
+
+	// Addr is the IP address of the client.
+	Addr string `json:"addr,omitempty"`
+}
+
+// Response is returned by webhooks with their decision.
+type Response struct {
+	Allowed bool `json:"allowed"`
+
+	// Message explains why the push was rejected. It is returned to the
+	// client.
+	Message string `json:"message,omitempty"`
+}
+
+// DeniedError is returned when a webhook rejects a push, or fails while
+// being configured to fail closed.
+type DeniedError struct {
+	Webhook string
+	Message string
+}
+
+func (e DeniedError) Error() string {
+	return fmt.Sprintf("admission webhook %q denied the request: %s", e.Webhook, e.Message)
+}
+
+// Controller reviews pushes with a list of webhooks.
+type Controller struct {
+	webhooks []*Webhook
+}
+
+// NewController returns a controller calling webhooks in order.
+func NewController(webhooks ...*Webhook) *Controller {
+	return &Controller{webhooks: webhooks}
+}
+
+// Admit reviews the push with each webhook configured for its repository,
+// returning a DeniedError if one of them rejects it. Failures of webhooks
+// which fail open are logged and ignored.
+func (c *Controller) Admit(ctx context.Context, req *Request) error {
+	for _, webhook := range c.webhooks {
+		if !webhook.matches(req.Repository) {
+			continue
+		}
+
+		resp, err := webhook.Review(ctx, req)
+		if err != nil {
+			if webhook.FailOpen {
+				dcontext.GetLogger(ctx).Warnf("admission webhook %q failed, allowing push: %v", webhook.name, err)
+				continue
+			}
+
+			dcontext.GetLogger(ctx).Errorf("admission webhook %q failed, denying push: %v", webhook.name, err)
+			return DeniedError{Webhook: webhook.name, Message: "webhook unavailable"}
+		}
+
+		if !resp.Allowed {
+			message := resp.Message
+			if message == "" {
+				message = "rejected"
+			}
+			return DeniedError{Webhook: webhook.name, Message: message}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+func testRequest() *Request {
+	manifest := []byte(`{"schemaVersion":2}`)
+
+	return &Request{
+		Repository: "prod/app",
+		Tag:        "v1.0.0",
+		Digest:     digest.FromBytes(manifest),
+		MediaType:  "application/vnd.oci.image.manifest.v1+json",
+		Manifest:   manifest,
+		References: []distribution.Descriptor{
+			{MediaType: "application/vnd.oci.image.config.v1+json", Digest: digest.FromString("config"), Size: 6},
+		},
+		Actor: Actor{Name: "alice", Addr: "192.0.2.1"},
+	}
+}
+
+// reviewer is a webhook server answering with resp after delay.
+type reviewer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []Request
+	headers  []http.Header
+}
+
+func newReviewer(t *testing.T, status int, resp Response, delay time.Duration) *reviewer {
+	rv := &reviewer{}
+	rv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid admission request: %v", err)
+		}
+
+		rv.mu.Lock()
+		rv.requests = append(rv.requests, req)
+		rv.headers = append(rv.headers, r.Header)
+		rv.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(rv.Close)
+
+	return rv
+}
+
+func (rv *reviewer) count() int {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	return len(rv.requests)
+}
+
+func newTestWebhook(t *testing.T, name, url string, config WebhookConfig) *Webhook {
+	webhook, err := NewWebhook(name, url, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+func TestAdmit(t *testing.T) {
+	ctx := context.Background()
+
+	allow := newReviewer(t, http.StatusOK, Response{Allowed: true}, 0)
+	deny := newReviewer(t, http.StatusOK, Response{Message: "critical vulnerabilities found"}, 0)
+
+	controller := NewController(
+		newTestWebhook(t, "policy", allow.URL, WebhookConfig{
+			Headers: http.Header{"Authorization": {"Bearer secret"}},
+		}),
+		newTestWebhook(t, "scanner", deny.URL, WebhookConfig{
+			Repositories: []string{"prod/**"},
+		}),
+	)
+
+	err := controller.Admit(ctx, testRequest())
+	denied, ok := err.(DeniedError)
+	if !ok {
+		t.Fatalf("expected push to be denied, got %v", err)
+	}
+	if denied.Webhook != "scanner" || denied.Message != "critical vulnerabilities found" {
+		t.Errorf("unexpected denial: %+v", denied)
+	}
+
+	if allow.count() != 1 {
+		t.Fatalf("expected one request to the policy webhook, got %d", allow.count())
+	}
+	received := allow.requests[0]
+	if received.Repository != "prod/app" || received.Tag != "v1.0.0" || received.Actor.Name != "alice" ||
+		len(received.References) != 1 || string(received.Manifest) != `{"schemaVersion":2}` {
+		t.Errorf("unexpected admission request: %+v", received)
+	}
+	if got := allow.headers[0].Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("expected configured headers to be sent, got %q", got)
+	}
+
+	// Repositories outside of the scanner's patterns are not reviewed by it.
+	req := testRequest()
+	req.Repository = "dev/app"
+	if err := controller.Admit(ctx, req); err != nil {
+		t.Errorf("expected push outside of the scanner's repositories to be admitted, got %v", err)
+	}
+	if deny.count() != 1 {
+		t.Errorf("expected scanner not to be called, got %d requests", deny.count())
+	}
+}
+
+func TestAdmitFailures(t *testing.T) {
+	ctx := context.Background()
+
+	slow := newReviewer(t, http.StatusOK, Response{Allowed: true}, time.Second)
+	broken := newReviewer(t, http.StatusInternalServerError, Response{}, 0)
+
+	for _, testcase := range []struct {
+		name     string
+		url      string
+		failOpen bool
+	}{
+		{"timeout", slow.URL, false},
+		{"timeout", slow.URL, true},
+		{"error", broken.URL, false},
+		{"error", broken.URL, true},
+		{"unreachable", "http://127.0.0.1:1/", false},
+		{"unreachable", "http://127.0.0.1:1/", true},
+	} {
+		controller := NewController(newTestWebhook(t, testcase.name, testcase.url, WebhookConfig{
+			Timeout:  50 * time.Millisecond,
+			FailOpen: testcase.failOpen,
+		}))
+
+		start := time.Now()
+		err := controller.Admit(ctx, testRequest())
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: webhook timeout not applied, took %v", testcase.name, elapsed)
+		}
+
+		if testcase.failOpen {
+			if err != nil {
+				t.Errorf("%s: expected failing webhook to fail open, got %v", testcase.name, err)
+			}
+		} else if _, ok := err.(DeniedError); !ok {
+			t.Errorf("%s: expected failing webhook to fail closed, got %v", testcase.name, err)
+		}
+	}
+}
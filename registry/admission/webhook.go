@@ -0,0 +1,129 @@
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// maxResponseSize bounds the size of webhook responses.
+const maxResponseSize = 1 << 20
+
+// WebhookConfig covers the optional configuration parameters of a webhook.
+type WebhookConfig struct {
+	Headers http.Header
+	Timeout time.Duration
+
+	// FailOpen allows pushes when the webhook cannot be reached or fails.
+	// By default such pushes are denied.
+	FailOpen bool
+
+	// Repositories lists glob patterns of the repositories the webhook
+	// reviews. All repositories are reviewed when it is empty.
+	Repositories []string
+
+	Transport http.RoundTripper `json:"-"`
+}
+
+// defaults set any zero-valued fields to a reasonable default.
+func (wc *WebhookConfig) defaults() {
+	if wc.Timeout <= 0 {
+		wc.Timeout = 10 * time.Second
+	}
+
+	if wc.Transport == nil {
+		wc.Transport = http.DefaultTransport
+	}
+}
+
+// Webhook posts admission requests to an http service.
+type Webhook struct {
+	name string
+	url  string
+
+	WebhookConfig
+
+	repositories *auth.RepositoryMatcher
+	client       *http.Client
+}
+
+// NewWebhook returns a webhook posting admission requests to url.
+func NewWebhook(name, url string, config WebhookConfig) (*Webhook, error) {
+	webhook := &Webhook{
+		name:          name,
+		url:           url,
+		WebhookConfig: config,
+	}
+	webhook.defaults()
+
+	if len(webhook.Repositories) > 0 {
+		matcher, err := auth.NewRepositoryMatcher(webhook.Repositories...)
+		if err != nil {
+			return nil, fmt.Errorf("admission webhook %q: %v", name, err)
+		}
+		webhook.repositories = matcher
+	}
+
+	webhook.client = &http.Client{
+		Transport: webhook.Transport,
+		Timeout:   webhook.Timeout,
+	}
+
+	return webhook, nil
+}
+
+// Name returns the name of the webhook.
+func (wh *Webhook) Name() string {
+	return wh.name
+}
+
+// matches returns true if the webhook reviews pushes to the repository.
+func (wh *Webhook) matches(repository string) bool {
+	return wh.repositories == nil || wh.repositories.Match(repository)
+}
+
+// Review posts the request to the webhook and returns its decision. Errors
+// are returned when the webhook cannot be reached, times out or does not
+// respond with a decision.
+func (wh *Webhook) Review(ctx context.Context, req *Request) (*Response, error) {
+	p, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, wh.url, bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+
+	for k, v := range wh.Headers {
+		httpReq.Header[k] = v
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := wh.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+		return nil, fmt.Errorf("unexpected response status %v", resp.Status)
+	}
+
+	var review Response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&review); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+
+	return &review, nil
+}
@@ -573,6 +573,18 @@ var routeDescriptors = []RouteDescriptor{
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
+							{
+								Name:        "If-Match",
+								Type:        "string",
+								Format:      `"<digest>"`,
+								Description: "Only update the tag if it currently references one of the listed manifest digests, as returned in the `Etag` header of a manifest `GET`. `*` requires the tag to exist.",
+							},
+							{
+								Name:        "If-None-Match",
+								Type:        "string",
+								Format:      "*",
+								Description: "Only update the tag if it does not currently reference one of the listed manifest digests. `*` requires the tag not to exist, making the request create-only.",
+							},
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
@@ -649,6 +661,18 @@ var routeDescriptors = []RouteDescriptor{
 									errcode.ErrorCodeUnsupported,
 								},
 							},
+							{
+								Name:        "Precondition Failed",
+								Description: "The tag does not satisfy the `If-Match` or `If-None-Match` headers of the request. The current digest of the tag, if any, is included in the error detail.",
+								StatusCode:  http.StatusPreconditionFailed,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodePreconditionFailed,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 						},
 					},
 				},
@@ -1595,6 +1619,266 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+
+	{
+		Name:        RouteNameRepository,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/",
+		Entity:      "Repository",
+		Description: "Operations on a repository as a whole.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "DELETE",
+				Description: "Delete the repository identified by `name`, removing all its tags, manifests and layer links. Repositories nested under `name` are kept. The blobs themselves are left in place until they are removed by garbage collection.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Name",
+								Description: "The specified `name` was invalid and the delete was unable to proceed.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							{
+								Name:        "Not allowed",
+								Description: "Repository delete is not allowed because the registry is configured as a pull-through cache or `delete` has been disabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+
+	{
+		Name:        RouteNameBulkDelete,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_delete",
+		Entity:      "Bulk Delete",
+		Description: "Delete tags and manifests of a repository in bulk.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "POST",
+				Description: "Delete the listed tags, the tags matching `tagPattern` and the listed manifests of the repository `name`, along with the tags referencing the manifests. Tags are deleted before manifests. Requires `delete` access on the repository. Manifest deletion also requires `delete` to be enabled. With `dryRun`, the items that would be deleted are reported without deleting them.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+	"tags": [<tag>, ...],
+	"tagPattern": "<regular expression matching whole tags>",
+	"digests": [<digest>, ...],
+	"dryRun": <true|false>
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The outcome of the deletion of each item. The status of an item is `deleted`, `matched` for dry runs, or `failed` along with the error that occurred.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"name": <name>,
+	"dryRun": <true|false>,
+	"results": [
+		{
+			"tag": <tag>,
+			"digest": <digest>,
+			"tags": [<tag>, ...],
+			"status": "deleted"|"matched"|"failed",
+			"error": {
+				"code": <error code>,
+				"message": "<error message>",
+				"detail": ...
+			}
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Request",
+								Description: "The request body was invalid, or listed or matched too many items.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+									ErrorCodeDigestInvalid,
+									ErrorCodeRequestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							{
+								Name:        "Not allowed",
+								Description: "Bulk delete is not allowed because the registry is configured as a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+
+	{
+		Name:        RouteNameCopy,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_copy",
+		Entity:      "Manifest Copy",
+		Description: "Copy manifests between repositories without transferring their content through the client.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      "POST",
+				Description: "Copy the manifest identified by `reference` in the repository `from` into the repository `name`, linking the blobs it references and copying the manifests of indexes recursively. The manifest is tagged with `tag` or, if `reference` is a tag and `tag` is not given, with the same tag. The tag is only updated once all referenced content has been copied. Requires `pull` access on `from` and `push` access on `name`. The policies of the target repository apply as for a manifest `PUT`, including the `If-Match` and `If-None-Match` headers.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "from",
+								Type:        "query",
+								Format:      "<repository name>",
+								Regexp:      reference.NameRegexp,
+								Required:    true,
+								Description: "Name of the source repository.",
+							},
+							{
+								Name:        "reference",
+								Type:        "query",
+								Format:      "<tag>|<digest>",
+								Required:    true,
+								Description: "Tag or digest of the manifest in the source repository.",
+							},
+							{
+								Name:        "tag",
+								Type:        "query",
+								Format:      "<tag>",
+								Regexp:      reference.TagRegexp,
+								Description: "Tag of the manifest in the target repository.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The manifest has been copied into the repository `name`.",
+								StatusCode:  http.StatusCreated,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Location",
+										Type:        "url",
+										Description: "The canonical location url of the copied manifest.",
+										Format:      "<url>",
+									},
+									contentLengthZeroHeader,
+									digestHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Reference",
+								Description: "The source repository, reference or target tag were invalid, or the source manifest references blobs the source repository does not hold.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+									ErrorCodeTagInvalid,
+									ErrorCodeBlobUnknown,
+									ErrorCodeManifestBlobUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Unknown Manifest",
+								Description: "The manifest is unknown to the source repository.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							{
+								Name:        "Precondition Failed",
+								Description: "The target tag does not satisfy the `If-Match` or `If-None-Match` headers of the request.",
+								StatusCode:  http.StatusPreconditionFailed,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodePreconditionFailed,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Copy is not allowed because the registry is configured as a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
 }
 
 var routeDescriptorsMap map[string]RouteDescriptor
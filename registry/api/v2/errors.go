@@ -154,4 +154,25 @@ var (
 		to return) is not an integer, or "n" is negative.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
+
+	// ErrorCodePreconditionFailed is returned when a conditional manifest
+	// PUT does not match the current state of the tag.
+	ErrorCodePreconditionFailed = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PRECONDITION_FAILED",
+		Message: "precondition failed",
+		Description: `Returned when the If-Match or If-None-Match headers
+		of a manifest PUT do not match the digest the tag currently
+		references.`,
+		HTTPStatusCode: http.StatusPreconditionFailed,
+	})
+
+	// ErrorCodeRequestInvalid is returned when the body of a request is
+	// malformed or exceeds the limits of the registry.
+	ErrorCodeRequestInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "REQUEST_INVALID",
+		Message: "request invalid",
+		Description: `Returned when the body of a request cannot be parsed,
+		is invalid, or exceeds the limits of the registry.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
 )
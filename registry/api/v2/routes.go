@@ -12,6 +12,9 @@ const (
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
+	RouteNameRepository      = "repository"
+	RouteNameBulkDelete      = "bulk-delete"
+	RouteNameCopy            = "copy"
 	RouteNameUCD             = "ucd"
 )
 
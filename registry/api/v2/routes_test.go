@@ -162,6 +162,36 @@ func TestRouter(t *testing.T) {
 				"name": "foo/bar/manifests",
 			},
 		},
+		{
+			RouteName:  RouteNameRepository,
+			RequestURI: "/v2/foo/bar/",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameBulkDelete,
+			RequestURI: "/v2/foo/bar/_delete",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameCopy,
+			RequestURI: "/v2/foo/bar/_copy",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			// Routes of the repository take precedence over a repository
+			// named after them.
+			RouteName:  RouteNameBlobUpload,
+			RequestURI: "/v2/foo/bar/blobs/uploads/",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
 		{
 			RouteName:  RouteNameManifest,
 			RequestURI: "/v2/locahost:8080/foo/bar/baz/manifests/tag",
@@ -127,6 +127,44 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildRepositoryURL constructs a url for the named repository.
+func (ub *URLBuilder) BuildRepositoryURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameRepository)
+
+	repositoryURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return repositoryURL.String(), nil
+}
+
+// BuildBulkDeleteURL constructs a url to delete tags and manifests of the
+// named repository in bulk.
+func (ub *URLBuilder) BuildBulkDeleteURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameBulkDelete)
+
+	bulkDeleteURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return bulkDeleteURL.String(), nil
+}
+
+// BuildCopyURL constructs a url to copy a manifest into the named
+// repository.
+func (ub *URLBuilder) BuildCopyURL(name reference.Named, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameCopy)
+
+	copyURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(copyURL, values...).String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -26,6 +26,33 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 			expectedErr:  nil,
 			build:        urlBuilder.BuildBaseURL,
 		},
+		{
+			description:  "test repository url",
+			expectedPath: "/v2/foo/bar/",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildRepositoryURL(fooBarRef)
+			},
+		},
+		{
+			description:  "test bulk delete url",
+			expectedPath: "/v2/foo/bar/_delete",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildBulkDeleteURL(fooBarRef)
+			},
+		},
+		{
+			description:  "test copy url",
+			expectedPath: "/v2/foo/bar/_copy?from=foo%2Fbaz&reference=latest",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildCopyURL(fooBarRef, url.Values{
+					"from":      []string{"foo/baz"},
+					"reference": []string{"latest"},
+				})
+			},
+		},
 		{
 			description:  "test tags url",
 			expectedPath: "/v2/foo/bar/tags/list",
@@ -0,0 +1,97 @@
+// Package audit records authorization decisions and mutating API calls in
+// an append-only audit log, kept apart from the access log so that it can
+// be retained for longer.
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Types of records.
+const (
+	// TypeAuthorization records a decision of the access controller.
+	TypeAuthorization = "authorization"
+
+	// TypeMutation records a call changing the content of a repository.
+	TypeMutation = "mutation"
+)
+
+// Results of authorization decisions.
+const (
+	ResultAllowed         = "allowed"
+	ResultDenied          = "denied"
+	ResultUnauthenticated = "unauthenticated"
+	ResultError           = "error"
+)
+
+// Results of mutations.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record is a single entry of the audit log.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	RequestID string    `json:"requestID,omitempty"`
+
+	// User is the authenticated user or, for requests which were not
+	// authenticated, the user the client claimed to be.
+	User     string `json:"user,omitempty"`
+	SourceIP string `json:"sourceIP,omitempty"`
+	Method   string `json:"method,omitempty"`
+
+	// Resource is the resource accessed when it is not a repository, as
+	// type:name, such as "registry:catalog".
+	Resource   string        `json:"resource,omitempty"`
+	Repository string        `json:"repository,omitempty"`
+	Action     string        `json:"action"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+	Tag        string        `json:"tag,omitempty"`
+
+	Result string `json:"result"`
+
+	// Reason explains why access was denied or a mutation failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Sink receives audit records.
+type Sink interface {
+	// Write records r. Records are written concurrently.
+	Write(r Record) error
+
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// InitFunc is the type of an audit sink factory function and is used to
+// register the constructor for different sinks.
+type InitFunc func(options map[string]interface{}) (Sink, error)
+
+var sinks = make(map[string]InitFunc)
+
+// Register is used to register an InitFunc for an audit sink with the given
+// name.
+func Register(name string, initFunc InitFunc) error {
+	if _, exists := sinks[name]; exists {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	sinks[name] = initFunc
+
+	return nil
+}
+
+// GetSink constructs an audit sink with the given options using the named
+// backend.
+func GetSink(name string, options map[string]interface{}) (Sink, error) {
+	if initFunc, exists := sinks[name]; exists {
+		return initFunc(options)
+	}
+
+	return nil, fmt.Errorf("no audit sink registered with name: %s", name)
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	if _, err := GetSink("file", map[string]interface{}{}); err == nil {
+		t.Fatal("expected error creating file sink without path")
+	}
+
+	// Records are appended to existing logs.
+	if err := ioutil.WriteFile(path, []byte(`{"type":"mutation","action":"push","result":"success"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := GetSink("file", map[string]interface{}{"path": path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := sink.Write(Record{
+				Time:       time.Now(),
+				Type:       TypeAuthorization,
+				User:       "alice",
+				Repository: "foo/bar",
+				Action:     "pull",
+				Result:     ResultAllowed,
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 51 {
+		t.Fatalf("expected 51 records, got %d", len(records))
+	}
+	if records[0].Type != TypeMutation || records[1].User != "alice" || records[1].Result != ResultAllowed {
+		t.Errorf("unexpected records: %+v", records[:2])
+	}
+}
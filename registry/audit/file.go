@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileSink appends records to a file as JSON lines.
+type fileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink returns a sink appending records to the file at path, one JSON
+// object per line. The file is created if it does not exist and is never
+// truncated.
+func NewFileSink(path string) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSink{file: file}, nil
+}
+
+func (fs *fileSink) Write(r Record) error {
+	p, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	p = append(p, '\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// A single write of the whole line keeps records intact when other
+	// processes append to the same file.
+	_, err = fs.file.Write(p)
+	return err
+}
+
+func (fs *fileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.file.Close()
+}
+
+func newFileSink(options map[string]interface{}) (Sink, error) {
+	path, ok := options["path"].(string)
+	if !ok || path == "" {
+		return nil, fmt.Errorf(`"path" must be set for the file audit sink`)
+	}
+
+	return NewFileSink(path)
+}
+
+func init() {
+	Register("file", newFileSink)
+}
@@ -55,12 +55,22 @@ var (
 
 	// ErrAuthenticationFailure returned when authentication fails.
 	ErrAuthenticationFailure = errors.New("authentication failure")
+
+	// ErrAccessDenied is returned when an authenticated user is not permitted
+	// the requested access.
+	ErrAccessDenied = errors.New("access denied")
 )
 
 // UserInfo carries information about
 // an autenticated/authorized client.
 type UserInfo struct {
 	Name string
+
+	// Credential identifies the credential the user authenticated with, for
+	// users who may hold several that are revoked independently, such as
+	// robot credentials. It is empty when the user authenticated as
+	// themselves.
+	Credential string
 }
 
 // Resource describes a resource by type and name.
@@ -106,6 +116,37 @@ type AccessController interface {
 	Authorized(ctx context.Context, access ...Access) (context.Context, error)
 }
 
+// EndpointProvider is implemented by access controllers which serve an HTTP
+// endpoint of their own, such as a built-in token server. The registry routes
+// requests for the returned path to the handler, outside of the API.
+type EndpointProvider interface {
+	Endpoint() (path string, handler http.Handler)
+}
+
+// UserVerifier is implemented by access controllers which can check that a
+// user they authenticated earlier may still authenticate. Grants which
+// outlive a request, such as refresh tokens, are only honoured while the
+// user verifies, so that they end when the user is removed or their
+// credential revoked, and only for the access the user would be authorized
+// for.
+type UserVerifier interface {
+	// VerifyUser returns a non-nil error if user, as returned in the context
+	// of an earlier call to Authorized, can no longer authenticate, and
+	// ErrAccessDenied if the user would not be authorized for one of the
+	// access records.
+	VerifyUser(ctx context.Context, user UserInfo, access ...Access) error
+}
+
+// RepositoryFilter is implemented by access controllers which can tell from
+// their policy which repositories a user may pull, without authorizing each
+// repository. The registry uses it to restrict the catalog of callers who may
+// not list every repository.
+type RepositoryFilter interface {
+	// PullableRepositories returns a function reporting whether the user of
+	// ctx, as returned by Authorized, may pull from the named repository.
+	PullableRepositories(ctx context.Context) (func(name string) bool, error)
+}
+
 // CredentialAuthenticator is an object which is able to authenticate credentials
 type CredentialAuthenticator interface {
 	AuthenticateUser(username, password string) error
@@ -22,15 +22,79 @@ import (
 	"github.com/distribution/distribution/v3/registry/auth"
 )
 
+// DefaultReloadInterval is how often the htpasswd file is checked for
+// changes when no interval is configured.
+const DefaultReloadInterval = 5 * time.Second
+
 type accessController struct {
-	realm    string
-	path     string
+	realm     string
+	anonymous anonymousAccess
+	file      *htpasswdFile
+}
+
+// htpasswdFile holds the entries of an htpasswd file, reloaded whenever the
+// file changes.
+type htpasswdFile struct {
+	path string
+
+	mu       sync.RWMutex
 	modtime  time.Time
-	mu       sync.Mutex
+	size     int64
 	htpasswd *htpasswd
 }
 
-var _ auth.AccessController = &accessController{}
+// watchKey identifies a watched htpasswd file.
+type watchKey struct {
+	path     string
+	interval time.Duration
+}
+
+// watchedFiles holds the htpasswd files in use. Access controllers reading
+// the same file with the same reload interval share it, so that each file is
+// watched by a single goroutine however many controllers are created.
+var (
+	watchedFilesMu sync.Mutex
+	watchedFiles   = map[watchKey]*htpasswdFile{}
+)
+
+// anonymousAccess describes the access granted to requests which do not
+// carry credentials.
+type anonymousAccess struct {
+	pull    *auth.RepositoryMatcher // repositories which may be pulled
+	catalog bool                    // whether the catalog may be listed
+}
+
+// allows returns true if every one of the access records is granted to
+// anonymous requests. A request with no access records, such as the base
+// route, is always challenged, so that clients learn to send credentials.
+func (aa anonymousAccess) allows(accessRecords []auth.Access) bool {
+	if len(accessRecords) == 0 || (aa.pull == nil && !aa.catalog) {
+		return false
+	}
+
+	for _, access := range accessRecords {
+		switch {
+		case access.Type == "repository" && access.Action == "pull":
+			if !aa.pull.Match(access.Name) {
+				return false
+			}
+		case access.Type == "registry" && access.Name == "catalog":
+			if !aa.catalog {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+var (
+	_ auth.AccessController = &accessController{}
+	_ auth.UserVerifier     = &accessController{}
+	_ auth.RepositoryFilter = &accessController{}
+)
 
 func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
 	realm, present := options["realm"]
@@ -46,52 +110,238 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 	if err := createHtpasswdFile(path); err != nil {
 		return nil, err
 	}
-	return &accessController{realm: realm.(string), path: path}, nil
-}
 
-func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
-	req, err := dcontext.GetRequest(ctx)
+	anonymous, err := parseAnonymousAccess(options["anonymous"])
 	if err != nil {
 		return nil, err
 	}
 
-	username, password, ok := req.BasicAuth()
-	if !ok {
-		return nil, &challenge{
-			realm: ac.realm,
-			err:   auth.ErrInvalidCredential,
+	reloadInterval := DefaultReloadInterval
+	if opt, present := options["reloadinterval"]; present {
+		switch opt := opt.(type) {
+		case string:
+			reloadInterval, err = time.ParseDuration(opt)
+		case time.Duration:
+			reloadInterval = opt
+		default:
+			err = fmt.Errorf("not a duration")
+		}
+		if err != nil || reloadInterval <= 0 {
+			return nil, fmt.Errorf(`"reloadinterval" must be a positive duration for htpasswd access controller`)
 		}
 	}
 
-	// Dynamically parsing the latest account list
-	fstat, err := os.Stat(ac.path)
+	file, err := openHtpasswdFile(path, reloadInterval)
 	if err != nil {
 		return nil, err
 	}
 
-	lastModified := fstat.ModTime()
-	ac.mu.Lock()
-	if ac.htpasswd == nil || !ac.modtime.Equal(lastModified) {
-		ac.modtime = lastModified
+	return &accessController{realm: realm.(string), anonymous: anonymous, file: file}, nil
+}
 
-		f, err := os.Open(ac.path)
-		if err != nil {
-			ac.mu.Unlock()
-			return nil, err
+// openHtpasswdFile returns the htpasswd file at path, reloaded when it
+// changes and checked every interval. The file is loaded and watched on
+// first use and shared afterwards.
+func openHtpasswdFile(path string, interval time.Duration) (*htpasswdFile, error) {
+	key := watchKey{path: path, interval: interval}
+
+	watchedFilesMu.Lock()
+	defer watchedFilesMu.Unlock()
+
+	if file, ok := watchedFiles[key]; ok {
+		return file, nil
+	}
+
+	// Load the file up front so that a malformed file prevents the registry
+	// from starting.
+	file := &htpasswdFile{path: path}
+	if err := file.reload(); err != nil {
+		return nil, err
+	}
+
+	go file.watch(interval)
+
+	watchedFiles[key] = file
+
+	return file, nil
+}
+
+// Reload reloads every htpasswd file in use, whether or not it changed. The
+// serve command calls it when the registry receives SIGHUP.
+func Reload() {
+	watchedFilesMu.Lock()
+	files := make([]*htpasswdFile, 0, len(watchedFiles))
+	for _, file := range watchedFiles {
+		files = append(files, file)
+	}
+	watchedFilesMu.Unlock()
+
+	for _, file := range files {
+		dcontext.GetLogger(context.Background()).Infof("htpasswd: reloading %q", file.path)
+		if err := file.reload(); err != nil {
+			dcontext.GetLogger(context.Background()).Errorf("htpasswd: error reloading %q: %v", file.path, err)
+		}
+	}
+}
+
+// watch reloads the htpasswd file whenever it changes on disk, checking
+// every interval.
+func (hf *htpasswdFile) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !hf.changed() {
+			continue
+		}
+
+		if err := hf.reload(); err != nil {
+			dcontext.GetLogger(context.Background()).Errorf("htpasswd: error reloading %q: %v", hf.path, err)
+		}
+	}
+}
+
+// changed returns true if the htpasswd file has been modified since it was
+// last loaded.
+func (hf *htpasswdFile) changed() bool {
+	fstat, err := os.Stat(hf.path)
+	if err != nil {
+		return false
+	}
+
+	hf.mu.RLock()
+	defer hf.mu.RUnlock()
+
+	return !hf.modtime.Equal(fstat.ModTime()) || hf.size != fstat.Size()
+}
+
+// reload parses the htpasswd file and atomically replaces the entries in
+// use. If the file is invalid, the previous entries are kept, so that a bad
+// edit does not lock every user out.
+func (hf *htpasswdFile) reload() error {
+	f, err := os.Open(hf.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fstat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	h, err := newHTPasswd(f)
+
+	hf.mu.Lock()
+	defer hf.mu.Unlock()
+
+	// Remember the file even if it is invalid, so that it is not parsed
+	// again until it changes.
+	hf.modtime = fstat.ModTime()
+	hf.size = fstat.Size()
+
+	if err != nil {
+		if hf.htpasswd == nil {
+			return err
+		}
+
+		dcontext.GetLogger(context.Background()).Errorf("htpasswd: error parsing %q, keeping previous entries: %v", hf.path, err)
+		return nil
+	}
+
+	hf.htpasswd = h
+
+	logger := dcontext.GetLogger(context.Background())
+	logger.Infof("htpasswd: loaded %d users from %q", len(h.entries), hf.path)
+	for username, reason := range h.weakUsers() {
+		logger.Warnf("htpasswd: user %q has a weak password hash: %s", username, reason)
+	}
+
+	return nil
+}
+
+// entries returns the entries last loaded from the file.
+func (hf *htpasswdFile) entries() *htpasswd {
+	hf.mu.RLock()
+	defer hf.mu.RUnlock()
+	return hf.htpasswd
+}
+
+// parseAnonymousAccess reads the "anonymous" option, which may hold a "pull"
+// list of repository patterns and a boolean "catalog" flag.
+func parseAnonymousAccess(opt interface{}) (anonymousAccess, error) {
+	var aa anonymousAccess
+	if opt == nil {
+		return aa, nil
+	}
+
+	var params map[string]interface{}
+	switch opt := opt.(type) {
+	case map[string]interface{}:
+		params = opt
+	case map[interface{}]interface{}:
+		params = make(map[string]interface{}, len(opt))
+		for k, v := range opt {
+			params[fmt.Sprint(k)] = v
+		}
+	default:
+		return aa, fmt.Errorf(`"anonymous" must be a map for htpasswd access controller`)
+	}
+
+	if pullOpt, ok := params["pull"]; ok {
+		var patterns []string
+		switch pullOpt := pullOpt.(type) {
+		case []string:
+			patterns = pullOpt
+		case []interface{}:
+			for _, p := range pullOpt {
+				pattern, ok := p.(string)
+				if !ok {
+					return aa, fmt.Errorf(`"anonymous.pull" must be a list of strings for htpasswd access controller`)
+				}
+				patterns = append(patterns, pattern)
+			}
+		default:
+			return aa, fmt.Errorf(`"anonymous.pull" must be a list of strings for htpasswd access controller`)
 		}
-		defer f.Close()
 
-		h, err := newHTPasswd(f)
+		pull, err := auth.NewRepositoryMatcher(patterns...)
 		if err != nil {
-			ac.mu.Unlock()
-			return nil, err
+			return aa, fmt.Errorf(`invalid "anonymous.pull" pattern for htpasswd access controller: %v`, err)
 		}
-		ac.htpasswd = h
+		aa.pull = pull
 	}
-	localHTPasswd := ac.htpasswd
-	ac.mu.Unlock()
 
-	if err := localHTPasswd.authenticateUser(username, password); err != nil {
+	if catalogOpt, ok := params["catalog"]; ok {
+		catalog, ok := catalogOpt.(bool)
+		if !ok {
+			return aa, fmt.Errorf(`"anonymous.catalog" must be a boolean for htpasswd access controller`)
+		}
+		aa.catalog = catalog
+	}
+
+	return aa, nil
+}
+
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		if ac.anonymous.allows(accessRecords) {
+			return ctx, nil
+		}
+
+		return nil, &challenge{
+			realm: ac.realm,
+			err:   auth.ErrInvalidCredential,
+		}
+	}
+
+	if err := ac.file.entries().authenticateUser(username, password); err != nil {
 		dcontext.GetLogger(ctx).Errorf("error authenticating user %q: %v", username, err)
 		return nil, &challenge{
 			realm: ac.realm,
@@ -102,6 +352,26 @@ func (ac *accessController) Authorized(ctx context.Context, accessRecords ...aut
 	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
 }
 
+// VerifyUser checks that user is still present in the htpasswd file. Users
+// are authorized for any access.
+func (ac *accessController) VerifyUser(ctx context.Context, user auth.UserInfo, access ...auth.Access) error {
+	if _, ok := ac.file.entries().entries[user.Name]; !ok || user.Credential != "" {
+		return auth.ErrAuthenticationFailure
+	}
+
+	return nil
+}
+
+// PullableRepositories allows users to pull from every repository, and
+// requests without credentials from the repositories open to anonymous pulls.
+func (ac *accessController) PullableRepositories(ctx context.Context) (func(name string) bool, error) {
+	if user, ok := ctx.Value(auth.UserKey).(auth.UserInfo); ok && user.Name != "" {
+		return func(string) bool { return true }, nil
+	}
+
+	return ac.anonymous.pull.Match, nil
+}
+
 // challenge implements the auth.Challenge interface.
 type challenge struct {
 	realm string
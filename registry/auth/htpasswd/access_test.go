@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/auth"
@@ -160,3 +162,212 @@ func TestCreateHtpasswdFile(t *testing.T) {
 		t.Fatalf("failed to find default user in file %s", string(content))
 	}
 }
+
+func TestAnonymousAccess(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "htpasswd-test")
+	if err != nil {
+		t.Fatal("could not create temporary htpasswd file")
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err = tempFile.WriteString("frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n"); err != nil {
+		t.Fatal("could not write temporary htpasswd file")
+	}
+	tempFile.Close()
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"realm": "The-Shire",
+		"path":  tempFile.Name(),
+		"anonymous": map[interface{}]interface{}{
+			"pull": []interface{}{"library/*", "mirror/**"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating access controller: %v", err)
+	}
+
+	repository := func(name, action string) auth.Access {
+		return auth.Access{
+			Resource: auth.Resource{Type: "repository", Name: name},
+			Action:   action,
+		}
+	}
+	catalog := auth.Access{
+		Resource: auth.Resource{Type: "registry", Name: "catalog"},
+		Action:   "*",
+	}
+
+	for _, testcase := range []struct {
+		access     []auth.Access
+		authorized bool
+	}{
+		{nil, false},
+		{[]auth.Access{repository("library/ubuntu", "pull")}, true},
+		{[]auth.Access{repository("mirror/docker.io/library/ubuntu", "pull")}, true},
+		{[]auth.Access{repository("private/app", "pull")}, false},
+		{[]auth.Access{repository("library/ubuntu", "pull"), repository("library/ubuntu", "push")}, false},
+		{[]auth.Access{repository("library/ubuntu", "delete")}, false},
+		{[]auth.Access{repository("library/ubuntu", "pull"), repository("private/app", "pull")}, false},
+		{[]auth.Access{catalog}, false},
+	} {
+		req, err := http.NewRequest("GET", "http://example.com/v2/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = accessController.Authorized(context.WithRequest(context.Background(), req), testcase.access...)
+		if testcase.authorized && err != nil {
+			t.Errorf("expected anonymous access to %v to be allowed, got %v", testcase.access, err)
+		}
+		if !testcase.authorized {
+			if _, ok := err.(auth.Challenge); !ok {
+				t.Errorf("expected challenge for anonymous access to %v, got %v", testcase.access, err)
+			}
+		}
+	}
+
+	// Credentials are still checked when present, even for repositories
+	// which may be pulled anonymously.
+	req, err := http.NewRequest("GET", "http://example.com/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("frodo", "wrong")
+	if _, err := accessController.Authorized(context.WithRequest(context.Background(), req), repository("library/ubuntu", "pull")); err == nil {
+		t.Fatal("expected invalid credentials to be rejected")
+	}
+
+	// The catalog is governed by its own flag.
+	accessController, err = newAccessController(map[string]interface{}{
+		"realm": "The-Shire",
+		"path":  tempFile.Name(),
+		"anonymous": map[string]interface{}{
+			"catalog": true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating access controller: %v", err)
+	}
+
+	req, err = http.NewRequest("GET", "http://example.com/v2/_catalog", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := accessController.Authorized(context.WithRequest(context.Background(), req), catalog); err != nil {
+		t.Fatalf("expected anonymous catalog access to be allowed, got %v", err)
+	}
+	if _, err := accessController.Authorized(context.WithRequest(context.Background(), req), repository("library/ubuntu", "pull")); err == nil {
+		t.Fatal("expected anonymous pull to be denied without pull patterns")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+
+	writeHtpasswd := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authenticate := func(ac auth.AccessController, username, password string) error {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/v2/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(username, password)
+
+		_, err = ac.Authorized(context.WithRequest(context.Background(), req))
+		return err
+	}
+
+	// eventually waits for the watcher to pick up a change.
+	eventually := func(check func() bool) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if check() {
+				return true
+			}
+		}
+		return false
+	}
+
+	writeHtpasswd("alice:" + sha512Crypt("one", "saltsalt", 5000, false) + "\n")
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":          "test-realm",
+		"path":           path,
+		"reloadinterval": "10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := authenticate(ac, "alice", "one"); err != nil {
+		t.Fatalf("unexpected error authenticating: %v", err)
+	}
+
+	writeHtpasswd("alice:" + makeArgon2idHash("two", 1024, 1) + "\nbob:" + sha512Crypt("three", "saltsalt", 5000, false) + "\n")
+	if !eventually(func() bool { return authenticate(ac, "alice", "two") == nil }) {
+		t.Fatal("changed htpasswd file was not reloaded")
+	}
+	if err := authenticate(ac, "alice", "one"); err == nil {
+		t.Fatal("expected old password to be rejected after reload")
+	}
+
+	// An invalid file keeps the previous entries in use.
+	writeHtpasswd("this line has no separator\n")
+	if !eventually(func() bool { return !ac.(*accessController).file.changed() }) {
+		t.Fatal("invalid htpasswd file was not read")
+	}
+	if err := authenticate(ac, "bob", "three"); err != nil {
+		t.Fatalf("expected previous entries to be kept, got %v", err)
+	}
+
+	// Without polling, Reload reloads the file, as on SIGHUP.
+	writeHtpasswd("alice:" + sha512Crypt("four", "saltsalt", 5000, false) + "\n")
+
+	ac, err = newAccessController(map[string]interface{}{
+		"realm":          "test-realm",
+		"path":           path,
+		"reloadinterval": "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeHtpasswd("alice:" + sha512Crypt("five", "saltsalt", 5000, false) + "\n")
+
+	Reload()
+	if err := authenticate(ac, "alice", "five"); err != nil {
+		t.Fatalf("htpasswd file was not reloaded: %v", err)
+	}
+}
+
+func TestSharedWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := ioutil.WriteFile(path, []byte("alice:"+sha512Crypt("one", "saltsalt", 5000, false)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	newController := func(interval string) *accessController {
+		ac, err := newAccessController(map[string]interface{}{
+			"realm":          "test-realm",
+			"path":           path,
+			"reloadinterval": interval,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ac.(*accessController)
+	}
+
+	// Controllers for the same file share its watcher rather than starting
+	// one each.
+	first, second := newController("1m"), newController("1m")
+	if first.file != second.file {
+		t.Fatal("expected controllers for the same file to share it")
+	}
+
+	if other := newController("2m"); other.file == first.file {
+		t.Fatal("expected a separate watcher for a different reload interval")
+	}
+}
@@ -0,0 +1,334 @@
+package htpasswd
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hash formats accepted in htpasswd files.
+const (
+	formatBcrypt    = "bcrypt"
+	formatSHA512    = "sha512-crypt"
+	formatArgon2id  = "argon2id"
+	formatMD5       = "apr1"
+	formatSHA1      = "sha1"
+	formatUnknown   = "crypt or plain text"
+	sha512Prefix    = "$6$"
+	argon2idPrefix  = "$argon2id$"
+	sha512RoundsKey = "rounds="
+)
+
+// Parameters below which a supported hash is reported as weak. They follow
+// the OWASP password storage recommendations.
+const (
+	minBcryptCost       = bcrypt.DefaultCost
+	minSHA512Rounds     = 5000
+	minArgon2idMemoryKB = 19 * 1024 // with two passes, or the equivalent
+	minArgon2idPasses   = 2
+)
+
+var errUnsupportedHash = errors.New("unsupported password hash format")
+
+// hashFormat returns the format of a password hash.
+func hashFormat(hash []byte) string {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$2a$")), bytes.HasPrefix(hash, []byte("$2b$")), bytes.HasPrefix(hash, []byte("$2y$")):
+		return formatBcrypt
+	case bytes.HasPrefix(hash, []byte(sha512Prefix)):
+		return formatSHA512
+	case bytes.HasPrefix(hash, []byte(argon2idPrefix)):
+		return formatArgon2id
+	case bytes.HasPrefix(hash, []byte("$apr1$")):
+		return formatMD5
+	case bytes.HasPrefix(hash, []byte("{SHA}")):
+		return formatSHA1
+	}
+
+	return formatUnknown
+}
+
+// compareHashAndPassword returns nil if password matches hash.
+func compareHashAndPassword(hash []byte, password string) error {
+	switch hashFormat(hash) {
+	case formatBcrypt:
+		return bcrypt.CompareHashAndPassword(hash, []byte(password))
+	case formatSHA512:
+		return compareSHA512Crypt(string(hash), password)
+	case formatArgon2id:
+		return compareArgon2id(string(hash), password)
+	}
+
+	return errUnsupportedHash
+}
+
+// weakness describes why a password hash is weak, or returns an empty string
+// if it is not. Hashes in unsupported formats are always weak; users with
+// such hashes cannot sign in.
+func weakness(hash []byte) string {
+	format := hashFormat(hash)
+
+	switch format {
+	case formatBcrypt:
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return "malformed bcrypt hash"
+		}
+		if cost < minBcryptCost {
+			return fmt.Sprintf("bcrypt cost %d is below %d", cost, minBcryptCost)
+		}
+	case formatSHA512:
+		rounds, _, _, err := parseSHA512Crypt(string(hash))
+		if err != nil {
+			return "malformed sha512-crypt hash"
+		}
+		if rounds < minSHA512Rounds {
+			return fmt.Sprintf("sha512-crypt rounds %d is below %d", rounds, minSHA512Rounds)
+		}
+	case formatArgon2id:
+		params, _, _, err := parseArgon2id(string(hash))
+		if err != nil {
+			return "malformed argon2id hash"
+		}
+		if uint64(params.memory)*uint64(params.passes) < minArgon2idMemoryKB*minArgon2idPasses {
+			return fmt.Sprintf("argon2id memory %dKiB with %d passes is below %dKiB with %d passes", params.memory, params.passes, minArgon2idMemoryKB, minArgon2idPasses)
+		}
+	default:
+		return fmt.Sprintf("unsupported %s hash", format)
+	}
+
+	return ""
+}
+
+// argon2idParams are the cost parameters of an argon2id hash.
+type argon2idParams struct {
+	memory  uint32
+	passes  uint32
+	threads uint8
+}
+
+// parseArgon2id parses a hash in the PHC string format, as produced by the
+// argon2 reference implementation:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func parseArgon2id(encoded string) (params argon2idParams, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.passes, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters %q", parts[3])
+	}
+
+	if params.passes == 0 || params.threads == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errors.New("malformed argon2id salt")
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("malformed argon2id key")
+	}
+
+	return params, salt, key, nil
+}
+
+func compareArgon2id(encoded, password string) error {
+	params, salt, key, err := parseArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	derived := argon2.IDKey([]byte(password), salt, params.passes, params.memory, params.threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(derived, key) != 1 {
+		return errors.New("password mismatch")
+	}
+
+	return nil
+}
+
+// parseSHA512Crypt parses a hash in the SHA-crypt format, as produced by
+// crypt(3) and mkpasswd -m sha-512:
+//
+//	$6$[rounds=<N>$]<salt>$<hash>
+func parseSHA512Crypt(encoded string) (rounds int, roundsSet bool, salt string, err error) {
+	rest := strings.TrimPrefix(encoded, sha512Prefix)
+	rounds = minSHA512Rounds
+
+	if strings.HasPrefix(rest, sha512RoundsKey) {
+		i := strings.Index(rest, "$")
+		if i < 0 {
+			return 0, false, "", errors.New("malformed sha512-crypt hash")
+		}
+
+		rounds, err = strconv.Atoi(rest[len(sha512RoundsKey):i])
+		if err != nil {
+			return 0, false, "", errors.New("malformed sha512-crypt rounds")
+		}
+
+		// Out of range values are clamped, as crypt(3) does.
+		if rounds < 1000 {
+			rounds = 1000
+		} else if rounds > 999999999 {
+			rounds = 999999999
+		}
+
+		roundsSet = true
+		rest = rest[i+1:]
+	}
+
+	i := strings.LastIndex(rest, "$")
+	if i < 0 {
+		return 0, false, "", errors.New("malformed sha512-crypt hash")
+	}
+
+	salt = rest[:i]
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+
+	return rounds, roundsSet, salt, nil
+}
+
+func compareSHA512Crypt(encoded, password string) error {
+	rounds, roundsSet, salt, err := parseSHA512Crypt(encoded)
+	if err != nil {
+		return err
+	}
+
+	computed := sha512Crypt(password, salt, rounds, roundsSet)
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(encoded)) != 1 {
+		return errors.New("password mismatch")
+	}
+
+	return nil
+}
+
+// cryptAlphabet is the base64 alphabet of crypt(3).
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sha512CryptOrder is the order in which the bytes of the final digest are
+// encoded, in groups of three.
+var sha512CryptOrder = [...]int{
+	0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4, 47, 5, 26, 6, 27, 48,
+	28, 49, 7, 50, 8, 29, 9, 30, 51, 31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55,
+	13, 56, 14, 35, 15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+	62, 20, 41,
+}
+
+// sha512Crypt computes the SHA-crypt hash of password, as specified in
+// https://www.akkadia.org/drepper/SHA-crypt.txt.
+func sha512Crypt(password, salt string, rounds int, roundsSet bool) string {
+	p, s := []byte(password), []byte(salt)
+
+	alternate := sha512.New()
+	alternate.Write(p)
+	alternate.Write(s)
+	alternate.Write(p)
+	altSum := alternate.Sum(nil)
+
+	digest := sha512.New()
+	digest.Write(p)
+	digest.Write(s)
+	for i := len(p); i > 0; i -= sha512.Size {
+		if i > sha512.Size {
+			digest.Write(altSum)
+		} else {
+			digest.Write(altSum[:i])
+		}
+	}
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			digest.Write(altSum)
+		} else {
+			digest.Write(p)
+		}
+	}
+	sum := digest.Sum(nil)
+
+	dp := sha512.New()
+	for range p {
+		dp.Write(p)
+	}
+	pSeq := repeat(dp.Sum(nil), len(p))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		ds.Write(s)
+	}
+	sSeq := repeat(ds.Sum(nil), len(s))
+
+	for i := 0; i < rounds; i++ {
+		round := sha512.New()
+		if i&1 != 0 {
+			round.Write(pSeq)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write(sSeq)
+		}
+		if i%7 != 0 {
+			round.Write(pSeq)
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write(pSeq)
+		}
+		sum = round.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(sha512Prefix)
+	if roundsSet {
+		fmt.Fprintf(&b, "%s%d$", sha512RoundsKey, rounds)
+	}
+	b.WriteString(salt)
+	b.WriteByte('$')
+
+	encode := func(w uint, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for i := 0; i < len(sha512CryptOrder); i += 3 {
+		encode(uint(sum[sha512CryptOrder[i]])<<16|uint(sum[sha512CryptOrder[i+1]])<<8|uint(sum[sha512CryptOrder[i+2]]), 4)
+	}
+	encode(uint(sum[63]), 2)
+
+	return b.String()
+}
+
+// repeat returns the first n bytes of p repeated.
+func repeat(p []byte, n int) []byte {
+	seq := make([]byte, 0, n)
+	for len(seq) < n {
+		seq = append(seq, p[:min(len(p), n-len(seq))]...)
+	}
+	return seq
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
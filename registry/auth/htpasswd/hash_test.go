@@ -0,0 +1,95 @@
+package htpasswd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func makeArgon2idHash(password string, memory, passes uint32) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, passes, memory, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=1$%s$%s", argon2.Version, memory, passes,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestSHA512Crypt(t *testing.T) {
+	for _, testcase := range []struct {
+		password, hash string
+	}{
+		// From https://www.akkadia.org/drepper/SHA-crypt.txt
+		{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+		{"This is just a test", "$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+		{"we have a short salt string but not a short password", "$6$rounds=77777$short$WuQyW2YR.hBNpjjRhpYD/ifIw05xdfeEyQoMxIXbkvr0gge1a1x3yRULJ5CCaUeOxFmtlcGZelFl5CxtgfiAc0"},
+		{"a very much longer text to encrypt.  This one even stretches over morethan one line.", "$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1"},
+		// Generated with openssl passwd -6
+		{"a much longer password that exceeds sixty four bytes in length for testing!!", "$6$abcdefghijklmnop$yrlslfcx5PlrvILHW.iLD4o/1l8FXMK/fFGJ5fdB.iAI9TRz/638NAKexee4XRMPQqFD2CUco2SUtjSVfymcC/"},
+	} {
+		if err := compareHashAndPassword([]byte(testcase.hash), testcase.password); err != nil {
+			t.Errorf("%s: unexpected error: %v", testcase.hash, err)
+		}
+		if err := compareHashAndPassword([]byte(testcase.hash), testcase.password+"x"); err == nil {
+			t.Errorf("%s: expected wrong password to be rejected", testcase.hash)
+		}
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	hash := makeArgon2idHash("secret", 19*1024, 2)
+
+	if err := compareHashAndPassword([]byte(hash), "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := compareHashAndPassword([]byte(hash), "wrong"); err == nil {
+		t.Fatal("expected wrong password to be rejected")
+	}
+
+	for _, malformed := range []string{
+		"$argon2id$v=16$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$",
+	} {
+		if err := compareHashAndPassword([]byte(malformed), "secret"); err == nil {
+			t.Errorf("%s: expected error", malformed)
+		}
+	}
+}
+
+func TestWeakness(t *testing.T) {
+	weakBcrypt, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strongBcrypt, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testcase := range []struct {
+		hash string
+		weak string
+	}{
+		{string(strongBcrypt), ""},
+		{string(weakBcrypt), "bcrypt cost"},
+		{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", ""},
+		{"$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1", "sha512-crypt rounds"},
+		{makeArgon2idHash("secret", 19*1024, 2), ""},
+		{makeArgon2idHash("secret", 1024, 1), "argon2id memory"},
+		{"{SHA}5siv5c0SHx681xU6GiSx9ZQryqs=", "unsupported sha1"},
+		{"$apr1$abcdefgh$0123456789abcdefghijkl", "unsupported apr1"},
+		{"plaintext", "unsupported crypt or plain text"},
+	} {
+		reason := weakness([]byte(testcase.hash))
+		if testcase.weak == "" && reason != "" {
+			t.Errorf("%s: unexpectedly weak: %s", testcase.hash, reason)
+		} else if !strings.HasPrefix(reason, testcase.weak) {
+			t.Errorf("%s: expected weakness %q, got %q", testcase.hash, testcase.weak, reason)
+		}
+	}
+}
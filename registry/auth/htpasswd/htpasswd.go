@@ -12,7 +12,7 @@ import (
 )
 
 // htpasswd holds a path to a system .htpasswd file and the machinery to parse
-// it. Only bcrypt hash entries are supported.
+// it. Entries hashed with bcrypt, SHA-512 crypt and argon2id are supported.
 type htpasswd struct {
 	entries map[string][]byte // maps username to password byte slice.
 }
@@ -38,7 +38,7 @@ func (htpasswd *htpasswd) authenticateUser(username string, password string) err
 		return auth.ErrAuthenticationFailure
 	}
 
-	err := bcrypt.CompareHashAndPassword(credentials, []byte(password))
+	err := compareHashAndPassword(credentials, password)
 	if err != nil {
 		return auth.ErrAuthenticationFailure
 	}
@@ -46,6 +46,18 @@ func (htpasswd *htpasswd) authenticateUser(username string, password string) err
 	return nil
 }
 
+// weakUsers returns the users whose password hashes are weak or in an
+// unsupported format, mapped to the reason.
+func (htpasswd *htpasswd) weakUsers() map[string]string {
+	weak := make(map[string]string)
+	for username, credentials := range htpasswd.entries {
+		if reason := weakness(credentials); reason != "" {
+			weak[username] = reason
+		}
+	}
+	return weak
+}
+
 // parseHTPasswd parses the contents of htpasswd. This will read all the
 // entries in the file, whether or not they are needed. An error is returned
 // if a syntax errors are encountered or if the reader fails.
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoIdentitySource is returned by NewIdentitySource when the option does
+// not name exactly one identity source.
+var ErrNoIdentitySource = errors.New(`"identity" must name exactly one identity source`)
+
+// NewIdentitySource constructs the identity source named by an "identity"
+// option of an access controller, which is either the name of an access
+// controller or a map holding the name and options of an access controller.
+func NewIdentitySource(opt interface{}) (AccessController, error) {
+	switch opt := opt.(type) {
+	case string:
+		return GetAccessController(opt, map[string]interface{}{})
+	case map[string]interface{}, map[interface{}]interface{}:
+		params := ToStringMap(opt)
+		if len(params) != 1 {
+			return nil, ErrNoIdentitySource
+		}
+
+		for name, v := range params {
+			options := ToStringMap(v)
+			if options == nil {
+				options = map[string]interface{}{}
+			}
+			return GetAccessController(name, options)
+		}
+	}
+
+	return nil, ErrNoIdentitySource
+}
+
+// ToStringMap converts option maps, which are decoded from YAML with
+// interface keys, to string keyed maps. It returns nil for other values.
+func ToStringMap(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = val
+		}
+		return m
+	}
+
+	return nil
+}
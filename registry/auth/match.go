@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"regexp"
+	"strings"
+)
+
+// RepositoryMatcher matches repository names against a set of glob
+// patterns. In a pattern, "*" matches any sequence of characters within a
+// single path component, "**" matches any sequence of characters across
+// path components and "?" matches a single character other than "/".
+type RepositoryMatcher struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRepositoryMatcher compiles the glob patterns into a RepositoryMatcher.
+func NewRepositoryMatcher(patterns ...string) (*RepositoryMatcher, error) {
+	m := &RepositoryMatcher{
+		patterns: make([]*regexp.Regexp, 0, len(patterns)),
+	}
+
+	for _, pattern := range patterns {
+		re, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.patterns = append(m.patterns, re)
+	}
+
+	return m, nil
+}
+
+// Match returns true if the repository name matches any of the patterns.
+func (m *RepositoryMatcher) Match(name string) bool {
+	if m == nil {
+		return false
+	}
+
+	for _, re := range m.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compileGlob converts a repository glob pattern to an anchored regular
+// expression.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,155 @@
+// Package mtls provides an access controller which authenticates clients by
+// the certificate they present during the TLS handshake. The certificate
+// must have been verified against the client certificate authorities
+// configured for the registry's TLS listener.
+//
+// The controller only authenticates: every verified client is granted the
+// requested access. Combine it with the rbac access controller to authorize
+// requests against a policy.
+package mtls
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// Sources of the user name within the client certificate.
+const (
+	usernameCommonName = "commonname"
+	usernameSubject    = "subject"
+	usernameDNS        = "dns"
+	usernameEmail      = "email"
+	usernameURI        = "uri"
+	usernameSPIFFE     = "spiffe"
+)
+
+// ErrNoClientCertificate is returned when the request was not made with a
+// verified client certificate.
+var ErrNoClientCertificate = errors.New("no verified client certificate")
+
+type accessController struct {
+	username    string
+	trustDomain string
+}
+
+var _ auth.AccessController = &accessController{}
+
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	ac := &accessController{username: usernameCommonName}
+
+	if opt, present := options["username"]; present {
+		username, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(`"username" must be a string for mtls access controller`)
+		}
+
+		switch username {
+		case usernameCommonName, usernameSubject, usernameDNS, usernameEmail, usernameURI, usernameSPIFFE:
+			ac.username = username
+		default:
+			return nil, fmt.Errorf("unknown username source %q for mtls access controller", username)
+		}
+	}
+
+	if opt, present := options["trustdomain"]; present {
+		trustDomain, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(`"trustdomain" must be a string for mtls access controller`)
+		}
+		ac.trustDomain = trustDomain
+	}
+
+	if ac.trustDomain != "" && ac.username != usernameSPIFFE {
+		return nil, fmt.Errorf(`"trustdomain" requires the spiffe username source for mtls access controller`)
+	}
+
+	return ac, nil
+}
+
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, challenge{err: ErrNoClientCertificate}
+	}
+
+	name, err := ac.userName(req.TLS.VerifiedChains[0][0])
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error identifying client certificate: %v", err)
+		return nil, challenge{err: auth.ErrAuthenticationFailure}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: name}), nil
+}
+
+// userName extracts the user name from the leaf client certificate.
+func (ac *accessController) userName(cert *x509.Certificate) (string, error) {
+	var name string
+
+	switch ac.username {
+	case usernameCommonName:
+		name = cert.Subject.CommonName
+	case usernameSubject:
+		name = cert.Subject.String()
+	case usernameDNS:
+		if len(cert.DNSNames) > 0 {
+			name = cert.DNSNames[0]
+		}
+	case usernameEmail:
+		if len(cert.EmailAddresses) > 0 {
+			name = cert.EmailAddresses[0]
+		}
+	case usernameURI:
+		if len(cert.URIs) > 0 {
+			name = cert.URIs[0].String()
+		}
+	case usernameSPIFFE:
+		// A SPIFFE verifiable identity document carries exactly one URI
+		// SAN, the SPIFFE ID.
+		if len(cert.URIs) != 1 || cert.URIs[0].Scheme != "spiffe" || cert.URIs[0].Host == "" {
+			return "", errors.New("certificate does not carry a SPIFFE ID")
+		}
+
+		id := cert.URIs[0]
+		if ac.trustDomain != "" && !strings.EqualFold(id.Host, ac.trustDomain) {
+			return "", fmt.Errorf("SPIFFE ID %q is not in trust domain %q", id, ac.trustDomain)
+		}
+		name = id.String()
+	}
+
+	if name == "" {
+		return "", fmt.Errorf("certificate has no %s", ac.username)
+	}
+
+	return name, nil
+}
+
+// challenge implements the auth.Challenge interface. There is no header to
+// set: clients authenticate by presenting a certificate during the TLS
+// handshake.
+type challenge struct {
+	err error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders is a no-op for client certificate authentication.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("client certificate authentication: %s", ch.err)
+}
+
+func init() {
+	auth.Register("mtls", auth.InitFunc(newAccessController))
+}
@@ -0,0 +1,192 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// makeCert creates a certificate from template, signed by parent. If parent
+// is nil, the certificate is self-signed.
+func makeCert(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestUserName(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://example.org/ci/runner")
+	otherID, _ := url.Parse("spiffe://other.org/ci/runner")
+	httpsURI, _ := url.Parse("https://example.org/ci")
+
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "ci-runner", Organization: []string{"Example"}},
+		DNSNames:       []string{"runner.example.org"},
+		EmailAddresses: []string{"ci@example.org"},
+		URIs:           []*url.URL{spiffeID},
+	}
+
+	for _, testcase := range []struct {
+		options  map[string]interface{}
+		cert     *x509.Certificate
+		expected string
+	}{
+		{map[string]interface{}{}, cert, "ci-runner"},
+		{map[string]interface{}{"username": "subject"}, cert, "CN=ci-runner,O=Example"},
+		{map[string]interface{}{"username": "dns"}, cert, "runner.example.org"},
+		{map[string]interface{}{"username": "email"}, cert, "ci@example.org"},
+		{map[string]interface{}{"username": "uri"}, cert, "spiffe://example.org/ci/runner"},
+		{map[string]interface{}{"username": "spiffe"}, cert, "spiffe://example.org/ci/runner"},
+		{map[string]interface{}{"username": "spiffe", "trustdomain": "example.org"}, cert, "spiffe://example.org/ci/runner"},
+		{map[string]interface{}{"username": "spiffe", "trustdomain": "example.org"}, &x509.Certificate{URIs: []*url.URL{otherID}}, ""},
+		{map[string]interface{}{"username": "spiffe"}, &x509.Certificate{URIs: []*url.URL{httpsURI}}, ""},
+		{map[string]interface{}{"username": "spiffe"}, &x509.Certificate{URIs: []*url.URL{spiffeID, otherID}}, ""},
+		{map[string]interface{}{"username": "dns"}, &x509.Certificate{}, ""},
+	} {
+		ac, err := newAccessController(testcase.options)
+		if err != nil {
+			t.Fatalf("unexpected error creating access controller with %v: %v", testcase.options, err)
+		}
+
+		name, err := ac.(*accessController).userName(testcase.cert)
+		if testcase.expected == "" {
+			if err == nil {
+				t.Errorf("%v: expected error, got user name %q", testcase.options, name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", testcase.options, err)
+		} else if name != testcase.expected {
+			t.Errorf("%v: expected user name %q, got %q", testcase.options, testcase.expected, name)
+		}
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"username": "serial"},
+		{"username": 1},
+		{"trustdomain": "example.org"},
+	} {
+		if _, err := newAccessController(invalid); err == nil {
+			t.Errorf("expected error creating access controller with %v", invalid)
+		}
+	}
+}
+
+func TestAccessController(t *testing.T) {
+	caCert, caKey := makeCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	serverCert, serverKey := makeCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, caKey)
+
+	clientCert, clientKey := makeCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "ci-runner"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, caKey)
+
+	accessController, err := newAccessController(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authCtx, err := accessController.Authorized(context.WithRequest(context.Background(), r))
+		if err != nil {
+			if _, ok := err.(auth.Challenge); !ok {
+				t.Errorf("expected challenge, got %v", err)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("X-User", context.GetStringValue(authCtx, auth.UserNameKey))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    roots,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      roots,
+					Certificates: certs,
+					ServerName:   "localhost",
+				},
+			},
+		}
+	}
+
+	resp, err := newClient(tls.Certificate{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status with client certificate: %v", resp.Status)
+	}
+	if user := resp.Header.Get("X-User"); user != "ci-runner" {
+		t.Fatalf("unexpected user from client certificate: %q", user)
+	}
+
+	resp, err = newClient().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status without client certificate: %v", resp.Status)
+	}
+}
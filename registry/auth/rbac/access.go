@@ -0,0 +1,246 @@
+// Package rbac provides an access controller which authorizes requests with
+// a role based policy file. Users are authenticated by an identity source,
+// another registered access controller such as htpasswd or mtls.
+//
+// The policy file is reloaded whenever it changes on disk.
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+type accessController struct {
+	identity auth.AccessController
+	policy   *PolicyFile
+}
+
+var (
+	_ auth.AccessController = &accessController{}
+	_ auth.RepositoryFilter = &accessController{}
+)
+
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	pathOpt, present := options["policy"]
+	path, ok := pathOpt.(string)
+	if !present || !ok {
+		return nil, fmt.Errorf(`"policy" must be set for rbac access controller`)
+	}
+
+	identity, err := auth.NewIdentitySource(options["identity"])
+	if err == auth.ErrNoIdentitySource {
+		return nil, fmt.Errorf("%v for rbac access controller", err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	pol, err := NewPolicyFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &accessController{identity: identity, policy: pol}, nil
+}
+
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	ctx, err := ac.identity.Authorized(ctx, accessRecords...)
+	if err != nil {
+		return nil, err
+	}
+
+	// An identity source may grant access to requests without credentials,
+	// such as anonymous pulls, which the policy must also grant to anonymous
+	// requests.
+	user, ok := ctx.Value(auth.UserKey).(auth.UserInfo)
+	if !ok || user.Name == "" {
+		return ac.authorizeAnonymous(ctx, accessRecords)
+	}
+
+	for _, access := range accessRecords {
+		allowed, err := ac.policy.Allowed(user.Name, access)
+		if err != nil {
+			return nil, err
+		}
+
+		if !allowed {
+			dcontext.GetLogger(ctx).Warnf("rbac: user %q denied %s access to %s %q", user.Name, access.Action, access.Type, access.Name)
+			return nil, auth.ErrAccessDenied
+		}
+	}
+
+	return ctx, nil
+}
+
+// authorizeAnonymous checks the access of a request without credentials
+// against the anonymous rules of the policy. Denied requests are challenged
+// by the identity source, so that clients send credentials.
+func (ac *accessController) authorizeAnonymous(ctx context.Context, accessRecords []auth.Access) (context.Context, error) {
+	for _, access := range accessRecords {
+		allowed, err := ac.policy.Allowed("", access)
+		if err != nil {
+			return nil, err
+		}
+
+		if !allowed {
+			dcontext.GetLogger(ctx).Infof("rbac: anonymous %s access to %s %q denied", access.Action, access.Type, access.Name)
+
+			// Without access records, the identity source only
+			// authenticates the request, which fails without credentials.
+			if _, err := ac.identity.Authorized(ctx); err != nil {
+				return nil, err
+			}
+			return nil, auth.ErrAccessDenied
+		}
+	}
+
+	return ctx, nil
+}
+
+// VerifyUser checks that user may still authenticate with the identity
+// source, and that both the identity source and the policy allow the access.
+func (ac *accessController) VerifyUser(ctx context.Context, user auth.UserInfo, access ...auth.Access) error {
+	verifier, ok := ac.identity.(auth.UserVerifier)
+	if !ok {
+		return fmt.Errorf("rbac: identity source cannot verify users")
+	}
+
+	if err := verifier.VerifyUser(ctx, user, access...); err != nil {
+		return err
+	}
+
+	for _, a := range access {
+		allowed, err := ac.policy.Allowed(user.Name, a)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return auth.ErrAccessDenied
+		}
+	}
+
+	return nil
+}
+
+// PullableRepositories resolves the repositories the user may pull from the
+// policy. Requests without a user, which the identity source allowed, may
+// pull the repositories both the identity source and the anonymous rules of
+// the policy allow.
+func (ac *accessController) PullableRepositories(ctx context.Context) (func(name string) bool, error) {
+	user, ok := ctx.Value(auth.UserKey).(auth.UserInfo)
+	if !ok || user.Name == "" {
+		filter, ok := ac.identity.(auth.RepositoryFilter)
+		if !ok {
+			return nil, fmt.Errorf("rbac: identity source cannot filter repositories")
+		}
+		identityPullable, err := filter.PullableRepositories(ctx)
+		if err != nil {
+			return nil, err
+		}
+		policyPullable, err := ac.policy.Pullable("")
+		if err != nil {
+			return nil, err
+		}
+
+		return func(name string) bool {
+			return identityPullable(name) && policyPullable(name)
+		}, nil
+	}
+
+	return ac.policy.Pullable(user.Name)
+}
+
+// PolicyFile is an authorization policy read from a file. The file is
+// reloaded whenever it is modified, so policy changes take effect without a
+// restart.
+type PolicyFile struct {
+	path    string
+	mu      sync.Mutex
+	modtime time.Time
+	policy  *policy
+}
+
+// NewPolicyFile loads the policy at path. The policy is read up front so that
+// configuration errors prevent the registry from starting.
+func NewPolicyFile(path string) (*PolicyFile, error) {
+	pf := &PolicyFile{path: path}
+	if _, err := pf.current(); err != nil {
+		return nil, err
+	}
+
+	return pf, nil
+}
+
+// Allowed returns true if the policy grants the access to the user.
+func (pf *PolicyFile) Allowed(user string, access auth.Access) (bool, error) {
+	pol, err := pf.current()
+	if err != nil {
+		return false, err
+	}
+
+	return pol.allowed(user, access), nil
+}
+
+// Pullable returns a function reporting whether the policy grants the user
+// pull access to a repository.
+func (pf *PolicyFile) Pullable(user string) (func(name string) bool, error) {
+	pol, err := pf.current()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(name string) bool {
+		return pol.allowed(user, auth.Access{
+			Resource: auth.Resource{Type: "repository", Name: name},
+			Action:   actionPull,
+		})
+	}, nil
+}
+
+// current returns the policy, reloading it if the file has been modified
+// since it was last read.
+func (pf *PolicyFile) current() (*policy, error) {
+	fstat, err := os.Stat(pf.path)
+	if err != nil {
+		return nil, err
+	}
+
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	lastModified := fstat.ModTime()
+	if pf.policy == nil || !pf.modtime.Equal(lastModified) {
+		f, err := os.Open(pf.path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		pol, err := parsePolicy(f)
+		if err != nil {
+			if pf.policy == nil {
+				return nil, err
+			}
+
+			// Keep enforcing the last valid policy rather than locking
+			// everyone out because of a bad edit.
+			pf.modtime = lastModified
+			dcontext.GetLogger(context.Background()).Errorf("error reloading rbac policy %q, keeping previous policy: %v", pf.path, err)
+			return pf.policy, nil
+		}
+
+		pf.modtime = lastModified
+		pf.policy = pol
+	}
+
+	return pf.policy, nil
+}
+
+func init() {
+	auth.Register("rbac", auth.InitFunc(newAccessController))
+}
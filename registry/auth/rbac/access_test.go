@@ -0,0 +1,214 @@
+package rbac
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccessController(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var htpasswd string
+	for _, user := range []string{"alice", "carol"} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(user+"-secret"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		htpasswd += fmt.Sprintf("%s:%s\n", user, hash)
+	}
+
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	if err := ioutil.WriteFile(htpasswdPath, []byte(htpasswd), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policyPath := filepath.Join(dir, "policy.yml")
+	if err := ioutil.WriteFile(policyPath, []byte(testPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"policy": policyPath,
+		"identity": map[interface{}]interface{}{
+			"htpasswd": map[interface{}]interface{}{
+				"realm": "test-realm",
+				"path":  htpasswdPath,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	authorize := func(user, password string, access ...auth.Access) error {
+		req, err := http.NewRequest("GET", "http://example.com/v2/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+
+		authCtx, err := accessController.Authorized(context.WithRequest(context.Background(), req), access...)
+		if err != nil {
+			return err
+		}
+
+		if name, _ := authCtx.Value(auth.UserNameKey).(string); name != user {
+			t.Fatalf("expected user %q in context, got %q", user, name)
+		}
+		return nil
+	}
+
+	if err := authorize("", ""); err == nil {
+		t.Fatal("expected challenge without credentials")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected challenge without credentials, got %v", err)
+	}
+
+	if err := authorize("alice", "wrong", repositoryAccess("dev/app", "pull")); err == nil {
+		t.Fatal("expected challenge with invalid password")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected challenge with invalid password, got %v", err)
+	}
+
+	if err := authorize("alice", "alice-secret"); err != nil {
+		t.Fatalf("unexpected error authenticating without access records: %v", err)
+	}
+
+	if err := authorize("alice", "alice-secret", repositoryAccess("dev/app", "pull"), repositoryAccess("dev/app", "push")); err != nil {
+		t.Fatalf("unexpected error authorizing push: %v", err)
+	}
+
+	if err := authorize("alice", "alice-secret", repositoryAccess("prod/app", "pull")); err != auth.ErrAccessDenied {
+		t.Fatalf("expected access denied, got %v", err)
+	}
+
+	// Grant alice access to prod and check that the policy is reloaded.
+	updated := testPolicy + "  - users: [alice]\n    repositories: [\"prod/*\"]\n    actions: [pull]\n"
+	if err := ioutil.WriteFile(policyPath, []byte(updated), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(policyPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := authorize("alice", "alice-secret", repositoryAccess("prod/app", "pull")); err != nil {
+		t.Fatalf("expected reloaded policy to grant access, got %v", err)
+	}
+
+	// An invalid policy update keeps the previous policy in force.
+	if err := ioutil.WriteFile(policyPath, []byte("rules: ["), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	if err := os.Chtimes(policyPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := authorize("alice", "alice-secret", repositoryAccess("prod/app", "pull")); err != nil {
+		t.Fatalf("expected previous policy to remain in force, got %v", err)
+	}
+	// Requests the identity source allows without credentials are checked
+	// against the anonymous rules of the policy.
+	if err := ioutil.WriteFile(policyPath, []byte(testPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	if err := os.Chtimes(policyPath, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	accessController, err = newAccessController(map[string]interface{}{
+		"policy": policyPath,
+		"identity": map[interface{}]interface{}{
+			"htpasswd": map[interface{}]interface{}{
+				"realm": "test-realm",
+				"path":  htpasswdPath,
+				"anonymous": map[interface{}]interface{}{
+					"pull": []interface{}{"library/*", "public/*"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+	if err := authorize("", "", repositoryAccess("library/ubuntu", "pull")); err != nil {
+		t.Fatalf("unexpected error authorizing anonymous pull: %v", err)
+	}
+	for _, access := range [][]auth.Access{
+		nil,
+		{repositoryAccess("public/base", "pull")},
+		{repositoryAccess("private/app", "pull")},
+	} {
+		if _, ok := authorize("", "", access...).(auth.Challenge); !ok {
+			t.Errorf("expected challenge for anonymous access to %v", access)
+		}
+	}
+
+	pullable, err := accessController.(auth.RepositoryFilter).PullableRepositories(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{"library/ubuntu": true, "public/base": false, "private/app": false} {
+		if pullable(name) != expected {
+			t.Errorf("%s: expected anonymous pullable=%v", name, expected)
+		}
+	}
+}
+
+func TestNewAccessControllerOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	identity := map[string]interface{}{
+		"htpasswd": map[string]interface{}{
+			"realm": "test-realm",
+			"path":  filepath.Join(dir, "htpasswd"),
+		},
+	}
+
+	if _, err := newAccessController(map[string]interface{}{"identity": identity}); err == nil {
+		t.Fatal("expected error without policy option")
+	}
+
+	if _, err := newAccessController(map[string]interface{}{"policy": filepath.Join(dir, "missing.yml"), "identity": identity}); err == nil {
+		t.Fatal("expected error with missing policy file")
+	}
+
+	policyPath := filepath.Join(dir, "policy.yml")
+	if err := ioutil.WriteFile(policyPath, []byte(testPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newAccessController(map[string]interface{}{"policy": policyPath}); err == nil {
+		t.Fatal("expected error without identity option")
+	}
+
+	if _, err := newAccessController(map[string]interface{}{"policy": policyPath, "identity": "unregistered"}); err == nil {
+		t.Fatal("expected error with unknown identity source")
+	}
+
+	if _, err := newAccessController(map[string]interface{}{"policy": policyPath, "identity": identity}); err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+}
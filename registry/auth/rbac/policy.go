@@ -0,0 +1,187 @@
+package rbac
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"gopkg.in/yaml.v2"
+)
+
+// Actions which may be granted by a policy rule.
+const (
+	actionPull   = "pull"
+	actionPush   = "push"
+	actionDelete = "delete"
+	actionAll    = "*"
+)
+
+// wildcard matches any user or group in a rule.
+const wildcard = "*"
+
+// policyFile is the on-disk representation of an authorization policy.
+type policyFile struct {
+	// Groups maps a group name to its member users.
+	Groups map[string][]string `yaml:"groups,omitempty"`
+
+	// Rules grant access to repositories. A request is allowed if any rule
+	// grants every access it requires.
+	Rules []ruleSpec `yaml:"rules"`
+}
+
+// ruleSpec grants actions on a set of repositories to users and groups.
+type ruleSpec struct {
+	// Users lists the user names the rule applies to. "*" matches any
+	// authenticated user.
+	Users []string `yaml:"users,omitempty"`
+
+	// Groups lists the groups the rule applies to.
+	Groups []string `yaml:"groups,omitempty"`
+
+	// Anonymous applies the rule to requests without credentials which the
+	// identity source allows.
+	Anonymous bool `yaml:"anonymous,omitempty"`
+
+	// Repositories lists glob patterns of repository names. "*" matches a
+	// single path component and "**" matches any number of them.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Actions lists the granted actions: pull, push, delete or "*".
+	Actions []string `yaml:"actions,omitempty"`
+
+	// Catalog grants access to the repository catalog.
+	Catalog bool `yaml:"catalog,omitempty"`
+}
+
+// policy is a parsed authorization policy, ready for evaluation.
+type policy struct {
+	memberships map[string][]string // maps a user to the groups it belongs to
+	rules       []rule
+}
+
+type rule struct {
+	users        map[string]bool
+	groups       map[string]bool
+	anonymous    bool
+	repositories *auth.RepositoryMatcher
+	actions      map[string]bool
+	catalog      bool
+}
+
+// parsePolicy reads a YAML policy from rd.
+func parsePolicy(rd io.Reader) (*policy, error) {
+	p, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	var pf policyFile
+	if err := yaml.UnmarshalStrict(p, &pf); err != nil {
+		return nil, fmt.Errorf("rbac: invalid policy: %v", err)
+	}
+
+	pol := &policy{
+		memberships: make(map[string][]string),
+		rules:       make([]rule, 0, len(pf.Rules)),
+	}
+
+	for group, members := range pf.Groups {
+		for _, member := range members {
+			pol.memberships[member] = append(pol.memberships[member], group)
+		}
+	}
+
+	for i, spec := range pf.Rules {
+		r := rule{
+			users:     toSet(spec.Users),
+			groups:    toSet(spec.Groups),
+			anonymous: spec.Anonymous,
+			actions:   toSet(spec.Actions),
+			catalog:   spec.Catalog,
+		}
+
+		if len(r.users) == 0 && len(r.groups) == 0 && !r.anonymous {
+			return nil, fmt.Errorf("rbac: rule %d applies to no users or groups", i)
+		}
+
+		for action := range r.actions {
+			switch action {
+			case actionPull, actionPush, actionDelete, actionAll:
+			default:
+				return nil, fmt.Errorf("rbac: rule %d has unknown action %q", i, action)
+			}
+		}
+
+		repositories, err := auth.NewRepositoryMatcher(spec.Repositories...)
+		if err != nil {
+			return nil, fmt.Errorf("rbac: rule %d has invalid repository pattern: %v", i, err)
+		}
+		r.repositories = repositories
+
+		pol.rules = append(pol.rules, r)
+	}
+
+	return pol, nil
+}
+
+// groups returns the groups the user is a member of.
+func (pol *policy) groups(user string) []string {
+	return pol.memberships[user]
+}
+
+// allowed returns true if the policy grants the access to the user, or to
+// anonymous requests if user is empty.
+func (pol *policy) allowed(user string, access auth.Access) bool {
+	for _, r := range pol.rules {
+		if r.appliesTo(user, pol.groups(user)) && r.grants(access) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appliesTo returns true if the rule names the user or one of its groups.
+// Anonymous requests, with an empty user, only match anonymous rules.
+func (r rule) appliesTo(user string, groups []string) bool {
+	if user == "" {
+		return r.anonymous
+	}
+
+	if r.users[wildcard] || r.users[user] {
+		return true
+	}
+
+	for _, group := range groups {
+		if r.groups[group] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// grants returns true if the rule allows the access.
+func (r rule) grants(access auth.Access) bool {
+	switch access.Type {
+	case "registry":
+		return access.Name == "catalog" && r.catalog
+	case "repository":
+		if !r.actions[actionAll] && !r.actions[access.Action] {
+			return false
+		}
+
+		return r.repositories.Match(access.Name)
+	}
+
+	return false
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
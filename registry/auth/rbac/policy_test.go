@@ -0,0 +1,90 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+const testPolicy = `
+groups:
+  developers: [alice, bob]
+  release: [carol]
+rules:
+  - users: [admin]
+    repositories: ["**"]
+    actions: ["*"]
+    catalog: true
+  - groups: [developers]
+    repositories: ["dev/*", "library/**"]
+    actions: [pull, push]
+  - groups: [release]
+    repositories: ["prod/*"]
+    actions: [pull, push, delete]
+  - users: ["*"]
+    repositories: ["public/*"]
+    actions: [pull]
+  - anonymous: true
+    repositories: ["library/*"]
+    actions: [pull]
+`
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{
+		Resource: auth.Resource{Type: "repository", Name: name},
+		Action:   action,
+	}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	pol, err := parsePolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error parsing policy: %v", err)
+	}
+
+	catalog := auth.Access{
+		Resource: auth.Resource{Type: "registry", Name: "catalog"},
+		Action:   "*",
+	}
+
+	for _, testcase := range []struct {
+		user     string
+		access   auth.Access
+		expected bool
+	}{
+		{"admin", repositoryAccess("any/deeply/nested", "delete"), true},
+		{"admin", catalog, true},
+		{"alice", catalog, false},
+		{"alice", repositoryAccess("dev/app", "push"), true},
+		{"alice", repositoryAccess("dev/app/nested", "pull"), false},
+		{"alice", repositoryAccess("library/a/b", "pull"), true},
+		{"alice", repositoryAccess("dev/app", "delete"), false},
+		{"alice", repositoryAccess("prod/app", "pull"), false},
+		{"carol", repositoryAccess("prod/app", "delete"), true},
+		{"carol", repositoryAccess("dev/app", "pull"), false},
+		{"mallory", repositoryAccess("public/base", "pull"), true},
+		{"mallory", repositoryAccess("public/base", "push"), false},
+		{"mallory", repositoryAccess("publicity", "pull"), false},
+		{"", repositoryAccess("library/ubuntu", "pull"), true},
+		{"", repositoryAccess("library/ubuntu", "push"), false},
+		{"", repositoryAccess("public/base", "pull"), false},
+	} {
+		if allowed := pol.allowed(testcase.user, testcase.access); allowed != testcase.expected {
+			t.Errorf("%s %s %s: expected allowed=%v, got %v", testcase.user, testcase.access.Action, testcase.access.Name, testcase.expected, allowed)
+		}
+	}
+}
+
+func TestPolicyInvalid(t *testing.T) {
+	for _, invalid := range []string{
+		"rules:\n  - users: [a]\n    repositories: [x]\n    actions: [write]\n",
+		"rules:\n  - repositories: [x]\n    actions: [pull]\n",
+		"rules:\n  - users: [a]\n    repos: [x]\n",
+		"rules: [",
+	} {
+		if _, err := parsePolicy(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected error parsing policy %q", invalid)
+		}
+	}
+}
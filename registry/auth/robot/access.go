@@ -0,0 +1,237 @@
+// Package robot provides an access controller for robot accounts and
+// personal access tokens: credentials which are scoped to repositories and
+// actions, may expire and can be revoked at any time. Clients present them as
+// basic authentication, with the credential secret as the password.
+//
+// Credentials are kept in a Store, placed on the request context with
+// WithStore and managed through the admin API served by AdminHandler. Other
+// users may be authenticated by an identity source, another registered access
+// controller such as htpasswd.
+package robot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/docker/go-metrics"
+)
+
+// lastUsedResolution limits how often the last-used time of a credential is
+// written, so that busy credentials do not cause a write per request.
+const lastUsedResolution = time.Minute
+
+// Reasons a credential was rejected, reported as metric labels.
+const (
+	failureUnknown  = "unknown"
+	failureMismatch = "mismatch"
+	failureExpired  = "expired"
+	failureDenied   = "denied"
+)
+
+// failuresCounter counts rejected uses of credentials by reason.
+var failuresCounter = prometheus.AuthNamespace.NewLabeledCounter("credential_failures", "The number of rejected uses of robot credentials", "reason")
+
+type accessController struct {
+	realm    string
+	identity auth.AccessController
+	store    Store // overrides the store on the request context, for tests
+}
+
+var (
+	_ auth.AccessController = &accessController{}
+	_ auth.UserVerifier     = &accessController{}
+	_ auth.RepositoryFilter = &accessController{}
+)
+
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	realm, ok := options["realm"].(string)
+	if !ok {
+		return nil, fmt.Errorf(`"realm" must be set for robot access controller`)
+	}
+
+	ac := &accessController{realm: realm}
+
+	if opt, present := options["identity"]; present {
+		identity, err := auth.NewIdentitySource(opt)
+		if err == auth.ErrNoIdentitySource {
+			return nil, fmt.Errorf("%v for robot access controller", err)
+		} else if err != nil {
+			return nil, err
+		}
+		ac.identity = identity
+	}
+
+	return ac, nil
+}
+
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	username, password, ok := req.BasicAuth()
+	id, key, isSecret := parseSecret(password)
+	if !ok || !isSecret {
+		if ac.identity != nil {
+			return ac.identity.Authorized(ctx, accessRecords...)
+		}
+
+		return nil, &challenge{realm: ac.realm, err: auth.ErrInvalidCredential}
+	}
+
+	store, err := ac.credentialStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := store.Get(ctx, id)
+	if err != nil {
+		if err != ErrCredentialUnknown {
+			return nil, err
+		}
+
+		return nil, ac.reject(ctx, failureUnknown, username, id)
+	}
+
+	now := time.Now()
+
+	switch {
+	case cred.Username != username || !cred.verifySecret(key):
+		return nil, ac.reject(ctx, failureMismatch, username, id)
+	case cred.Expired(now):
+		return nil, ac.reject(ctx, failureExpired, username, id)
+	}
+
+	for _, access := range accessRecords {
+		if !cred.Allows(access) {
+			failuresCounter.WithValues(failureDenied).Inc(1)
+			dcontext.GetLogger(ctx).Warnf("robot: credential %s of %q denied %s access to %s %q", id, username, access.Action, access.Type, access.Name)
+			return nil, auth.ErrAccessDenied
+		}
+	}
+
+	if cred.LastUsedAt == nil || now.Sub(*cred.LastUsedAt) >= lastUsedResolution {
+		if err := store.Touch(ctx, id, now); err != nil {
+			dcontext.GetLogger(ctx).Errorf("robot: error recording use of credential %s: %v", id, err)
+		}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: cred.Username, Credential: id}), nil
+}
+
+// VerifyUser checks that the credential user authenticated with has not
+// since been revoked or expired, and that its scopes allow the access. Users
+// authenticated by the identity source are verified by it.
+func (ac *accessController) VerifyUser(ctx context.Context, user auth.UserInfo, access ...auth.Access) error {
+	if user.Credential == "" {
+		verifier, ok := ac.identity.(auth.UserVerifier)
+		if !ok {
+			return fmt.Errorf("robot: identity source cannot verify users")
+		}
+
+		return verifier.VerifyUser(ctx, user, access...)
+	}
+
+	store, err := ac.credentialStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	cred, err := store.Get(ctx, user.Credential)
+	if err != nil {
+		return err
+	}
+
+	if cred.Username != user.Name || cred.Expired(time.Now()) {
+		return auth.ErrAuthenticationFailure
+	}
+
+	for _, a := range access {
+		if !cred.Allows(a) {
+			return auth.ErrAccessDenied
+		}
+	}
+
+	return nil
+}
+
+// PullableRepositories resolves the repositories the user may pull from the
+// scopes of the credential they authenticated with. Other users are filtered
+// by the identity source.
+func (ac *accessController) PullableRepositories(ctx context.Context) (func(name string) bool, error) {
+	user, _ := ctx.Value(auth.UserKey).(auth.UserInfo)
+	if user.Credential == "" {
+		filter, ok := ac.identity.(auth.RepositoryFilter)
+		if !ok {
+			return nil, fmt.Errorf("robot: identity source cannot filter repositories")
+		}
+		return filter.PullableRepositories(ctx)
+	}
+
+	store, err := ac.credentialStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := store.Get(ctx, user.Credential)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(name string) bool {
+		return cred.Allows(auth.Access{
+			Resource: auth.Resource{Type: "repository", Name: name},
+			Action:   "pull",
+		})
+	}, nil
+}
+
+// credentialStore returns the store credentials are looked up in.
+func (ac *accessController) credentialStore(ctx context.Context) (Store, error) {
+	if ac.store != nil {
+		return ac.store, nil
+	}
+
+	if store := storeFromContext(ctx); store != nil {
+		return store, nil
+	}
+
+	return nil, fmt.Errorf("robot: no credential store configured")
+}
+
+// reject records a failed use of a credential and returns the challenge to
+// respond with.
+func (ac *accessController) reject(ctx context.Context, reason, username, id string) error {
+	failuresCounter.WithValues(reason).Inc(1)
+	dcontext.GetLogger(ctx).Errorf("robot: rejected credential %s for %q: %s", id, username, reason)
+
+	return &challenge{realm: ac.realm, err: auth.ErrAuthenticationFailure}
+}
+
+// challenge implements the auth.Challenge interface.
+type challenge struct {
+	realm string
+	err   error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders sets the basic challenge header on the response.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ch.realm))
+}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("basic authentication challenge for realm %q: %s", ch.realm, ch.err)
+}
+
+func init() {
+	auth.Register("robot", auth.InitFunc(newAccessController))
+	metrics.Register(prometheus.AuthNamespace)
+}
@@ -0,0 +1,247 @@
+package robot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func pullPush(name string) []auth.Access {
+	return []auth.Access{
+		{Resource: auth.Resource{Type: "repository", Name: name}, Action: "pull"},
+		{Resource: auth.Resource{Type: "repository", Name: name}, Action: "push"},
+	}
+}
+
+func TestAccessController(t *testing.T) {
+	store := NewDriverStore(inmemory.New(), "/credentials")
+	ctx := context.Background()
+
+	ac, err := newAccessController(map[string]interface{}{"realm": "test-realm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac.(*accessController).store = store
+
+	scopes := []Scope{{Repositories: []string{"ci/**"}, Actions: []string{"pull", "push"}}}
+
+	cred, secret, err := NewCredential(KindRobot, "ci-bot", scopes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, cred); err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	expired, expiredSecret, err := NewCredential(KindPersonal, "alice", scopes, &past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	authorize := func(user, password string, access ...auth.Access) (string, error) {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/v2/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != "" || password != "" {
+			req.SetBasicAuth(user, password)
+		}
+
+		authCtx, err := ac.Authorized(context.WithRequest(ctx, req), access...)
+		if err != nil {
+			return "", err
+		}
+		return context.GetStringValue(authCtx, auth.UserNameKey), nil
+	}
+
+	user, err := authorize("ci-bot", secret, pullPush("ci/app")...)
+	if err != nil {
+		t.Fatalf("unexpected error authorizing credential: %v", err)
+	}
+	if user != "ci-bot" {
+		t.Fatalf("unexpected user: %q", user)
+	}
+
+	stored, err := store.Get(ctx, cred.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.LastUsedAt == nil {
+		t.Error("expected last used time to be recorded")
+	}
+
+	if _, err := authorize("ci-bot", secret, pullPush("other/app")...); err != auth.ErrAccessDenied {
+		t.Errorf("expected access outside of the scopes to be denied, got %v", err)
+	}
+
+	for _, testcase := range []struct {
+		user, password string
+	}{
+		{"", ""},
+		{"ci-bot", "not-a-secret"},
+		{"ci-bot", secret + "x"},
+		{"someone", secret},
+		{"alice", expiredSecret},
+		{"ci-bot", secretPrefix + "0123456789abcdef_00"},
+	} {
+		_, err := authorize(testcase.user, testcase.password, pullPush("ci/app")...)
+		if _, ok := err.(auth.Challenge); !ok {
+			t.Errorf("%q/%q: expected challenge, got %v", testcase.user, testcase.password, err)
+		}
+	}
+
+	verifier := ac.(auth.UserVerifier)
+	if err := verifier.VerifyUser(ctx, auth.UserInfo{Name: "ci-bot", Credential: cred.ID}); err != nil {
+		t.Errorf("unexpected error verifying credential user: %v", err)
+	}
+	if err := verifier.VerifyUser(ctx, auth.UserInfo{Name: "ci-bot", Credential: cred.ID}, pullPush("ci/app")...); err != nil {
+		t.Errorf("unexpected error verifying credential user access: %v", err)
+	}
+	if err := verifier.VerifyUser(ctx, auth.UserInfo{Name: "ci-bot", Credential: cred.ID}, pullPush("other/app")...); err != auth.ErrAccessDenied {
+		t.Errorf("expected access outside of the scopes to be denied on verification, got %v", err)
+	}
+	if err := verifier.VerifyUser(ctx, auth.UserInfo{Name: "alice", Credential: expired.ID}); err == nil {
+		t.Error("expected expired credential user to fail verification")
+	}
+
+	// Revoked credentials are rejected.
+	if err := store.Delete(ctx, cred.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authorize("ci-bot", secret, pullPush("ci/app")...); err == nil {
+		t.Error("expected revoked credential to be rejected")
+	}
+	if err := verifier.VerifyUser(ctx, auth.UserInfo{Name: "ci-bot", Credential: cred.ID}); err == nil {
+		t.Error("expected revoked credential user to fail verification")
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	store := NewDriverStore(inmemory.New(), "/credentials")
+	server := httptest.NewServer(AdminHandler("/debug/credentials", store, "admin-token"))
+	defer server.Close()
+
+	// Requests without the admin token are rejected.
+	for _, token := range []string{"", "wrong-token"} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/debug/credentials", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("token %q: unexpected status %v", token, resp.Status)
+		}
+	}
+
+	client := &http.Client{Transport: bearerTransport("admin-token")}
+
+	body, _ := json.Marshal(createRequest{
+		Username:  "ci-bot",
+		Scopes:    []Scope{{Repositories: []string{"ci/*"}, Actions: []string{"pull"}}},
+		ExpiresIn: "24h",
+	})
+
+	resp, err := client.Post(server.URL+"/debug/credentials", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status creating credential: %v", resp.Status)
+	}
+
+	var created createResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	if created.Secret == "" || created.SecretHash != "" {
+		t.Fatalf("expected secret and no secret hash in response: %+v", created)
+	}
+	if created.Kind != KindRobot || created.ExpiresAt == nil {
+		t.Fatalf("unexpected credential: %+v", created.Credential)
+	}
+
+	resp, err = client.Get(server.URL + "/debug/credentials?username=ci-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var listed []Credential
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != created.ID || listed[0].SecretHash != "" {
+		t.Fatalf("unexpected credential list: %+v", listed)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL+"/debug/credentials/"+created.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status revoking credential: %v", resp.Status)
+	}
+
+	resp, err = client.Get(server.URL + "/debug/credentials/" + created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status for revoked credential: %v", resp.Status)
+	}
+
+	for _, invalid := range []createRequest{
+		{Username: "ci-bot"},
+		{Username: "ci-bot", Kind: "admin", Scopes: []Scope{{Repositories: []string{"a"}, Actions: []string{"pull"}}}},
+		{Username: "ci-bot", Scopes: []Scope{{Repositories: []string{"a"}, Actions: []string{"sudo"}}}},
+		{Username: "ci:bot", Scopes: []Scope{{Repositories: []string{"a"}, Actions: []string{"pull"}}}},
+		{Username: "ci-bot", ExpiresIn: "-1h", Scopes: []Scope{{Repositories: []string{"a"}, Actions: []string{"pull"}}}},
+	} {
+		body, _ := json.Marshal(invalid)
+		resp, err := client.Post(server.URL+"/debug/credentials", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%+v: unexpected status %v", invalid, resp.Status)
+		}
+	}
+}
+
+// bearerTransport adds a bearer token to each request.
+type bearerTransport string
+
+func (bt bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+string(bt))
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -0,0 +1,194 @@
+package robot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+)
+
+// createRequest is the body of a request creating a credential.
+type createRequest struct {
+	Kind        string     `json:"kind"`
+	Username    string     `json:"username"`
+	Description string     `json:"description,omitempty"`
+	Scopes      []Scope    `json:"scopes"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	ExpiresIn   string     `json:"expiresIn,omitempty"`
+}
+
+// createResponse is the body of the response to a created credential. The
+// secret is only ever returned here.
+type createResponse struct {
+	Credential
+	Secret string `json:"secret"`
+}
+
+// adminHandler serves the admin API for credentials.
+type adminHandler struct {
+	prefix string
+	store  Store
+	token  string
+}
+
+// AdminHandler returns the admin API for the credentials in store, served
+// under prefix to clients presenting token as a bearer token:
+//
+//	GET    <prefix>        lists credentials, optionally for ?username=
+//	POST   <prefix>        creates a credential, returning its secret
+//	GET    <prefix>/<id>   returns a credential
+//	DELETE <prefix>/<id>   revokes a credential
+//
+// Secret hashes are never returned. An empty token rejects every request.
+func AdminHandler(prefix string, store Store, token string) http.Handler {
+	return &adminHandler{prefix: strings.TrimSuffix(prefix, "/"), store: store, token: token}
+}
+
+func (ah *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !ah.authenticated(r) {
+		dcontext.GetLogger(r.Context()).Warnf("robot: rejected unauthenticated credentials admin request from %s", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="credentials"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, ah.prefix), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		ah.list(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		ah.create(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		ah.get(w, r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		ah.delete(w, r, id)
+	case id == "":
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticated returns true if r carries the admin token.
+func (ah *adminHandler) authenticated(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ah.token == "" || token == r.Header.Get("Authorization") {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(ah.token)) == 1
+}
+
+func (ah *adminHandler) list(w http.ResponseWriter, r *http.Request) {
+	creds, err := ah.store.List(r.Context())
+	if err != nil {
+		ah.serveError(w, r, err)
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+
+	results := make([]Credential, 0, len(creds))
+	for _, cred := range creds {
+		if username != "" && cred.Username != username {
+			continue
+		}
+		results = append(results, redact(cred))
+	}
+
+	serveJSON(w, http.StatusOK, results)
+}
+
+func (ah *adminHandler) create(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Kind == "" {
+		req.Kind = KindRobot
+	}
+
+	if req.ExpiresIn != "" {
+		if req.ExpiresAt != nil {
+			http.Error(w, "invalid request: expiresAt and expiresIn are exclusive", http.StatusBadRequest)
+			return
+		}
+
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid request: invalid expiresIn %q", req.ExpiresIn), http.StatusBadRequest)
+			return
+		}
+
+		expiresAt := time.Now().Add(d).UTC()
+		req.ExpiresAt = &expiresAt
+	}
+
+	cred, secret, err := NewCredential(req.Kind, req.Username, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	cred.Description = req.Description
+
+	if err := ah.store.Put(r.Context(), cred); err != nil {
+		ah.serveError(w, r, err)
+		return
+	}
+
+	dcontext.GetLogger(r.Context()).Infof("robot: created %s credential %s for %q", cred.Kind, cred.ID, cred.Username)
+
+	serveJSON(w, http.StatusCreated, createResponse{Credential: redact(cred), Secret: secret})
+}
+
+func (ah *adminHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+	cred, err := ah.store.Get(r.Context(), id)
+	if err != nil {
+		ah.serveError(w, r, err)
+		return
+	}
+
+	serveJSON(w, http.StatusOK, redact(cred))
+}
+
+func (ah *adminHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	if err := ah.store.Delete(r.Context(), id); err != nil {
+		ah.serveError(w, r, err)
+		return
+	}
+
+	dcontext.GetLogger(r.Context()).Infof("robot: revoked credential %s", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ah *adminHandler) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == ErrCredentialUnknown {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	dcontext.GetLogger(r.Context()).Errorf("robot: credential store error: %v", err)
+	http.Error(w, "credential store error", http.StatusInternalServerError)
+}
+
+// redact removes the secret hash from a credential.
+func redact(cred Credential) Credential {
+	cred.SecretHash = ""
+	return cred
+}
+
+func serveJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,197 @@
+package robot
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// Kinds of credentials.
+const (
+	// KindRobot is a credential for an account of its own, such as a CI
+	// pipeline.
+	KindRobot = "robot"
+
+	// KindPersonal is a personal access token, acting on behalf of a user.
+	KindPersonal = "personal"
+)
+
+// secretPrefix marks passwords which are credential secrets, so that they
+// can be told apart from the passwords of other identity sources.
+const secretPrefix = "drc_"
+
+// Actions which may be granted by a credential scope.
+const (
+	actionPull   = "pull"
+	actionPush   = "push"
+	actionDelete = "delete"
+	actionAll    = "*"
+)
+
+// Scope grants actions on a set of repositories.
+type Scope struct {
+	// Repositories lists glob patterns of repository names. "*" matches a
+	// single path component and "**" matches any number of them.
+	Repositories []string `json:"repositories"`
+
+	// Actions lists the granted actions: pull, push, delete or "*".
+	Actions []string `json:"actions"`
+}
+
+// Credential is a robot account or personal access token. Its secret is
+// only known when the credential is created; the hash of the secret is kept.
+type Credential struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Username    string     `json:"username"`
+	Description string     `json:"description,omitempty"`
+	Scopes      []Scope    `json:"scopes"`
+	SecretHash  string     `json:"secretHash,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// NewCredential creates a credential for username, returning it along with
+// the secret to use as its password.
+func NewCredential(kind, username string, scopes []Scope, expiresAt *time.Time) (Credential, string, error) {
+	cred := Credential{
+		Kind:      kind,
+		Username:  username,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := cred.Validate(); err != nil {
+		return Credential{}, "", err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return Credential{}, "", err
+	}
+
+	key, err := randomHex(32)
+	if err != nil {
+		return Credential{}, "", err
+	}
+
+	cred.ID = id
+	cred.SecretHash = hashSecret(key)
+
+	return cred, secretPrefix + id + "_" + key, nil
+}
+
+// Validate checks that the credential is well formed.
+func (cred Credential) Validate() error {
+	switch cred.Kind {
+	case KindRobot, KindPersonal:
+	default:
+		return fmt.Errorf("unknown credential kind %q", cred.Kind)
+	}
+
+	if cred.Username == "" || strings.Contains(cred.Username, ":") {
+		return fmt.Errorf("invalid credential username %q", cred.Username)
+	}
+
+	if len(cred.Scopes) == 0 {
+		return errors.New("credential has no scopes")
+	}
+
+	for i, scope := range cred.Scopes {
+		if len(scope.Repositories) == 0 || len(scope.Actions) == 0 {
+			return fmt.Errorf("scope %d must list repositories and actions", i)
+		}
+
+		for _, action := range scope.Actions {
+			switch action {
+			case actionPull, actionPush, actionDelete, actionAll:
+			default:
+				return fmt.Errorf("scope %d has unknown action %q", i, action)
+			}
+		}
+
+		if _, err := auth.NewRepositoryMatcher(scope.Repositories...); err != nil {
+			return fmt.Errorf("scope %d has invalid repository pattern: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Expired returns true if the credential has expired at t.
+func (cred Credential) Expired(t time.Time) bool {
+	return cred.ExpiresAt != nil && !t.Before(*cred.ExpiresAt)
+}
+
+// Allows returns true if one of the credential's scopes grants the access.
+func (cred Credential) Allows(access auth.Access) bool {
+	if access.Type != "repository" {
+		return false
+	}
+
+	for _, scope := range cred.Scopes {
+		if !contains(scope.Actions, actionAll) && !contains(scope.Actions, access.Action) {
+			continue
+		}
+
+		matcher, err := auth.NewRepositoryMatcher(scope.Repositories...)
+		if err == nil && matcher.Match(access.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifySecret returns true if key is the credential's secret.
+func (cred Credential) verifySecret(key string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(key)), []byte(cred.SecretHash)) == 1
+}
+
+// parseSecret splits a credential secret into the ID of the credential and
+// its key. It returns false if password is not a credential secret.
+func parseSecret(password string) (id, key string, ok bool) {
+	if !strings.HasPrefix(password, secretPrefix) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(password, secretPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// hashSecret hashes a secret key for storage. Keys are random, so a fast
+// hash is sufficient.
+func hashSecret(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	p := make([]byte, n)
+	if _, err := rand.Read(p); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(p), nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
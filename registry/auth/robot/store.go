@@ -0,0 +1,332 @@
+package robot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrCredentialUnknown is returned when a credential does not exist.
+var ErrCredentialUnknown = errors.New("unknown credential")
+
+// Store persists credentials.
+type Store interface {
+	// Get returns the credential with the given ID.
+	Get(ctx context.Context, id string) (Credential, error)
+
+	// List returns all credentials, ordered by ID.
+	List(ctx context.Context) ([]Credential, error)
+
+	// Put creates or replaces a credential.
+	Put(ctx context.Context, cred Credential) error
+
+	// Delete removes a credential, revoking it.
+	Delete(ctx context.Context, id string) error
+
+	// Touch records that the credential was used at t.
+	Touch(ctx context.Context, id string, t time.Time) error
+}
+
+// storeKey is the context key of the store used by robot access controllers.
+type storeKey struct{}
+
+// WithStore returns a context holding the store used by robot access
+// controllers authorizing requests with it. The registry places its store on
+// the context of every request.
+func WithStore(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, storeKey{}, store)
+}
+
+// storeFromContext returns the store placed on ctx with WithStore, or nil.
+func storeFromContext(ctx context.Context) Store {
+	store, _ := ctx.Value(storeKey{}).(Store)
+	return store
+}
+
+// driverStore keeps each credential in a directory of its own, holding the
+// credential and, separately, the time it was last used.
+type driverStore struct {
+	driver storagedriver.StorageDriver
+	root   string
+}
+
+// NewDriverStore returns a store keeping credentials with driver, under the
+// path root.
+func NewDriverStore(driver storagedriver.StorageDriver, root string) Store {
+	return &driverStore{driver: driver, root: root}
+}
+
+func (ds *driverStore) Get(ctx context.Context, id string) (Credential, error) {
+	if !validID(id) {
+		return Credential{}, ErrCredentialUnknown
+	}
+
+	p, err := ds.driver.GetContent(ctx, path.Join(ds.root, id, "data"))
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return Credential{}, ErrCredentialUnknown
+		}
+		return Credential{}, err
+	}
+
+	var cred Credential
+	if err := json.Unmarshal(p, &cred); err != nil {
+		return Credential{}, err
+	}
+
+	p, err = ds.driver.GetContent(ctx, path.Join(ds.root, id, "lastused"))
+	switch err.(type) {
+	case nil:
+		var lastUsed time.Time
+		if err := lastUsed.UnmarshalText(p); err == nil {
+			cred.LastUsedAt = &lastUsed
+		}
+	case storagedriver.PathNotFoundError:
+	default:
+		return Credential{}, err
+	}
+
+	return cred, nil
+}
+
+func (ds *driverStore) List(ctx context.Context) ([]Credential, error) {
+	paths, err := ds.driver.List(ctx, ds.root)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sort.Strings(paths)
+
+	creds := make([]Credential, 0, len(paths))
+	for _, p := range paths {
+		cred, err := ds.Get(ctx, path.Base(p))
+		if err == ErrCredentialUnknown {
+			// Deleted since listing, or only a stray last-used record.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+func (ds *driverStore) Put(ctx context.Context, cred Credential) error {
+	if !validID(cred.ID) {
+		return errors.New("invalid credential id")
+	}
+
+	cred.LastUsedAt = nil
+	p, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+
+	return ds.driver.PutContent(ctx, path.Join(ds.root, cred.ID, "data"), p)
+}
+
+func (ds *driverStore) Delete(ctx context.Context, id string) error {
+	if !validID(id) {
+		return ErrCredentialUnknown
+	}
+
+	if err := ds.driver.Delete(ctx, path.Join(ds.root, id)); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return ErrCredentialUnknown
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (ds *driverStore) Touch(ctx context.Context, id string, t time.Time) error {
+	if !validID(id) {
+		return ErrCredentialUnknown
+	}
+
+	p, err := t.UTC().MarshalText()
+	if err != nil {
+		return err
+	}
+
+	return ds.driver.PutContent(ctx, path.Join(ds.root, id, "lastused"), p)
+}
+
+// Redis hashes holding credentials and their last-used times, keyed by
+// credential ID.
+const (
+	redisCredentialsKey = "credentials"
+	redisLastUsedKey    = "credentials::lastused"
+)
+
+type redisStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisStore returns a store keeping credentials in Redis.
+func NewRedisStore(pool *redis.Pool) Store {
+	return &redisStore{pool: pool}
+}
+
+func (rs *redisStore) Get(ctx context.Context, id string) (Credential, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(conn.Do("HMGET", redisCredentialsKey, id))
+	if err != nil {
+		return Credential{}, err
+	}
+
+	creds, err := rs.decode(conn, []string{id}, reply)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if len(creds) == 0 {
+		return Credential{}, ErrCredentialUnknown
+	}
+
+	return creds[0], nil
+}
+
+func (rs *redisStore) List(ctx context.Context) ([]Credential, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("HKEYS", redisCredentialsKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(ids)
+
+	args := redis.Args{}.Add(redisCredentialsKey).AddFlat(ids)
+	reply, err := redis.Values(conn.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.decode(conn, ids, reply)
+}
+
+// decode unmarshals the credentials in reply, the values of the credentials
+// hash for ids, skipping missing ones, and adds their last-used times.
+func (rs *redisStore) decode(conn redis.Conn, ids []string, reply []interface{}) ([]Credential, error) {
+	args := redis.Args{}.Add(redisLastUsedKey).AddFlat(ids)
+	lastUsed, err := redis.Values(conn.Do("HMGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	creds := make([]Credential, 0, len(ids))
+	for i, v := range reply {
+		if v == nil {
+			continue
+		}
+
+		p, err := redis.Bytes(v, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var cred Credential
+		if err := json.Unmarshal(p, &cred); err != nil {
+			return nil, err
+		}
+
+		if i < len(lastUsed) && lastUsed[i] != nil {
+			if p, err := redis.Bytes(lastUsed[i], nil); err == nil {
+				var t time.Time
+				if err := t.UnmarshalText(p); err == nil {
+					cred.LastUsedAt = &t
+				}
+			}
+		}
+
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+func (rs *redisStore) Put(ctx context.Context, cred Credential) error {
+	if !validID(cred.ID) {
+		return errors.New("invalid credential id")
+	}
+
+	cred.LastUsedAt = nil
+	p, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("HSET", redisCredentialsKey, cred.ID, p)
+	return err
+}
+
+func (rs *redisStore) Delete(ctx context.Context, id string) error {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	deleted, err := redis.Int(conn.Do("HDEL", redisCredentialsKey, id))
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Do("HDEL", redisLastUsedKey, id); err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrCredentialUnknown
+	}
+
+	return nil
+}
+
+func (rs *redisStore) Touch(ctx context.Context, id string, t time.Time) error {
+	p, err := t.UTC().MarshalText()
+	if err != nil {
+		return err
+	}
+
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("HSET", redisLastUsedKey, id, p)
+	return err
+}
+
+// validID returns true if id could have been generated for a credential,
+// which keeps it safe to use in storage paths.
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
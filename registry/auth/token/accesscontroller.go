@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/auth"
@@ -133,8 +134,20 @@ type accessController struct {
 	service      string
 	rootCerts    *x509.CertPool
 	trustedKeys  map[string]libtrust.PublicKey
+	keySet       *KeySet
+	server       *tokenServer
+	serverPath   string
 }
 
+var (
+	_ auth.AccessController = &accessController{}
+	_ auth.RepositoryFilter = &accessController{}
+)
+
+// grantedAccessKey holds the access granted by the token of a request on the
+// context returned by Authorized.
+type grantedAccessKey struct{}
+
 // tokenAccessOptions is a convenience type for handling
 // options to the contstructor of an accessController.
 type tokenAccessOptions struct {
@@ -143,6 +156,9 @@ type tokenAccessOptions struct {
 	issuer         string
 	service        string
 	rootCertBundle string
+	jwks           string
+	jwksRefresh    time.Duration
+	server         *tokenServerOptions
 }
 
 // checkOptions gathers the necessary options
@@ -150,7 +166,7 @@ type tokenAccessOptions struct {
 func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 	var opts tokenAccessOptions
 
-	keys := []string{"realm", "issuer", "service", "rootcertbundle"}
+	keys := []string{"realm", "issuer", "service"}
 	vals := make([]string, 0, len(keys))
 	for _, key := range keys {
 		val, ok := options[key].(string)
@@ -160,7 +176,48 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		vals = append(vals, val)
 	}
 
-	opts.realm, opts.issuer, opts.service, opts.rootCertBundle = vals[0], vals[1], vals[2], vals[3]
+	opts.realm, opts.issuer, opts.service = vals[0], vals[1], vals[2]
+
+	// At least one source of trusted signing keys is required.
+	for key, val := range map[string]*string{
+		"rootcertbundle": &opts.rootCertBundle,
+		"jwks":           &opts.jwks,
+	} {
+		if v, ok := options[key]; ok {
+			s, ok := v.(string)
+			if !ok {
+				return opts, fmt.Errorf("token auth requires a valid option string: %q", key)
+			}
+			*val = s
+		}
+	}
+
+	if v, ok := options["server"]; ok {
+		server, err := checkServerOptions(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.server = &server
+	}
+
+	if opts.rootCertBundle == "" && opts.jwks == "" && opts.server == nil {
+		return opts, fmt.Errorf("token auth requires a valid option string: %q", "rootcertbundle")
+	}
+
+	if v, ok := options["jwksrefresh"]; ok {
+		switch v := v.(type) {
+		case string:
+			refresh, err := time.ParseDuration(v)
+			if err != nil {
+				return opts, fmt.Errorf("token auth requires a valid option duration: jwksrefresh: %v", err)
+			}
+			opts.jwksRefresh = refresh
+		case time.Duration:
+			opts.jwksRefresh = v
+		default:
+			return opts, fmt.Errorf("token auth requires a valid option duration: jwksrefresh")
+		}
+	}
 
 	autoRedirectVal, ok := options["autoredirect"]
 	if ok {
@@ -181,15 +238,61 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		return nil, err
 	}
 
-	fp, err := os.Open(config.rootCertBundle)
+	ac := &accessController{
+		realm:        config.realm,
+		autoRedirect: config.autoRedirect,
+		issuer:       config.issuer,
+		service:      config.service,
+	}
+
+	if config.rootCertBundle != "" {
+		ac.rootCerts, ac.trustedKeys, err = loadRootCertBundle(config.rootCertBundle)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.jwks != "" {
+		ac.keySet, err = NewKeySet(config.jwks, config.jwksRefresh)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load token auth key set %q: %s", config.jwks, err)
+		}
+	}
+
+	if config.server != nil {
+		ac.serverPath, err = endpointPath(config.realm, config.autoRedirect)
+		if err != nil {
+			return nil, err
+		}
+
+		ac.server, err = newTokenServer(config.issuer, config.service, *config.server)
+		if err != nil {
+			return nil, err
+		}
+
+		// Tokens issued by the built-in server are signed with its key.
+		if ac.trustedKeys == nil {
+			ac.trustedKeys = make(map[string]libtrust.PublicKey)
+		}
+		pubKey := ac.server.signingKey.PublicKey()
+		ac.trustedKeys[pubKey.KeyID()] = pubKey
+	}
+
+	return ac, nil
+}
+
+// loadRootCertBundle reads the certificates of the bundle at path, returning
+// them as a pool along with their public keys indexed by libtrust key ID.
+func loadRootCertBundle(path string) (*x509.CertPool, map[string]libtrust.PublicKey, error) {
+	fp, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
+		return nil, nil, fmt.Errorf("unable to open token auth root certificate bundle file %q: %s", path, err)
 	}
 	defer fp.Close()
 
 	rawCertBundle, err := ioutil.ReadAll(fp)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", config.rootCertBundle, err)
+		return nil, nil, fmt.Errorf("unable to read token auth root certificate bundle file %q: %s", path, err)
 	}
 
 	var rootCerts []*x509.Certificate
@@ -198,7 +301,7 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		if pemBlock.Type == "CERTIFICATE" {
 			cert, err := x509.ParseCertificate(pemBlock.Bytes)
 			if err != nil {
-				return nil, fmt.Errorf("unable to parse token auth root certificate: %s", err)
+				return nil, nil, fmt.Errorf("unable to parse token auth root certificate: %s", err)
 			}
 
 			rootCerts = append(rootCerts, cert)
@@ -208,7 +311,7 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 	}
 
 	if len(rootCerts) == 0 {
-		return nil, errors.New("token auth requires at least one token signing root certificate")
+		return nil, nil, errors.New("token auth requires at least one token signing root certificate")
 	}
 
 	rootPool := x509.NewCertPool()
@@ -217,19 +320,12 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		rootPool.AddCert(rootCert)
 		pubKey, err := libtrust.FromCryptoPublicKey(crypto.PublicKey(rootCert.PublicKey))
 		if err != nil {
-			return nil, fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
+			return nil, nil, fmt.Errorf("unable to get public key from token auth root certificate: %s", err)
 		}
 		trustedKeys[pubKey.KeyID()] = pubKey
 	}
 
-	return &accessController{
-		realm:        config.realm,
-		autoRedirect: config.autoRedirect,
-		issuer:       config.issuer,
-		service:      config.service,
-		rootCerts:    rootPool,
-		trustedKeys:  trustedKeys,
-	}, nil
+	return rootPool, trustedKeys, nil
 }
 
 // Authorized handles checking whether the given request is authorized
@@ -267,6 +363,7 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 		AcceptedAudiences: []string{ac.service},
 		Roots:             ac.rootCerts,
 		TrustedKeys:       ac.trustedKeys,
+		KeySet:            ac.keySet,
 	}
 
 	if err = token.Verify(verifyOpts); err != nil {
@@ -274,6 +371,12 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 		return nil, challenge
 	}
 
+	// Refresh tokens only serve to obtain access tokens.
+	if token.Header.Type == refreshTokenType {
+		challenge.err = ErrInvalidToken
+		return nil, challenge
+	}
+
 	accessSet := token.accessSet()
 	for _, access := range accessItems {
 		if !accessSet.contains(access) {
@@ -283,10 +386,39 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 	}
 
 	ctx = auth.WithResources(ctx, token.resources())
+	ctx = context.WithValue(ctx, grantedAccessKey{}, accessSet)
 
 	return auth.WithUser(ctx, auth.UserInfo{Name: token.Claims.Subject}), nil
 }
 
+// PullableRepositories resolves the repositories the subject of the token
+// may pull. With the built-in token server, which grants the access its
+// policy allows, they are resolved from the policy. Tokens of other servers
+// only allow the repositories they were granted pull access to.
+func (ac *accessController) PullableRepositories(ctx context.Context) (func(name string) bool, error) {
+	if ac.server != nil {
+		return ac.server.pullable(ctx)
+	}
+
+	granted, _ := ctx.Value(grantedAccessKey{}).(accessSet)
+	return func(name string) bool {
+		return granted.contains(auth.Access{
+			Resource: auth.Resource{Type: "repository", Name: name},
+			Action:   "pull",
+		})
+	}, nil
+}
+
+// Endpoint returns the handler of the built-in token server and the path it
+// is served at, if the server is enabled.
+func (ac *accessController) Endpoint() (string, http.Handler) {
+	if ac.server == nil {
+		return "", nil
+	}
+
+	return ac.serverPath, ac.server
+}
+
 // init handles registering the token auth backend.
 func init() {
 	auth.Register("token", auth.InitFunc(newAccessController))
@@ -0,0 +1,354 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultKeySetRefresh is the interval after which a key set is reloaded
+	// from its source when no refresh interval is configured.
+	DefaultKeySetRefresh = 15 * time.Minute
+)
+
+// minKeySetRefetch limits how often a token signed by an unknown key ID can
+// force a key set to be reloaded ahead of its refresh interval.
+var minKeySetRefetch = 10 * time.Second
+
+// jsonWebKey is the subset of the JSON Web Key (RFC 7517) representation
+// needed to construct RSA, EC and OKP public keys.
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// jsonWebKeySet is the JSON Web Key Set document served by OIDC providers.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// keySetKey is a public key from a key set, along with the algorithm it is
+// restricted to, if any.
+type keySetKey struct {
+	key       crypto.PublicKey
+	algorithm string
+}
+
+// KeySet holds the signing keys of a JSON Web Key Set, indexed by key ID. The
+// set is loaded from a file or an http(s) URL and periodically reloaded so
+// that keys rotated by the issuer are picked up without a restart.
+type KeySet struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]keySetKey
+	fetched time.Time
+
+	// reloading is closed when the in-flight reload completes, and is nil
+	// when no reload is running.
+	reloading chan struct{}
+}
+
+// NewKeySet loads the key set at source, which is either a file path or an
+// http(s) URL. The set is reloaded once it is older than refresh.
+func NewKeySet(source string, refresh time.Duration) (*KeySet, error) {
+	if refresh <= 0 {
+		refresh = DefaultKeySetRefresh
+	}
+
+	ks := &KeySet{
+		source:  source,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+
+	keys, err := ks.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ks.keys = keys
+	ks.fetched = time.Now()
+
+	return ks, nil
+}
+
+// Key returns the public key with the given key ID and the algorithm it is
+// restricted to, which is empty if the key does not specify one. A stale set
+// is reloaded in the background while the cached keys keep being served. An
+// unknown key ID waits for a reload, so that newly rotated keys can be used as
+// soon as the issuer publishes them. At most one reload runs at a time.
+func (ks *KeySet) Key(keyID string) (crypto.PublicKey, string, bool) {
+	ks.mu.Lock()
+	k, known := ks.keys[keyID]
+	age := time.Since(ks.fetched)
+
+	var done chan struct{}
+	if age > ks.refresh || (!known && age > minKeySetRefetch) {
+		done = ks.startReload()
+	} else if !known {
+		// A reload triggered by another caller may be about to publish
+		// the key.
+		done = ks.reloading
+	}
+	ks.mu.Unlock()
+
+	if known || done == nil {
+		return k.key, k.algorithm, known
+	}
+
+	<-done
+
+	ks.mu.Lock()
+	k, known = ks.keys[keyID]
+	ks.mu.Unlock()
+
+	return k.key, k.algorithm, known
+}
+
+// startReload starts reloading the key set unless a reload is already
+// running, and returns a channel which is closed once it completes. The caller
+// must hold ks.mu.
+func (ks *KeySet) startReload() chan struct{} {
+	if ks.reloading != nil {
+		return ks.reloading
+	}
+
+	done := make(chan struct{})
+	ks.reloading = done
+
+	go func() {
+		keys, err := ks.load()
+
+		ks.mu.Lock()
+		if err != nil {
+			// Keep serving the keys we have; the source may be briefly
+			// unavailable.
+			log.Warnf("unable to reload token signing key set from %s: %v", ks.source, err)
+		} else {
+			ks.keys = keys
+		}
+		ks.fetched = time.Now()
+		ks.reloading = nil
+		ks.mu.Unlock()
+
+		close(done)
+	}()
+
+	return done
+}
+
+// load reads and parses the key set from its source.
+func (ks *KeySet) load() (map[string]keySetKey, error) {
+	var (
+		raw []byte
+		err error
+	)
+
+	if strings.HasPrefix(ks.source, "http://") || strings.HasPrefix(ks.source, "https://") {
+		raw, err = ks.fetch()
+	} else {
+		raw, err = ioutil.ReadFile(ks.source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseKeySet(raw)
+}
+
+// fetch retrieves the key set document from a remote URL.
+func (ks *KeySet) fetch() ([]byte, error) {
+	resp, err := ks.client.Get(ks.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching key set: %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseKeySet decodes a JSON Web Key Set document. Keys which are not meant
+// for signatures, have no key ID or are of an unsupported type are skipped.
+func parseKeySet(raw []byte) (map[string]keySetKey, error) {
+	var set jsonWebKeySet
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("unable to decode key set: %v", err)
+	}
+
+	keys := make(map[string]keySetKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.KeyID == "" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Warnf("skipping key %q from key set: %v", jwk.KeyID, err)
+			continue
+		}
+
+		keys[jwk.KeyID] = keySetKey{key: key, algorithm: jwk.Algorithm}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("key set contains no usable signing keys")
+	}
+
+	return keys, nil
+}
+
+// publicKey constructs the crypto public key described by the JWK.
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := joseBase64UrlDecode(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %v", err)
+		}
+		e, err := joseBase64UrlDecode(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %v", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() > 1<<31-1 || exponent.Int64() < 2 {
+			return nil, errors.New("invalid RSA public key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", jwk.Curve)
+		}
+		x, err := joseBase64UrlDecode(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %v", err)
+		}
+		y, err := joseBase64UrlDecode(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %v", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on curve")
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", jwk.Curve)
+		}
+		x, err := joseBase64UrlDecode(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+	}
+}
+
+// verifySignature checks the JWS signature of signed, made with the given
+// algorithm, against the public key. ES256, ES384, ES512, RS256, RS384,
+// RS512, PS256, PS384, PS512 and EdDSA are supported.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch strings.TrimLeft(alg, "ERSP") {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write([]byte(signed))
+		digest = h.Sum(nil)
+	}
+
+	switch alg {
+	case "RS256", "RS384", "RS512":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+	case "PS256", "PS384", "PS512":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an RSA key", alg)
+		}
+		return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256", "ES384", "ES512":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an EC key", alg)
+		}
+		curveBits := map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}[alg]
+		if pub.Curve.Params().BitSize != curveBits {
+			return fmt.Errorf("algorithm %s does not match key curve %s", alg, pub.Curve.Params().Name)
+		}
+		size := (curveBits + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s requires an Ed25519 key", alg)
+		}
+		if !ed25519.Verify(pub, []byte(signed), signature) {
+			return errors.New("invalid EdDSA signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
@@ -0,0 +1,416 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// testKeySetServer serves a JSON Web Key Set which can be changed by tests to
+// simulate key rotation.
+type testKeySetServer struct {
+	mu   sync.Mutex
+	keys []jsonWebKey
+}
+
+func (s *testKeySetServer) setKeys(keys ...jsonWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *testKeySetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	json.NewEncoder(w).Encode(jsonWebKeySet{Keys: s.keys})
+}
+
+func makeJWK(kid, alg string, pub crypto.PublicKey) jsonWebKey {
+	jwk := jsonWebKey{KeyID: kid, Algorithm: alg, Use: "sig"}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = joseBase64UrlEncode(pub.N.Bytes())
+		jwk.E = joseBase64UrlEncode(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = joseBase64UrlEncode(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = joseBase64UrlEncode(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = joseBase64UrlEncode(pub)
+	}
+
+	return jwk
+}
+
+// signTestJWT produces a compact JWT signed with the given algorithm, naming
+// its signing key by kid.
+func signTestJWT(t *testing.T, alg, kid string, key crypto.Signer, claims *ClaimSet) string {
+	header, err := json.Marshal(&Header{Type: "JWT", SigningAlg: alg, KeyID: kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := joseBase64UrlEncode(header) + "." + joseBase64UrlEncode(payload)
+
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "ES384":
+		hash = crypto.SHA384
+	case "PS512", "ES512":
+		hash = crypto.SHA512
+	}
+
+	var signature []byte
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	case *ecdsa.PrivateKey:
+		h := hash.New()
+		h.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	case *rsa.PrivateKey:
+		h := hash.New()
+		h.Write([]byte(signed))
+		if alg[0] == 'P' {
+			signature, err = rsa.SignPSS(rand.Reader, key, hash, h.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, h.Sum(nil))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + joseBase64UrlEncode(signature)
+}
+
+func TestAccessControllerKeySet(t *testing.T) {
+	minKeySetRefetch = 0
+	defer func() { minKeySetRefetch = 10 * time.Second }()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keySetServer := &testKeySetServer{}
+	keySetServer.setKeys(
+		makeJWK("rsa", "", rsaKey.Public()),
+		makeJWK("p256", "ES256", p256Key.Public()),
+		makeJWK("p384", "ES384", p384Key.Public()),
+		makeJWK("p521", "ES512", p521Key.Public()),
+		makeJWK("ed", "EdDSA", edKey.Public()),
+	)
+	server := httptest.NewServer(keySetServer)
+	defer server.Close()
+
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"realm":       "https://auth.example.com/token/",
+		"issuer":      issuer,
+		"service":     service,
+		"jwks":        server.URL,
+		"jwksrefresh": "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testAccess := auth.Access{
+		Resource: auth.Resource{
+			Type: "repository",
+			Name: "foo/bar",
+		},
+		Action: "pull",
+	}
+
+	now := time.Now()
+	claims := &ClaimSet{
+		Issuer:     issuer,
+		Subject:    "ci",
+		Audience:   service,
+		Expiration: now.Add(5 * time.Minute).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		Access: []*ResourceActions{{
+			Type:    testAccess.Type,
+			Name:    testAccess.Name,
+			Actions: []string{testAccess.Action},
+		}},
+	}
+
+	authorize := func(rawToken string) error {
+		req, err := http.NewRequest("GET", "http://example.com/v2/foo/bar/tags/list", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", rawToken))
+
+		authCtx, err := accessController.Authorized(context.WithRequest(context.Background(), req), testAccess)
+		if err != nil {
+			return err
+		}
+
+		if userInfo, ok := authCtx.Value(auth.UserKey).(auth.UserInfo); !ok || userInfo.Name != "ci" {
+			t.Fatal("token subject not available from context")
+		}
+		return nil
+	}
+
+	for _, testcase := range []struct {
+		alg string
+		kid string
+		key crypto.Signer
+	}{
+		{"RS256", "rsa", rsaKey},
+		{"PS256", "rsa", rsaKey},
+		{"PS512", "rsa", rsaKey},
+		{"ES256", "p256", p256Key},
+		{"ES384", "p384", p384Key},
+		{"ES512", "p521", p521Key},
+		{"EdDSA", "ed", edKey},
+	} {
+		if err := authorize(signTestJWT(t, testcase.alg, testcase.kid, testcase.key, claims)); err != nil {
+			t.Errorf("%s: unexpected error authorizing token: %v", testcase.alg, err)
+		}
+	}
+
+	// Tokens naming the wrong key or an algorithm the key is restricted
+	// against must be rejected.
+	for _, testcase := range []struct {
+		alg string
+		kid string
+		key crypto.Signer
+	}{
+		{"ES256", "p384", p256Key},
+		{"ES384", "p256", p256Key},
+		{"RS256", "ed", rsaKey},
+		{"EdDSA", "unknown", edKey},
+	} {
+		err := authorize(signTestJWT(t, testcase.alg, testcase.kid, testcase.key, claims))
+		if challenge, ok := err.(auth.Challenge); !ok || challenge.Error() != ErrInvalidToken.Error() {
+			t.Errorf("%s signed by %q: expected invalid token challenge, got %v", testcase.alg, testcase.kid, err)
+		}
+	}
+
+	// Rotate to a new key: tokens signed with it are accepted once the key
+	// set is reloaded for the unknown key ID, and the retired key is dropped.
+	rotatedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySetServer.setKeys(makeJWK("rotated", "ES256", rotatedKey.Public()))
+
+	if err := authorize(signTestJWT(t, "ES256", "rotated", rotatedKey, claims)); err != nil {
+		t.Fatalf("unexpected error authorizing token signed by rotated key: %v", err)
+	}
+
+	if err := authorize(signTestJWT(t, "ES256", "p256", p256Key, claims)); err == nil {
+		t.Fatal("expected token signed by retired key to be rejected")
+	}
+}
+
+func TestAccessControllerKeySetRejectsCertChain(t *testing.T) {
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCerts, err := makeRootCerts(rootKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Trust the root as a system root, like a public authority, if the
+	// system roots have not been loaded yet.
+	dir, err := ioutil.TempDir("", "token-roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "roots.pem")
+	if err := ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCerts[0].Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", bundle)
+	t.Setenv("SSL_CERT_DIR", dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySetServer := &testKeySetServer{}
+	keySetServer.setKeys(makeJWK("p256", "ES256", key.Public()))
+	server := httptest.NewServer(keySetServer)
+	defer server.Close()
+
+	issuer := "test-issuer.example.com"
+	service := "test-service.example.com"
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"realm":   "https://auth.example.com/token/",
+		"issuer":  issuer,
+		"service": service,
+		"jwks":    server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testAccess := auth.Access{
+		Resource: auth.Resource{
+			Type: "repository",
+			Name: "foo/bar",
+		},
+		Action: "pull",
+	}
+	access := []*ResourceActions{{
+		Type:    testAccess.Type,
+		Name:    testAccess.Name,
+		Actions: []string{testAccess.Action},
+	}}
+
+	token, err := makeTestToken(issuer, service, access, rootKeys[0], 1, time.Now(), time.Now().Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/v2/foo/bar/tags/list", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.Raw+"."+joseBase64UrlEncode(token.Signature)))
+
+	_, err = accessController.Authorized(context.WithRequest(context.Background(), req), testAccess)
+	if challenge, ok := err.(auth.Challenge); !ok || challenge.Error() != ErrInvalidToken.Error() {
+		t.Fatalf("expected invalid token challenge for a certificate chain without a root bundle, got %v", err)
+	}
+}
+
+func TestKeySetReloadDoesNotBlock(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keySetServer := &testKeySetServer{}
+	keySetServer.setKeys(makeJWK("p256", "ES256", key.Public()))
+
+	var blocked bool
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocked {
+			<-release
+		}
+		keySetServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ks, err := NewKeySet(server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the set stale and the source hang: cached keys must still be
+	// served while the reload is in flight.
+	blocked = true
+	ks.mu.Lock()
+	ks.fetched = time.Now().Add(-2 * time.Hour)
+	ks.mu.Unlock()
+
+	done := make(chan bool)
+	go func() {
+		_, _, ok := ks.Key("p256")
+		done <- ok
+	}()
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("expected cached key to be served during reload")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("key lookup blocked behind key set reload")
+	}
+}
+
+func TestNewKeySetErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	if _, err := NewKeySet(server.URL, 0); err == nil {
+		t.Fatal("expected error loading key set from missing URL")
+	}
+
+	keySetServer := &testKeySetServer{}
+	keySetServer.setKeys(jsonWebKey{KeyID: "enc", KeyType: "OKP", Curve: "X25519", X: "AA"})
+	server = httptest.NewServer(keySetServer)
+	defer server.Close()
+
+	if _, err := NewKeySet(server.URL, 0); err == nil {
+		t.Fatal("expected error loading key set with no usable keys")
+	}
+
+	if _, err := newAccessController(map[string]interface{}{
+		"realm":   "https://auth.example.com/token/",
+		"issuer":  "issuer",
+		"service": "service",
+	}); err == nil {
+		t.Fatal("expected error creating access controller without signing keys")
+	}
+}
@@ -0,0 +1,639 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/rbac"
+	"github.com/distribution/distribution/v3/uuid"
+	"github.com/docker/libtrust"
+)
+
+const (
+	// DefaultTokenExpiration is the lifetime of access tokens issued by the
+	// built-in token server when no expiration is configured.
+	DefaultTokenExpiration = 5 * time.Minute
+
+	// DefaultRefreshTokenExpiration is the lifetime of refresh tokens issued
+	// by the built-in token server when no expiration is configured.
+	DefaultRefreshTokenExpiration = 30 * 24 * time.Hour
+
+	// refreshTokenType is the JOSE header type of refresh tokens, which
+	// distinguishes them from access tokens signed by the same key.
+	refreshTokenType = "refresh+jwt"
+)
+
+// ErrorCodeInvalidTokenRequest is returned by the built-in token server when
+// a token request is malformed or uses an unsupported option.
+var ErrorCodeInvalidTokenRequest = errcode.Register("token", errcode.ErrorDescriptor{
+	Value:   "INVALID_TOKEN_REQUEST",
+	Message: "invalid token request",
+	Description: `Returned by the built-in token server when a token
+	request is missing a required parameter or contains an unsupported
+	value.`,
+	HTTPStatusCode: http.StatusBadRequest,
+})
+
+// tokenServer issues tokens for the access controller it is configured
+// with. Clients authenticate against an identity source and are granted the
+// subset of the requested scope which the policy allows.
+type tokenServer struct {
+	issuer            string
+	service           string
+	signingKey        libtrust.PrivateKey
+	expiration        time.Duration
+	refreshExpiration time.Duration
+	identity          auth.AccessController
+	policy            *rbac.PolicyFile
+}
+
+// tokenServerOptions is a convenience type for handling the "server" option
+// of the token access controller.
+type tokenServerOptions struct {
+	signingKey        string
+	expiration        time.Duration
+	refreshExpiration time.Duration
+	identity          interface{}
+	policy            string
+}
+
+// checkServerOptions gathers the options for the built-in token server.
+func checkServerOptions(opt interface{}) (tokenServerOptions, error) {
+	opts := tokenServerOptions{
+		expiration:        DefaultTokenExpiration,
+		refreshExpiration: DefaultRefreshTokenExpiration,
+	}
+
+	options := auth.ToStringMap(opt)
+	if options == nil {
+		return opts, fmt.Errorf("token auth requires a valid option map: server")
+	}
+
+	for key, val := range map[string]*string{
+		"signingkey": &opts.signingKey,
+		"policy":     &opts.policy,
+	} {
+		if v, ok := options[key]; ok {
+			s, ok := v.(string)
+			if !ok {
+				return opts, fmt.Errorf("token server requires a valid option string: %q", key)
+			}
+			*val = s
+		}
+	}
+
+	if opts.signingKey == "" {
+		return opts, fmt.Errorf("token server requires a valid option string: %q", "signingkey")
+	}
+
+	for key, val := range map[string]*time.Duration{
+		"expiration":        &opts.expiration,
+		"refreshexpiration": &opts.refreshExpiration,
+	} {
+		if v, ok := options[key]; ok {
+			d, err := parseDuration(v)
+			if err != nil || d <= 0 {
+				return opts, fmt.Errorf("token server requires a valid option duration: %q", key)
+			}
+			*val = d
+		}
+	}
+
+	opts.identity = options["identity"]
+
+	return opts, nil
+}
+
+// newTokenServer creates a token server issuing tokens for service, signed
+// as issuer.
+func newTokenServer(issuer, service string, opts tokenServerOptions) (*tokenServer, error) {
+	signingKey, err := libtrust.LoadKeyFile(opts.signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load token server signing key %q: %s", opts.signingKey, err)
+	}
+
+	if _, err := signingAlgorithm(signingKey); err != nil {
+		return nil, err
+	}
+
+	identity, err := auth.NewIdentitySource(opts.identity)
+	if err == auth.ErrNoIdentitySource {
+		return nil, fmt.Errorf("token server requires a valid option: %q", "identity")
+	} else if err != nil {
+		return nil, err
+	}
+
+	ts := &tokenServer{
+		issuer:            issuer,
+		service:           service,
+		signingKey:        signingKey,
+		expiration:        opts.expiration,
+		refreshExpiration: opts.refreshExpiration,
+		identity:          identity,
+	}
+
+	if opts.policy != "" {
+		ts.policy, err = rbac.NewPolicyFile(opts.policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ts, nil
+}
+
+// tokenResponse is the body of a successful token request. It carries the
+// fields expected by both the Docker token and the OAuth2 token flows.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	IssuedAt     string `json:"issued_at"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// ServeHTTP handles token requests. GET requests follow the Docker token
+// protocol with basic authentication; POST requests follow the OAuth2 flow
+// with the password and refresh_token grant types.
+func (ts *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The registry places the request on the context before routing it here.
+	ctx := r.Context()
+	if _, err := dcontext.GetRequest(ctx); err != nil {
+		ctx = dcontext.WithRequest(ctx, r)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ts.getToken(ctx, w, r)
+	case http.MethodPost:
+		ts.postToken(ctx, w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		serveError(ctx, w, errcode.ErrorCodeUnsupported)
+	}
+}
+
+// getToken handles a token request made with the Docker token protocol.
+func (ts *tokenServer) getToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	if err := ts.checkService(params.Get("service")); err != nil {
+		serveError(ctx, w, err)
+		return
+	}
+
+	requested, err := parseScopes(params["scope"])
+	if err != nil {
+		serveError(ctx, w, err)
+		return
+	}
+
+	authCtx, err := ts.identity.Authorized(ctx, requested...)
+	if err != nil {
+		ts.serveAuthError(ctx, w, r, err)
+		return
+	}
+
+	ts.issue(authCtx, w, authUser(authCtx), requested, params.Get("offline_token") == "true")
+}
+
+// postToken handles a token request made with the OAuth2 flow.
+func (ts *tokenServer) postToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		serveError(ctx, w, ErrorCodeInvalidTokenRequest.WithDetail(err.Error()))
+		return
+	}
+
+	if err := ts.checkService(r.PostForm.Get("service")); err != nil {
+		serveError(ctx, w, err)
+		return
+	}
+
+	if r.PostForm.Get("client_id") == "" {
+		serveError(ctx, w, ErrorCodeInvalidTokenRequest.WithDetail("missing client_id"))
+		return
+	}
+
+	requested, err := parseScopes(strings.Fields(r.PostForm.Get("scope")))
+	if err != nil {
+		serveError(ctx, w, err)
+		return
+	}
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "password":
+		// Present the credentials to the identity source as basic
+		// authentication, the way it sees them on registry requests.
+		req, err := dcontext.GetRequest(ctx)
+		if err != nil {
+			serveError(ctx, w, errcode.ErrorCodeUnknown.WithDetail(err.Error()))
+			return
+		}
+		req.SetBasicAuth(r.PostForm.Get("username"), r.PostForm.Get("password"))
+
+		authCtx, err := ts.identity.Authorized(ctx, requested...)
+		if err != nil {
+			ts.serveAuthError(ctx, w, r, err)
+			return
+		}
+
+		ts.issue(authCtx, w, authUser(authCtx), requested, r.PostForm.Get("access_type") == "offline")
+	case "refresh_token":
+		user, err := ts.verifyRefreshToken(ctx, r.PostForm.Get("refresh_token"), requested)
+		if err == auth.ErrAccessDenied {
+			serveError(ctx, w, errcode.ErrorCodeDenied)
+			return
+		}
+		if err != nil {
+			dcontext.GetLogger(ctx).Infof("token server: rejected refresh token: %v", err)
+			serveError(ctx, w, errcode.ErrorCodeUnauthorized.WithDetail("invalid refresh token"))
+			return
+		}
+
+		ctx = auth.WithUser(ctx, user)
+		ts.issue(ctx, w, user, requested, false)
+	default:
+		serveError(ctx, w, ErrorCodeInvalidTokenRequest.WithDetail(fmt.Sprintf("unsupported grant_type %q", grantType)))
+	}
+}
+
+// checkService ensures that a token request is for the service tokens are
+// issued for.
+func (ts *tokenServer) checkService(service string) error {
+	if service != "" && service != ts.service {
+		return ErrorCodeInvalidTokenRequest.WithDetail(fmt.Sprintf("unknown service %q", service))
+	}
+
+	return nil
+}
+
+// serveAuthError responds to a request the identity source failed to
+// authenticate.
+func (ts *tokenServer) serveAuthError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	if challenge, ok := err.(auth.Challenge); ok {
+		challenge.SetHeaders(r, w)
+		serveError(ctx, w, errcode.ErrorCodeUnauthorized.WithDetail(challenge.Error()))
+		return
+	}
+
+	if err == auth.ErrAccessDenied {
+		serveError(ctx, w, errcode.ErrorCodeDenied)
+		return
+	}
+
+	dcontext.GetLogger(ctx).Errorf("token server: error authenticating request: %v", err)
+	serveError(ctx, w, errcode.ErrorCodeUnauthorized)
+}
+
+// issue grants user the part of the requested access allowed by the policy
+// and responds with a signed access token, along with a refresh token if
+// offline access was requested and the identity source can verify the user
+// when the refresh token is used.
+func (ts *tokenServer) issue(ctx context.Context, w http.ResponseWriter, user auth.UserInfo, requested []auth.Access, offline bool) {
+	subject := user.Name
+	granted, err := ts.grant(subject, requested)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("token server: error evaluating policy: %v", err)
+		serveError(ctx, w, errcode.ErrorCodeUnknown)
+		return
+	}
+
+	now := time.Now()
+
+	accessToken, err := ts.createToken(now, "JWT", auth.UserInfo{Name: subject}, ts.expiration, granted)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("token server: error creating token: %v", err)
+		serveError(ctx, w, errcode.ErrorCodeUnknown)
+		return
+	}
+
+	resp := tokenResponse{
+		Token:       accessToken,
+		AccessToken: accessToken,
+		ExpiresIn:   int(ts.expiration / time.Second),
+		IssuedAt:    now.UTC().Format(time.RFC3339),
+		Scope:       scopeString(granted),
+	}
+
+	// Refresh tokens identify a user; anonymous clients have nothing to
+	// refresh. Users the identity source cannot verify later would keep
+	// refreshing after their removal, so they get none either.
+	if _, ok := ts.identity.(auth.UserVerifier); offline && subject != "" && ok {
+		resp.RefreshToken, err = ts.createToken(now, refreshTokenType, user, ts.refreshExpiration, nil)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("token server: error creating refresh token: %v", err)
+			serveError(ctx, w, errcode.ErrorCodeUnknown)
+			return
+		}
+	}
+
+	dcontext.GetLogger(ctx).Infof("token server: issued token to %q for %q", subject, resp.Scope)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		dcontext.GetLogger(ctx).Errorf("token server: error encoding response: %v", err)
+	}
+}
+
+// grant returns the requested access the policy allows subject. Without a
+// policy, and for anonymous requests the identity source allowed, all of the
+// requested access is granted.
+func (ts *tokenServer) grant(subject string, requested []auth.Access) ([]*ResourceActions, error) {
+	var allowed []auth.Access
+	for _, access := range requested {
+		if ts.policy != nil && subject != "" {
+			ok, err := ts.policy.Allowed(subject, access)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		allowed = append(allowed, access)
+	}
+
+	return toResourceActions(allowed), nil
+}
+
+// pullable returns a function reporting whether the user of ctx would be
+// granted pull access to a repository. Without a policy, and for anonymous
+// requests, the identity source decides.
+func (ts *tokenServer) pullable(ctx context.Context) (func(name string) bool, error) {
+	if subject := authUser(ctx).Name; ts.policy != nil && subject != "" {
+		return ts.policy.Pullable(subject)
+	}
+
+	filter, ok := ts.identity.(auth.RepositoryFilter)
+	if !ok {
+		return nil, fmt.Errorf("token server: identity source cannot filter repositories")
+	}
+	return filter.PullableRepositories(ctx)
+}
+
+// createToken creates a token of the given type for user, granting access.
+func (ts *tokenServer) createToken(now time.Time, typ string, user auth.UserInfo, expiration time.Duration, access []*ResourceActions) (string, error) {
+	alg, err := signingAlgorithm(ts.signingKey)
+	if err != nil {
+		return "", err
+	}
+
+	header := Header{
+		Type:       typ,
+		SigningAlg: alg,
+		KeyID:      ts.signingKey.KeyID(),
+	}
+
+	claims := ClaimSet{
+		Issuer:     ts.issuer,
+		Subject:    user.Name,
+		Audience:   ts.service,
+		Expiration: now.Add(expiration).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		JWTID:      uuid.Generate().String(),
+		Access:     access,
+		Credential: user.Credential,
+	}
+
+	if claims.Access == nil {
+		claims.Access = []*ResourceActions{}
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := joseBase64UrlEncode(headerJSON) + TokenSeparator + joseBase64UrlEncode(claimsJSON)
+
+	signature, signedAlg, err := ts.signingKey.Sign(strings.NewReader(payload), crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	if signedAlg != alg {
+		return "", fmt.Errorf("token signed with %s, expected %s", signedAlg, alg)
+	}
+
+	return payload + TokenSeparator + joseBase64UrlEncode(signature), nil
+}
+
+// verifyRefreshToken checks that raw is a refresh token issued by this
+// server which is still valid, and that the identity source still
+// authenticates the user it was issued to, returning that user. The identity
+// source must also authorize the requested access, as it does for password
+// grants, or ErrAccessDenied is returned.
+func (ts *tokenServer) verifyRefreshToken(ctx context.Context, raw string, requested []auth.Access) (auth.UserInfo, error) {
+	if raw == "" {
+		return auth.UserInfo{}, errors.New("missing refresh token")
+	}
+
+	token, err := NewToken(raw)
+	if err != nil {
+		return auth.UserInfo{}, err
+	}
+
+	if token.Header.Type != refreshTokenType {
+		return auth.UserInfo{}, errors.New("not a refresh token")
+	}
+
+	err = token.Verify(VerifyOptions{
+		TrustedIssuers:    []string{ts.issuer},
+		AcceptedAudiences: []string{ts.service},
+		TrustedKeys:       map[string]libtrust.PublicKey{ts.signingKey.KeyID(): ts.signingKey.PublicKey()},
+	})
+	if err != nil {
+		return auth.UserInfo{}, err
+	}
+
+	if token.Claims.Subject == "" {
+		return auth.UserInfo{}, errors.New("refresh token has no subject")
+	}
+
+	user := auth.UserInfo{Name: token.Claims.Subject, Credential: token.Claims.Credential}
+
+	verifier, ok := ts.identity.(auth.UserVerifier)
+	if !ok {
+		return auth.UserInfo{}, errors.New("identity source cannot verify users")
+	}
+	if err := verifier.VerifyUser(ctx, user, requested...); err != nil {
+		if err == auth.ErrAccessDenied {
+			dcontext.GetLogger(ctx).Warnf("token server: user %q denied the refreshed access", user.Name)
+			return auth.UserInfo{}, err
+		}
+		return auth.UserInfo{}, fmt.Errorf("user %q no longer authenticates: %v", user.Name, err)
+	}
+
+	return user, nil
+}
+
+// signingAlgorithm returns the JWS algorithm used to sign tokens with key.
+func signingAlgorithm(key libtrust.PrivateKey) (string, error) {
+	switch key.KeyType() {
+	case "RSA":
+		return "RS256", nil
+	case "EC":
+		pub, ok := key.CryptoPublicKey().(*ecdsa.PublicKey)
+		if !ok {
+			break
+		}
+
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			return "ES256", nil
+		case 384:
+			return "ES384", nil
+		case 521:
+			return "ES512", nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported token server signing key type %s", key.KeyType())
+}
+
+// parseScopes parses scopes of the form type[(class)]:name:actions into the
+// access they request.
+func parseScopes(scopes []string) ([]auth.Access, error) {
+	var accessRecords []auth.Access
+
+	for _, scopeList := range scopes {
+		for _, scope := range strings.Fields(scopeList) {
+			first, last := strings.Index(scope, ":"), strings.LastIndex(scope, ":")
+			if first <= 0 || first == last || last == len(scope)-1 {
+				return nil, ErrorCodeInvalidTokenRequest.WithDetail(fmt.Sprintf("invalid scope %q", scope))
+			}
+
+			resourceType, resourceName, actions := scope[:first], scope[first+1:last], scope[last+1:]
+
+			var resourceClass string
+			if i := strings.Index(resourceType, "("); i > 0 && strings.HasSuffix(resourceType, ")") {
+				resourceType, resourceClass = resourceType[:i], resourceType[i+1:len(resourceType)-1]
+			}
+
+			for _, action := range strings.Split(actions, ",") {
+				if action == "" {
+					continue
+				}
+
+				accessRecords = append(accessRecords, auth.Access{
+					Resource: auth.Resource{
+						Type:  resourceType,
+						Class: resourceClass,
+						Name:  resourceName,
+					},
+					Action: action,
+				})
+			}
+		}
+	}
+
+	return accessRecords, nil
+}
+
+// toResourceActions groups access records by resource, in the form they are
+// carried in token claims.
+func toResourceActions(accessRecords []auth.Access) []*ResourceActions {
+	resourceActions := []*ResourceActions{}
+	index := make(map[auth.Resource]*ResourceActions)
+
+	for _, access := range accessRecords {
+		ra, ok := index[access.Resource]
+		if !ok {
+			ra = &ResourceActions{
+				Type:  access.Type,
+				Class: access.Class,
+				Name:  access.Name,
+			}
+			index[access.Resource] = ra
+			resourceActions = append(resourceActions, ra)
+		}
+
+		if !contains(ra.Actions, access.Action) {
+			ra.Actions = append(ra.Actions, access.Action)
+		}
+	}
+
+	for _, ra := range resourceActions {
+		sort.Strings(ra.Actions)
+	}
+
+	return resourceActions
+}
+
+// scopeString formats granted access as a space separated list of scopes.
+func scopeString(resourceActions []*ResourceActions) string {
+	scopes := make([]string, 0, len(resourceActions))
+	for _, ra := range resourceActions {
+		resourceType := ra.Type
+		if ra.Class != "" {
+			resourceType = fmt.Sprintf("%s(%s)", ra.Type, ra.Class)
+		}
+		scopes = append(scopes, fmt.Sprintf("%s:%s:%s", resourceType, ra.Name, strings.Join(ra.Actions, ",")))
+	}
+
+	return strings.Join(scopes, " ")
+}
+
+// authUser returns the user authenticated in ctx, whose name is empty for
+// anonymous requests.
+func authUser(ctx context.Context) auth.UserInfo {
+	user, _ := ctx.Value(auth.UserKey).(auth.UserInfo)
+	return user
+}
+
+// endpointPath returns the path the token server is reached at: the path of
+// the realm, or the path used when the realm is derived from the request.
+func endpointPath(realm string, autoRedirect bool) (string, error) {
+	if autoRedirect {
+		return "/auth/token", nil
+	}
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("token server requires a valid realm URL: %v", err)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		return "", fmt.Errorf("token server requires a realm URL with a path")
+	}
+
+	return u.Path, nil
+}
+
+// parseDuration accepts a duration option given as a string, such as "5m",
+// or as a time.Duration.
+func parseDuration(v interface{}) (time.Duration, error) {
+	switch v := v.(type) {
+	case string:
+		return time.ParseDuration(v)
+	case time.Duration:
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("invalid duration %v", v)
+}
+
+func serveError(ctx context.Context, w http.ResponseWriter, err error) {
+	if serveErr := errcode.ServeJSON(w, err); serveErr != nil {
+		dcontext.GetLogger(ctx).Errorf("token server: error sending error response: %v", serveErr)
+	}
+}
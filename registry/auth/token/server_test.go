@@ -0,0 +1,453 @@
+package token
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	"github.com/distribution/distribution/v3/registry/auth/robot"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/docker/libtrust"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testServerPolicy = `
+rules:
+  - users: [alice]
+    repositories: ["alice/**"]
+    actions: ["*"]
+  - users: ["*"]
+    repositories: ["library/*"]
+    actions: [pull]
+`
+
+// newTestTokenServer starts a registry token endpoint backed by an htpasswd
+// file holding the user alice, returning the server, the access controller
+// validating its tokens and the path of the htpasswd file.
+func newTestTokenServer(t *testing.T) (*httptest.Server, auth.AccessController, string) {
+	dir := t.TempDir()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "key.json")
+	if err := libtrust.SaveKey(keyPath, key); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	if err := ioutil.WriteFile(htpasswdPath, []byte("alice:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	policyPath := filepath.Join(dir, "policy.yml")
+	if err := ioutil.WriteFile(policyPath, []byte(testServerPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":   "https://registry.example.com/auth/token",
+		"issuer":  "registry.example.com",
+		"service": "registry.example.com",
+		"server": map[interface{}]interface{}{
+			"signingkey": keyPath,
+			"expiration": "1m",
+			"policy":     policyPath,
+			"identity": map[interface{}]interface{}{
+				"htpasswd": map[interface{}]interface{}{
+					"realm":          "test-realm",
+					"path":           htpasswdPath,
+					"reloadinterval": "10ms",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, handler := ac.(auth.EndpointProvider).Endpoint()
+	if path != "/auth/token" {
+		t.Fatalf("unexpected endpoint path: %q", path)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server, ac, htpasswdPath
+}
+
+func decodeTokenResponse(t *testing.T, resp *http.Response) tokenResponse {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("unexpected token response status %v: %s", resp.Status, body)
+	}
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		t.Fatal(err)
+	}
+
+	return tr
+}
+
+// authorize checks the access token against the access controller.
+func authorize(ac auth.AccessController, token string, access ...auth.Access) error {
+	req, err := http.NewRequest(http.MethodGet, "https://registry.example.com/v2/", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	_, err = ac.Authorized(context.WithRequest(context.Background(), req), access...)
+	return err
+}
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+}
+
+func TestTokenServerGet(t *testing.T) {
+	server, ac, _ := newTestTokenServer(t)
+
+	params := url.Values{
+		"service":       {"registry.example.com"},
+		"scope":         {"repository:alice/app:pull,push", "repository:bob/app:pull"},
+		"offline_token": {"true"},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/auth/token?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := decodeTokenResponse(t, resp)
+
+	if tr.Token == "" || tr.Token != tr.AccessToken {
+		t.Fatalf("expected matching token and access_token, got %q and %q", tr.Token, tr.AccessToken)
+	}
+	if tr.ExpiresIn != 60 {
+		t.Errorf("unexpected expires_in: %d", tr.ExpiresIn)
+	}
+	if tr.Scope != "repository:alice/app:pull,push" {
+		t.Errorf("unexpected granted scope: %q", tr.Scope)
+	}
+	if tr.RefreshToken == "" {
+		t.Error("expected refresh token for offline token request")
+	}
+
+	if err := authorize(ac, tr.AccessToken, repositoryAccess("alice/app", "push")); err != nil {
+		t.Errorf("unexpected error authorizing granted access: %v", err)
+	}
+	if err := authorize(ac, tr.AccessToken, repositoryAccess("bob/app", "pull")); err == nil {
+		t.Error("expected access outside of the policy to be denied")
+	}
+
+	// Missing and wrong credentials are challenged.
+	for _, password := range []string{"", "wrong"} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/auth/token?"+params.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if password != "" {
+			req.SetBasicAuth("alice", password)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("password %q: unexpected status %v", password, resp.Status)
+		}
+		if challenge := resp.Header.Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "Basic") {
+			t.Errorf("password %q: unexpected challenge %q", password, challenge)
+		}
+	}
+
+	// Tokens are only issued for the configured service.
+	params.Set("service", "other.example.com")
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/auth/token?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "secret")
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status for unknown service: %v", resp.Status)
+	}
+}
+
+func TestTokenServerOAuth(t *testing.T) {
+	server, ac, _ := newTestTokenServer(t)
+
+	resp, err := http.PostForm(server.URL+"/auth/token", url.Values{
+		"grant_type":  {"password"},
+		"service":     {"registry.example.com"},
+		"client_id":   {"test"},
+		"access_type": {"offline"},
+		"username":    {"alice"},
+		"password":    {"secret"},
+		"scope":       {"repository:library/alpine:pull,push"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := decodeTokenResponse(t, resp)
+
+	if tr.Scope != "repository:library/alpine:pull" {
+		t.Errorf("unexpected granted scope: %q", tr.Scope)
+	}
+	if tr.IssuedAt == "" {
+		t.Error("expected issued_at in response")
+	}
+	if tr.RefreshToken == "" {
+		t.Fatal("expected refresh token for offline access")
+	}
+
+	// A refresh token is not an access token.
+	if err := authorize(ac, tr.RefreshToken); err == nil {
+		t.Error("expected refresh token to be rejected as an access token")
+	}
+
+	resp, err = http.PostForm(server.URL+"/auth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"service":       {"registry.example.com"},
+		"client_id":     {"test"},
+		"refresh_token": {tr.RefreshToken},
+		"scope":         {"repository:alice/app:push"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed := decodeTokenResponse(t, resp)
+
+	if err := authorize(ac, refreshed.AccessToken, repositoryAccess("alice/app", "push")); err != nil {
+		t.Errorf("unexpected error authorizing refreshed token: %v", err)
+	}
+
+	for _, testcase := range []struct {
+		form   url.Values
+		status int
+	}{
+		{url.Values{"grant_type": {"refresh_token"}, "client_id": {"test"}, "refresh_token": {tr.AccessToken}}, http.StatusUnauthorized},
+		{url.Values{"grant_type": {"refresh_token"}, "client_id": {"test"}, "refresh_token": {"garbage"}}, http.StatusUnauthorized},
+		{url.Values{"grant_type": {"password"}, "client_id": {"test"}, "username": {"alice"}, "password": {"wrong"}}, http.StatusUnauthorized},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"test"}}, http.StatusBadRequest},
+		{url.Values{"grant_type": {"password"}, "username": {"alice"}, "password": {"secret"}}, http.StatusBadRequest},
+		{url.Values{"grant_type": {"password"}, "client_id": {"test"}, "username": {"alice"}, "password": {"secret"}, "scope": {"repository"}}, http.StatusBadRequest},
+	} {
+		resp, err := http.PostForm(server.URL+"/auth/token", testcase.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != testcase.status {
+			t.Errorf("%v: expected status %d, got %v", testcase.form, testcase.status, resp.Status)
+		}
+	}
+}
+
+func TestTokenServerRefreshRemovedUser(t *testing.T) {
+	server, _, htpasswdPath := newTestTokenServer(t)
+
+	resp, err := http.PostForm(server.URL+"/auth/token", url.Values{
+		"grant_type":  {"password"},
+		"service":     {"registry.example.com"},
+		"client_id":   {"test"},
+		"access_type": {"offline"},
+		"username":    {"alice"},
+		"password":    {"secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := decodeTokenResponse(t, resp)
+	if tr.RefreshToken == "" {
+		t.Fatal("expected refresh token for offline access")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(htpasswdPath, []byte("bob:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once alice is removed from the identity source, her refresh token
+	// stops working.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resp, err := http.PostForm(server.URL+"/auth/token", url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {"test"},
+			"refresh_token": {tr.RefreshToken},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected refresh token of removed user to be rejected, got %v", resp.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestTokenServerRefreshScopedCredential(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "key.json")
+	if err := libtrust.SaveKey(keyPath, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a policy, the credential alone limits the access granted.
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":   "https://registry.example.com/auth/token",
+		"issuer":  "registry.example.com",
+		"service": "registry.example.com",
+		"server": map[interface{}]interface{}{
+			"signingkey": keyPath,
+			"identity": map[interface{}]interface{}{
+				"robot": map[interface{}]interface{}{"realm": "test-realm"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := robot.NewDriverStore(inmemory.New(), "/credentials")
+	cred, secret, err := robot.NewCredential(robot.KindRobot, "ci-bot", []robot.Scope{{Repositories: []string{"ci/**"}, Actions: []string{"pull"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), cred); err != nil {
+		t.Fatal(err)
+	}
+
+	path, handler := ac.(auth.EndpointProvider).Endpoint()
+	mux := http.NewServeMux()
+	mux.Handle(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(robot.WithStore(r.Context(), store)))
+	}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+path, url.Values{
+		"grant_type":  {"password"},
+		"client_id":   {"test"},
+		"access_type": {"offline"},
+		"scope":       {"repository:ci/app:pull"},
+		"username":    {"ci-bot"},
+		"password":    {secret},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := decodeTokenResponse(t, resp)
+	if tr.RefreshToken == "" {
+		t.Fatal("expected refresh token for offline access")
+	}
+
+	refresh := func(scope string) *http.Response {
+		resp, err := http.PostForm(server.URL+path, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {"test"},
+			"scope":         {scope},
+			"refresh_token": {tr.RefreshToken},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	refreshed := decodeTokenResponse(t, refresh("repository:ci/other:pull"))
+	if err := authorize(ac, refreshed.AccessToken, repositoryAccess("ci/other", "pull")); err != nil {
+		t.Errorf("unexpected error authorizing refreshed token: %v", err)
+	}
+
+	// Refreshing cannot widen the scopes of the credential.
+	for _, scope := range []string{"repository:ci/app:push", "repository:other/app:pull"} {
+		resp := refresh(scope)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected refresh beyond the credential scopes to be denied, got %v", scope, resp.Status)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	accessRecords, err := parseScopes([]string{"repository(plugin):a/b:pull,push registry:catalog:*", "repository:localhost:5000/c:pull"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, access := range accessRecords {
+		got = append(got, fmt.Sprintf("%s/%s/%s/%s", access.Type, access.Class, access.Name, access.Action))
+	}
+
+	expected := []string{
+		"repository/plugin/a/b/pull",
+		"repository/plugin/a/b/push",
+		"registry//catalog/*",
+		"repository//localhost:5000/c/pull",
+	}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected access records: %v", got)
+	}
+
+	if scope := scopeString(toResourceActions(accessRecords[:2])); scope != "repository(plugin):a/b:pull,push" {
+		t.Errorf("unexpected scope string: %q", scope)
+	}
+
+	for _, invalid := range []string{"repository", "repository:a", ":a:pull", "repository:a:"} {
+		if _, err := parseScopes([]string{invalid}); err == nil {
+			t.Errorf("expected error parsing scope %q", invalid)
+		}
+	}
+}
@@ -52,6 +52,11 @@ type ClaimSet struct {
 
 	// Private claims
 	Access []*ResourceActions `json:"access"`
+
+	// Credential is set on refresh tokens issued to a user who authenticated
+	// with one of several credentials, such as a robot credential, so that
+	// revoking the credential ends the refresh token too.
+	Credential string `json:"cred,omitempty"`
 }
 
 // Header describes the header section of a JSON Web Token.
@@ -78,6 +83,7 @@ type VerifyOptions struct {
 	AcceptedAudiences []string
 	Roots             *x509.CertPool
 	TrustedKeys       map[string]libtrust.PublicKey
+	KeySet            *KeySet
 }
 
 // NewToken parses the given raw token string
@@ -167,6 +173,24 @@ func (t *Token) Verify(verifyOpts VerifyOptions) error {
 		return ErrInvalidToken
 	}
 
+	// Tokens identifying their signing key by ID alone may be signed by a
+	// key from the configured key set.
+	if verifyOpts.KeySet != nil && len(t.Header.X5c) == 0 && t.Header.RawJWK == nil && t.Header.KeyID != "" {
+		if key, alg, ok := verifyOpts.KeySet.Key(t.Header.KeyID); ok {
+			if alg != "" && alg != t.Header.SigningAlg {
+				log.Infof("token signing algorithm %q does not match key algorithm %q", t.Header.SigningAlg, alg)
+				return ErrInvalidToken
+			}
+
+			if err := verifySignature(t.Header.SigningAlg, key, t.Raw, t.Signature); err != nil {
+				log.Infof("unable to verify token signature: %s", err)
+				return ErrInvalidToken
+			}
+
+			return nil
+		}
+	}
+
 	// Verify that the signing key is trusted.
 	signingKey, err := t.VerifySigningKey(verifyOpts)
 	if err != nil {
@@ -224,6 +248,12 @@ func parseAndVerifyCertChain(x5c []string, roots *x509.CertPool) (leafKey libtru
 		return nil, errors.New("empty x509 certificate chain")
 	}
 
+	// Without roots, the chain would be verified against the system roots,
+	// which would trust any certificate of a public authority.
+	if roots == nil {
+		return nil, errors.New("no trusted root certificates to verify the x509 certificate chain")
+	}
+
 	// Ensure the first element is encoded correctly.
 	leafCertDer, err := base64.StdEncoding.DecodeString(x5c[0])
 	if err != nil {
@@ -258,6 +258,58 @@ func (t *tags) All(ctx context.Context) ([]string, error) {
 	}
 }
 
+// List issues a single paginated request for at most limit tags after last.
+// If limit is negative, every page is fetched.
+func (t *tags) List(ctx context.Context, limit int, last string) ([]string, error) {
+	if limit < 0 {
+		all, err := t.All(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		tags := []string{}
+		for _, tag := range all {
+			if last == "" || tag > last {
+				tags = append(tags, tag)
+			}
+		}
+		return tags, nil
+	}
+
+	values := url.Values{}
+	values.Set("n", strconv.Itoa(limit))
+	if last != "" {
+		values.Set("last", last)
+	}
+
+	listURLStr, err := t.ub.BuildTagsURL(t.name, values)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", listURLStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !SuccessStatus(resp.StatusCode) {
+		return nil, HandleErrorResponse(resp)
+	}
+
+	tagsResponse := struct {
+		Tags []string `json:"tags"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tagsResponse); err != nil {
+		return nil, err
+	}
+	return tagsResponse.Tags, nil
+}
+
 func descriptorFromResponse(response *http.Response) (distribution.Descriptor, error) {
 	desc := distribution.Descriptor{}
 	headers := response.Header
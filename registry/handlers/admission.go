@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/admission"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+)
+
+// configureAdmission sets up the webhooks reviewing manifest pushes.
+func (app *App) configureAdmission(config *configuration.Configuration) error {
+	var webhooks []*admission.Webhook
+	for _, whc := range config.Admission.Webhooks {
+		if whc.Disabled {
+			dcontext.GetLogger(app).Infof("admission webhook %q disabled, skipping", whc.Name)
+			continue
+		}
+
+		webhook, err := admission.NewWebhook(whc.Name, whc.URL, admission.WebhookConfig{
+			Headers:      whc.Headers,
+			Timeout:      whc.Timeout,
+			FailOpen:     whc.FailOpen,
+			Repositories: whc.Repositories,
+		})
+		if err != nil {
+			return err
+		}
+
+		dcontext.GetLogger(app).Infof("configuring admission webhook %q, url=%s", whc.Name, whc.URL)
+		webhooks = append(webhooks, webhook)
+	}
+
+	if len(webhooks) > 0 {
+		app.admission = admission.NewController(webhooks...)
+	}
+
+	return nil
+}
+
+// admit reviews the manifest being pushed with the admission webhooks,
+// returning a DENIED error if it is rejected.
+func (imh *manifestHandler) admit(r *http.Request, manifest distribution.Manifest, desc distribution.Descriptor, payload []byte) error {
+	if imh.App.admission == nil {
+		return nil
+	}
+
+	req := &admission.Request{
+		RequestID:  dcontext.GetRequestID(imh),
+		Repository: imh.Repository.Named().Name(),
+		Tag:        imh.Tag,
+		Digest:     desc.Digest,
+		MediaType:  desc.MediaType,
+		Manifest:   payload,
+		References: manifest.References(),
+		Actor: admission.Actor{
+			Name: getUserName(imh, r),
+			Addr: dcontext.RemoteIP(r),
+		},
+	}
+
+	if err := imh.App.admission.Admit(imh, req); err != nil {
+		if denied, ok := err.(admission.DeniedError); ok {
+			return errcode.ErrorCodeDenied.WithMessage(denied.Message).WithDetail(map[string]string{
+				"webhook": denied.Webhook,
+			})
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/schema1"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/admission"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestAdmissionWebhooks(t *testing.T) {
+	var reviewed []admission.Request
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req admission.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid admission request: %v", err)
+		}
+		reviewed = append(reviewed, req)
+
+		resp := admission.Response{Allowed: true}
+		if req.Tag == "rejected" {
+			resp = admission.Response{Message: "image has critical vulnerabilities"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer webhook.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Admission: configuration.Admission{
+			Webhooks: []configuration.AdmissionWebhook{
+				{Name: "scanner", URL: webhook.URL},
+				{Name: "unreachable", URL: "http://127.0.0.1:1/", FailOpen: true},
+			},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	dgst := createRepository(env, t, "foo/admitted", "accepted")
+
+	if len(reviewed) != 1 {
+		t.Fatalf("expected one admission review, got %d", len(reviewed))
+	}
+	if reviewed[0].Repository != "foo/admitted" || reviewed[0].Tag != "accepted" || reviewed[0].Digest != dgst ||
+		len(reviewed[0].References) != 1 || len(reviewed[0].Manifest) == 0 {
+		t.Errorf("unexpected admission review: %+v", reviewed[0])
+	}
+
+	imageName, _ := reference.WithName("foo/admitted")
+	tagRef, _ := reference.WithTag(imageName, "rejected")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedManifest, err := schema1.Sign(&schema1.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 1},
+		Name:      "foo/admitted",
+		Tag:       "rejected",
+		FSLayers:  []schema1.FSLayer{{BlobSum: digest.FromString("layer")}},
+		History:   []schema1.History{{V1Compatibility: ""}},
+	}, env.pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := putManifest(t, "putting rejected manifest", manifestURL, "", signedManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting rejected manifest", resp, http.StatusForbidden)
+	errs, _, _ := checkBodyHasErrorCodes(t, "putting rejected manifest", resp, errcode.ErrorCodeDenied)
+	if len(errs) != 1 || errs[0].(errcode.Error).Message != "image has critical vulnerabilities" {
+		t.Errorf("expected webhook message in error, got %v", errs)
+	}
+	// Pushes the registry rejects by itself are not reviewed.
+	reviews := len(reviewed)
+	acceptedRef, _ := reference.WithTag(imageName, "accepted")
+	acceptedURL, err := env.builder.BuildManifestURL(acceptedRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, err := signedManifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, acceptedURL, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", schema1.MediaTypeSignedManifest)
+	req.Header.Set("If-None-Match", "*")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest over existing tag", resp, http.StatusPreconditionFailed)
+	if len(reviewed) != reviews {
+		t.Errorf("expected push failing its preconditions not to be reviewed, got %d reviews", len(reviewed)-reviews)
+	}
+}
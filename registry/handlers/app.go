@@ -24,18 +24,24 @@ import (
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/admission"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/audit"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/robot"
+	"github.com/distribution/distribution/v3/registry/lock"
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/signature"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/distribution/distribution/v3/registry/storage/metadata"
 	"github.com/distribution/distribution/v3/version"
 	events "github.com/docker/go-events"
 	"github.com/docker/go-metrics"
@@ -43,6 +49,7 @@ import (
 	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 )
 
 // randomSecretSize is the number of random bytes to generate if no secret
@@ -64,6 +71,7 @@ type App struct {
 	driver           storagedriver.StorageDriver    // driver maintains the app global storage driver instance.
 	registry         distribution.Namespace         // registry is the primary registry backend for the app instance.
 	repoRemover      distribution.RepositoryRemover // repoRemover provides ability to delete repos
+	deleteEnabled    bool                           // deleteEnabled allows deleting manifests and repositories
 	accessController auth.AccessController          // main access controller for application
 
 	// httpHost is a parsed representation of the http.host parameter from
@@ -74,10 +82,32 @@ type App struct {
 	events struct {
 		sink   events.Sink
 		source notifications.SourceRecord
+		recent *notifications.RecentEvents
 	}
 
 	redis *redis.Pool
 
+	// credentials stores robot accounts and personal access tokens.
+	credentials robot.Store
+
+	// rateLimits limits the requests of clients.
+	rateLimits rateLimits
+
+	// audit receives the audit log, if it is enabled.
+	audit audit.Sink
+
+	// admission reviews manifest pushes, if webhooks are configured.
+	admission *admission.Controller
+
+	// signaturePolicies require the manifests of repositories to be signed.
+	signaturePolicies []*signature.Policy
+
+	// immutableTags are the rules making tags immutable.
+	immutableTags []immutableTagRule
+
+	// tagLocks serialize the updates of each tag.
+	tagLocks lock.Locker
+
 	// trustKey is a deprecated key used to sign manifests converted to
 	// schema1 for backward compatibility. It should not be used for any
 	// other purposes.
@@ -112,6 +142,9 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
 	app.register(v2.RouteNameUCD, ucdDispatcher)
+	app.register(v2.RouteNameRepository, repositoryDispatcher)
+	app.register(v2.RouteNameBulkDelete, bulkDeleteDispatcher)
+	app.register(v2.RouteNameCopy, copyDispatcher)
 
 	// override the storage driver's UA string for registry outbound HTTP requests
 	storageParams := config.Storage.Parameters()
@@ -161,8 +194,32 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.configureSecret(config)
 	app.configureEvents(config)
 	app.configureRedis(config)
+	if err := app.configureCredentials(config); err != nil {
+		panic(fmt.Sprintf("unable to configure credentials: %v", err))
+	}
+	app.configureTagLocks()
 	app.configureLogHook(config)
 
+	if err := app.configureRateLimits(config); err != nil {
+		panic(err)
+	}
+
+	if err := app.configureAudit(config); err != nil {
+		panic(fmt.Sprintf("unable to configure audit log: %v", err))
+	}
+
+	if err := app.configureAdmission(config); err != nil {
+		panic(fmt.Sprintf("unable to configure admission webhooks: %v", err))
+	}
+
+	if err := app.configureSignatures(config); err != nil {
+		panic(fmt.Sprintf("unable to configure signature policies: %v", err))
+	}
+
+	if err := app.configureImmutableTags(config); err != nil {
+		panic(fmt.Sprintf("unable to configure immutable tags: %v", err))
+	}
+
 	options := registrymiddleware.GetRegistryOptions()
 	if config.Compatibility.Schema1.TrustKey != "" {
 		app.trustKey, err = libtrust.LoadKeyFile(config.Compatibility.Schema1.TrustKey)
@@ -202,6 +259,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		if ok {
 			if deleteEnabled, ok := e.(bool); ok && deleteEnabled {
 				options = append(options, storage.EnableDelete)
+				app.deleteEnabled = true
 			}
 		}
 	}
@@ -260,6 +318,16 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	// configure the metadata store
+	if mc, ok := config.Storage["metadata"]; ok {
+		metadataStore, err := metadata.Create(mc)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure metadata store: %v", err))
+		}
+		options = append(options, storage.MetadataStore(metadataStore))
+		dcontext.GetLogger(app).Infof("using %v metadata store", mc["database"])
+	}
+
 	// configure storage caches
 	if cc, ok := config.Storage["cache"]; ok {
 		v, ok := cc["blobdescriptor"]
@@ -317,6 +385,13 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 		app.accessController = accessController
 		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
+
+		if provider, ok := accessController.(auth.EndpointProvider); ok {
+			if path, handler := provider.Endpoint(); handler != nil {
+				app.router.Path(path).Handler(handler)
+				dcontext.GetLogger(app).Infof("serving %q access controller endpoint at %s", authType, path)
+			}
+		}
 	}
 
 	// configure as a pull through cache
@@ -469,6 +544,11 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 		sinks = append(sinks, endpoint)
 	}
 
+	if configuration.Notifications.Recent.Enabled {
+		app.events.recent = notifications.NewRecentEvents(configuration.Notifications.Recent.Size)
+		sinks = append(sinks, app.events.recent)
+	}
+
 	// NOTE(stevvooe): Moving to a new queuing implementation is as easy as
 	// replacing broadcaster with a rabbitmq implementation. It's recommended
 	// that the registry instances also act as the workers to keep deployment
@@ -493,6 +573,81 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	}
 }
 
+// RecentEvents returns the buffer of recent events, or nil if it is not
+// enabled in the configuration.
+func (app *App) RecentEvents() *notifications.RecentEvents {
+	return app.events.recent
+}
+
+// configureCredentials sets up the store of robot accounts and personal
+// access tokens when the robot access controller is configured, on its own
+// or as the identity source of another. Credentials are kept in the redis
+// instance given by the "redis" option of the robot access controller, and
+// with the storage driver otherwise. The redis cache is never used, as it
+// may evict them.
+func (app *App) configureCredentials(config *configuration.Configuration) error {
+	options, ok := findAccessController(map[string]interface{}(config.Auth.Parameters()), config.Auth.Type(), "robot")
+	if !ok {
+		return nil
+	}
+
+	redisOpt, ok := options["redis"]
+	if !ok {
+		app.credentials = robot.NewDriverStore(app.driver, storage.CredentialsPath())
+		return nil
+	}
+
+	// The option takes the same parameters as the redis section.
+	p, err := yaml.Marshal(map[string]interface{}{"redis": redisOpt})
+	if err != nil {
+		return err
+	}
+	var redisConfig configuration.Configuration
+	if err := yaml.UnmarshalStrict(p, &redisConfig); err != nil {
+		return fmt.Errorf("invalid redis option for robot access controller: %v", err)
+	}
+	if redisConfig.Redis.Addr == "" {
+		return fmt.Errorf("redis option for robot access controller requires an addr")
+	}
+
+	app.credentials = robot.NewRedisStore(app.newRedisPool(&redisConfig))
+	return nil
+}
+
+// findAccessController returns the options of the access controller name if
+// it is the access controller typ, with options, or is nested in it. Access
+// controllers name their identity sources by the keys of nested option maps.
+func findAccessController(options map[string]interface{}, typ, name string) (map[string]interface{}, bool) {
+	if typ == name {
+		return options, true
+	}
+
+	for key, v := range options {
+		if v, ok := v.(string); ok {
+			if key == "identity" && v == name {
+				return map[string]interface{}{}, true
+			}
+			continue
+		}
+
+		nested := auth.ToStringMap(v)
+		if nested == nil {
+			continue
+		}
+		if found, ok := findAccessController(nested, key, name); ok {
+			return found, true
+		}
+	}
+
+	return nil, false
+}
+
+// Credentials returns the store of robot accounts and personal access
+// tokens, or nil if the robot access controller is not configured.
+func (app *App) Credentials() robot.Store {
+	return app.credentials
+}
+
 type redisStartAtKey struct{}
 
 func (app *App) configureRedis(configuration *configuration.Configuration) {
@@ -501,7 +656,26 @@ func (app *App) configureRedis(configuration *configuration.Configuration) {
 		return
 	}
 
-	pool := &redis.Pool{
+	app.redis = app.newRedisPool(configuration)
+
+	// setup expvar
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+
+	registry.(*expvar.Map).Set("redis", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"Config": configuration.Redis,
+			"Active": app.redis.ActiveCount(),
+		}
+	}))
+}
+
+// newRedisPool returns a pool of connections to the redis instance of the
+// configuration.
+func (app *App) newRedisPool(configuration *configuration.Configuration) *redis.Pool {
+	return &redis.Pool{
 		Dial: func() (redis.Conn, error) {
 			// TODO(stevvooe): Yet another use case for contextual timing.
 			ctx := context.WithValue(app, redisStartAtKey{}, time.Now())
@@ -561,21 +735,6 @@ func (app *App) configureRedis(configuration *configuration.Configuration) {
 		},
 		Wait: false, // if a connection is not available, proceed without cache.
 	}
-
-	app.redis = pool
-
-	// setup expvar
-	registry := expvar.Get("registry")
-	if registry == nil {
-		registry = expvar.NewMap("registry")
-	}
-
-	registry.(*expvar.Map).Set("redis", expvar.Func(func() interface{} {
-		return map[string]interface{}{
-			"Config": configuration.Redis,
-			"Active": app.redis.ActiveCount(),
-		}
-	}))
 }
 
 // configureLogHook prepares logging hook parameters.
@@ -630,6 +789,9 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx = dcontext.WithRequest(ctx, r)
 	ctx, w = dcontext.WithResponseWriter(ctx, w)
 	ctx = dcontext.WithLogger(ctx, dcontext.GetRequestLogger(ctx))
+	if app.credentials != nil {
+		ctx = robot.WithStore(ctx, app.credentials)
+	}
 	r = r.WithContext(ctx)
 
 	defer func() {
@@ -821,7 +983,12 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	var accessRecords []auth.Access
 
 	if repo != "" {
-		accessRecords = appendAccessRecords(accessRecords, r.Method, repo)
+		method := r.Method
+		if route := mux.CurrentRoute(r); route != nil && route.GetName() == v2.RouteNameBulkDelete {
+			// bulk deletes are posted but require delete access.
+			method = "DELETE"
+		}
+		accessRecords = appendAccessRecords(accessRecords, method, repo)
 		if fromRepo := r.FormValue("from"); fromRepo != "" {
 			// mounting a blob from one repository to another requires pull (GET)
 			// access to the source repository.
@@ -836,6 +1003,7 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			// base route is accessed. This section prevents us from making
 			// that mistake elsewhere in the code, allowing any operation to
 			// proceed.
+			app.auditAuthorization(context, r, nil, audit.ResultDenied, "no repository name")
 			if err := errcode.ServeJSON(w, errcode.ErrorCodeUnauthorized); err != nil {
 				dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 			}
@@ -845,9 +1013,27 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	}
 
 	ctx, err := app.accessController.Authorized(context.Context, accessRecords...)
+	if err != nil && repo == "" && len(accessRecords) > 0 {
+		// Callers without access to the catalog may still list the
+		// repositories they can pull.
+		if restrictedCtx, restrictedErr := app.accessController.Authorized(context.Context); restrictedErr == nil {
+			ctx, err = restrictedCtx, nil
+			context.restrictCatalog = true
+		}
+	}
 	if err != nil {
+		if err == auth.ErrAccessDenied {
+			app.auditAuthorization(context, r, accessRecords, audit.ResultDenied, err.Error())
+			if err := errcode.ServeJSON(w, errcode.ErrorCodeDenied.WithDetail(accessRecords)); err != nil {
+				dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+			}
+			return err
+		}
+
 		switch err := err.(type) {
 		case auth.Challenge:
+			app.auditAuthorization(context, r, accessRecords, audit.ResultUnauthenticated, err.Error())
+
 			// Add the appropriate WWW-Auth header
 			err.SetHeaders(r, w)
 
@@ -860,6 +1046,7 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			// controller. Just return a bad request with no information
 			// to avoid exposure. The request should not proceed.
 			dcontext.GetLogger(context).Errorf("error checking authorization: %v", err)
+			app.auditAuthorization(context, r, accessRecords, audit.ResultError, err.Error())
 			w.WriteHeader(http.StatusBadRequest)
 		}
 
@@ -871,6 +1058,11 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	// should be replaced by another, rather than replacing the context on a
 	// mutable object.
 	context.Context = ctx
+	if context.restrictCatalog {
+		app.auditAuthorization(context, r, nil, audit.ResultAllowed, "catalog restricted to pullable repositories")
+		return nil
+	}
+	app.auditAuthorization(context, r, accessRecords, audit.ResultAllowed, "")
 	return nil
 }
 
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path"
 	"reflect"
 	"testing"
 
@@ -13,10 +14,12 @@ import (
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/robot"
 	_ "github.com/distribution/distribution/v3/registry/auth/silly"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	"github.com/distribution/distribution/v3/registry/storage/driver/testdriver"
+	"github.com/gomodule/redigo/redis"
 )
 
 // TestAppDispatcher builds an application with a test dispatcher and ensures
@@ -277,3 +280,81 @@ func TestAppendAccessRecords(t *testing.T) {
 	}
 
 }
+
+func TestFindAccessController(t *testing.T) {
+	for _, testcase := range []struct {
+		typ      string
+		options  map[string]interface{}
+		expected bool
+	}{
+		{"robot", map[string]interface{}{"realm": "test"}, true},
+		{"htpasswd", map[string]interface{}{"realm": "test", "path": "/htpasswd"}, false},
+		{"rbac", map[string]interface{}{"identity": "robot"}, true},
+		{"rbac", map[string]interface{}{
+			"identity": map[interface{}]interface{}{
+				"robot": map[interface{}]interface{}{"realm": "test"},
+			},
+		}, true},
+		{"token", map[string]interface{}{
+			"server": map[interface{}]interface{}{
+				"identity": map[interface{}]interface{}{
+					"htpasswd": map[interface{}]interface{}{"path": "/robot"},
+				},
+			},
+		}, false},
+	} {
+		if _, actual := findAccessController(testcase.options, testcase.typ, "robot"); actual != testcase.expected {
+			t.Errorf("%s %v: expected %v, got %v", testcase.typ, testcase.options, testcase.expected, actual)
+		}
+	}
+	options, _ := findAccessController(map[string]interface{}{
+		"identity": map[interface{}]interface{}{
+			"robot": map[interface{}]interface{}{"realm": "test"},
+		},
+	}, "rbac", "robot")
+	if options["realm"] != "test" {
+		t.Errorf("unexpected options of nested access controller: %v", options)
+	}
+}
+
+func TestConfigureCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	// Credentials are kept with the storage driver, even when the redis
+	// cache is configured, unless the robot access controller names a
+	// redis instance of its own.
+	app := &App{Context: ctx, driver: testdriver.New(), redis: &redis.Pool{}}
+	config := &configuration.Configuration{
+		Auth: configuration.Auth{"robot": configuration.Parameters{"realm": "test"}},
+	}
+	if err := app.configureCredentials(config); err != nil {
+		t.Fatalf("unexpected error configuring credentials: %v", err)
+	}
+
+	cred, _, err := robot.NewCredential(robot.KindRobot, "ci", []robot.Scope{{Repositories: []string{"**"}, Actions: []string{"pull"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Credentials().Put(ctx, cred); err != nil {
+		t.Fatalf("unexpected error storing credential: %v", err)
+	}
+	if _, err := app.driver.Stat(ctx, path.Join(storage.CredentialsPath(), cred.ID)); err != nil {
+		t.Fatalf("expected credential to be stored with the storage driver: %v", err)
+	}
+
+	for _, redisOpt := range []interface{}{
+		map[interface{}]interface{}{"db": 1},
+		map[interface{}]interface{}{"addr": "localhost:6379", "unknown": true},
+		"localhost:6379",
+	} {
+		config.Auth["robot"]["redis"] = redisOpt
+		if err := app.configureCredentials(config); err == nil {
+			t.Errorf("expected error with redis option %v", redisOpt)
+		}
+	}
+
+	config.Auth["robot"]["redis"] = map[interface{}]interface{}{"addr": "localhost:6379", "db": 2}
+	if err := app.configureCredentials(config); err != nil {
+		t.Fatalf("unexpected error configuring credentials in redis: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/audit"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/opencontainers/go-digest"
+)
+
+// Actions recorded for mutations.
+const (
+	auditActionPush   = "push"
+	auditActionDelete = "delete"
+	auditActionMount  = "mount"
+)
+
+// configureAudit sets up the sink of the audit log.
+func (app *App) configureAudit(config *configuration.Configuration) error {
+	if !config.Audit.Enabled {
+		return nil
+	}
+
+	name := config.Audit.Sink
+	if name == "" {
+		name = "file"
+	}
+
+	sink, err := audit.GetSink(name, config.Audit.Options)
+	if err != nil {
+		return err
+	}
+
+	dcontext.GetLogger(app).Infof("writing audit log with sink %q", name)
+	app.audit = sink
+
+	return nil
+}
+
+// auditAuthorization records the decision of the access controller on each
+// of the access records of a request.
+func (app *App) auditAuthorization(ctx *Context, r *http.Request, accessRecords []auth.Access, result, reason string) {
+	if app.audit == nil {
+		return
+	}
+
+	for _, access := range accessRecords {
+		record := audit.Record{
+			Type:   audit.TypeAuthorization,
+			Action: access.Action,
+			Result: result,
+			Reason: reason,
+		}
+
+		if access.Type == "repository" {
+			record.Repository = access.Name
+		} else {
+			record.Resource = access.Type + ":" + access.Name
+		}
+
+		app.writeAudit(ctx, r, record)
+	}
+
+	if len(accessRecords) == 0 {
+		app.writeAudit(ctx, r, audit.Record{
+			Type:   audit.TypeAuthorization,
+			Result: result,
+			Reason: reason,
+		})
+	}
+}
+
+// auditMutation records the outcome of a call changing the repository of
+// the request. The call failed if it recorded errors on the context.
+func (app *App) auditMutation(ctx *Context, r *http.Request, action, tag string, dgst digest.Digest) {
+	if app.audit == nil {
+		return
+	}
+
+	record := audit.Record{
+		Type:       audit.TypeMutation,
+		Repository: getName(ctx),
+		Action:     action,
+		Digest:     dgst,
+		Tag:        tag,
+		Result:     audit.ResultSuccess,
+	}
+
+	if len(ctx.Errors) > 0 {
+		record.Result = audit.ResultFailure
+		record.Reason = ctx.Errors.Error()
+	}
+
+	app.writeAudit(ctx, r, record)
+}
+
+// writeAudit completes the record with the details of the request and
+// writes it. Failures are logged; they do not fail the request.
+func (app *App) writeAudit(ctx *Context, r *http.Request, record audit.Record) {
+	record.Time = time.Now().UTC()
+	record.RequestID = dcontext.GetRequestID(ctx)
+	record.User = getUserName(ctx, r)
+	record.SourceIP = dcontext.RemoteIP(r)
+	record.Method = r.Method
+
+	if err := app.audit.Write(record); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error writing audit record: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/audit"
+	_ "github.com/distribution/distribution/v3/registry/auth/silly"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Audit.Enabled = true
+	config.Audit.Options = configuration.Parameters{"path": path}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/audited")
+
+	do := func(method, u string, body []byte, authorized bool) *http.Response {
+		req, err := http.NewRequest(method, u, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorized {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	uploadURL, err := env.builder.BuildBlobUploadURL(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := do(http.MethodPost, uploadURL, nil, false)
+	checkResponse(t, "starting upload without credentials", resp, http.StatusUnauthorized)
+
+	resp = do(http.MethodPost, uploadURL, nil, true)
+	checkResponse(t, "starting upload", resp, http.StatusAccepted)
+
+	content := []byte("audited blob")
+	dgst := digest.FromBytes(content)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	query.Set("digest", dgst.String())
+	location.RawQuery = query.Encode()
+
+	resp = do(http.MethodPut, location.String(), content, true)
+	checkResponse(t, "completing upload", resp, http.StatusCreated)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []audit.Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record audit.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid audit record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+
+	expected := []audit.Record{
+		{Type: audit.TypeAuthorization, Repository: "foo/audited", Action: "pull", Result: audit.ResultUnauthenticated},
+		{Type: audit.TypeAuthorization, Repository: "foo/audited", Action: "push", Result: audit.ResultUnauthenticated},
+		{Type: audit.TypeAuthorization, User: "silly", Repository: "foo/audited", Action: "pull", Result: audit.ResultAllowed},
+		{Type: audit.TypeAuthorization, User: "silly", Repository: "foo/audited", Action: "push", Result: audit.ResultAllowed},
+		// Completing the upload is authorized as well.
+		{Type: audit.TypeAuthorization, User: "silly", Repository: "foo/audited", Action: "pull", Result: audit.ResultAllowed},
+		{Type: audit.TypeAuthorization, User: "silly", Repository: "foo/audited", Action: "push", Result: audit.ResultAllowed},
+		{Type: audit.TypeMutation, User: "silly", Repository: "foo/audited", Action: "push", Digest: dgst, Result: audit.ResultSuccess},
+	}
+
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d audit records, got %d: %+v", len(expected), len(records), records)
+	}
+
+	for i, record := range records {
+		if record.Time.IsZero() || record.RequestID == "" || record.SourceIP == "" {
+			t.Errorf("record %d: missing request details: %+v", i, record)
+		}
+		if record.Type == audit.TypeAuthorization && record.Result != audit.ResultAllowed && record.Reason == "" {
+			t.Errorf("record %d: missing reason: %+v", i, record)
+		}
+
+		record.Time, record.RequestID, record.SourceIP, record.Method, record.Reason = expected[i].Time, "", "", "", ""
+		if record != expected[i] {
+			t.Errorf("record %d: expected %+v, got %+v", i, expected[i], record)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/context"
@@ -66,6 +69,10 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == http.MethodGet && !checkRateLimit(bh.Context, w, r, bh.App.rateLimits.blobBytes, servedLength(r, desc)) {
+		return
+	}
+
 	if err := blobs.ServeBlob(bh, w, r, desc.Digest); err != nil {
 		context.GetLogger(bh).Debugf("unexpected error getting blob HTTP handler: %v", err)
 		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -73,9 +80,92 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// servedLength returns the number of bytes of the blob described by desc a
+// GET request is served: the length of the requested ranges, or the whole
+// blob when the request has no Range header or one that does not apply. It
+// follows the range handling of http.ServeContent, which serves the blob.
+func servedLength(r *http.Request, desc distribution.Descriptor) int64 {
+	size := desc.Size
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || !strings.HasPrefix(rangeHeader, "bytes=") {
+		return size
+	}
+
+	// A stale If-Range validator asks for the whole blob.
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != fmt.Sprintf(`"%s"`, desc.Digest) {
+		return size
+	}
+
+	var (
+		total  int64
+		ranges int
+	)
+	for _, spec := range strings.Split(strings.TrimPrefix(rangeHeader, "bytes="), ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		ranges++
+
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return size
+		}
+		start, end := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+		var length int64
+		if start == "" {
+			// A suffix range: the last n bytes.
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return size
+			}
+			length = n
+			if length > size {
+				length = size
+			}
+		} else {
+			first, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || first < 0 {
+				return size
+			}
+			if first >= size {
+				// Unsatisfiable ranges are skipped.
+				continue
+			}
+
+			last := size - 1
+			if end != "" {
+				last, err = strconv.ParseInt(end, 10, 64)
+				if err != nil || last < first {
+					return size
+				}
+				if last >= size {
+					last = size - 1
+				}
+			}
+			length = last - first + 1
+		}
+
+		total += length
+	}
+
+	// Ranges adding up to more than the blob are ignored, and the whole blob
+	// is served.
+	if ranges == 0 || total > size {
+		return size
+	}
+
+	return total
+}
+
 // DeleteBlob deletes a layer blob
 func (bh *blobHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 	context.GetLogger(bh).Debug("DeleteBlob")
+	defer func() {
+		bh.App.auditMutation(bh.Context, r, auditActionDelete, "", bh.Digest)
+	}()
 
 	blobs := bh.Repository.Blobs(bh)
 	err := blobs.Delete(bh, bh.Digest)
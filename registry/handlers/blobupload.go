@@ -62,6 +62,10 @@ type blobUploadHandler struct {
 // StartBlobUpload begins the blob upload process and allocates a server-side
 // blob writer session, optionally mounting the blob from a separate repository.
 func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkRateLimit(buh.Context, w, r, buh.App.rateLimits.uploads, 1) {
+		return
+	}
+
 	var options []distribution.BlobCreateOption
 
 	fromRepo := r.FormValue("from")
@@ -82,6 +86,7 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 			if err := buh.writeBlobCreatedHeaders(w, ebm.Descriptor); err != nil {
 				buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
+			buh.App.auditMutation(buh.Context, r, auditActionMount, "", ebm.Descriptor.Digest)
 		} else if err == distribution.ErrUnsupported {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnsupported)
 		} else {
@@ -177,6 +182,10 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 // into the blob store and 201 Created is returned with the canonical
 // url of the blob.
 func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		buh.App.auditMutation(buh.Context, r, auditActionPush, "", digest.Digest(r.FormValue("digest")))
+	}()
+
 	if buh.Upload == nil {
 		buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadUnknown)
 		return
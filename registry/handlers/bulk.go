@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/audit"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// maxBulkDeleteBodySize is the maximum size of a bulk delete request.
+	maxBulkDeleteBodySize = 4 << 20
+
+	// maxBulkDeleteItems is the maximum number of tags and manifests
+	// deleted by a single request, including the tags matching the
+	// pattern.
+	maxBulkDeleteItems = 10000
+
+	// bulkDeleteConcurrency is the number of items deleted concurrently.
+	bulkDeleteConcurrency = 8
+)
+
+// Statuses of the items of a bulk delete.
+const (
+	bulkStatusDeleted = "deleted"
+	bulkStatusMatched = "matched"
+	bulkStatusFailed  = "failed"
+)
+
+// bulkDeleteDispatcher constructs the handler deleting tags and manifests
+// of a repository in bulk.
+func bulkDeleteDispatcher(ctx *Context, r *http.Request) http.Handler {
+	bulkDeleteHandler := &bulkDeleteHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(bulkDeleteHandler.BulkDelete),
+	}
+}
+
+// bulkDeleteHandler handles bulk deletes of tags and manifests.
+type bulkDeleteHandler struct {
+	*Context
+}
+
+// bulkDeleteRequest lists the tags and manifests to delete. Tags matching
+// TagPattern, a regular expression matched against whole tags, are deleted
+// along with the listed tags.
+type bulkDeleteRequest struct {
+	Tags       []string        `json:"tags,omitempty"`
+	TagPattern string          `json:"tagPattern,omitempty"`
+	Digests    []digest.Digest `json:"digests,omitempty"`
+	DryRun     bool            `json:"dryRun,omitempty"`
+}
+
+// bulkDeleteResult is the outcome of the deletion of a tag or manifest.
+// Deleting a manifest also deletes the tags referencing it.
+type bulkDeleteResult struct {
+	Tag    string         `json:"tag,omitempty"`
+	Digest digest.Digest  `json:"digest,omitempty"`
+	Tags   []string       `json:"tags,omitempty"`
+	Status string         `json:"status"`
+	Error  *errcode.Error `json:"error,omitempty"`
+}
+
+type bulkDeleteResponse struct {
+	Name    string             `json:"name"`
+	DryRun  bool               `json:"dryRun,omitempty"`
+	Results []bulkDeleteResult `json:"results"`
+}
+
+// BulkDelete deletes the tags and manifests listed in the request, or
+// reports what would be deleted for dry runs. Tags are deleted before
+// manifests. The response reports the outcome of each item; the request
+// succeeds even if some items failed.
+func (bh *bulkDeleteHandler) BulkDelete(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(bh).Debug("BulkDelete")
+
+	if bh.App.isCache {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := copyFullPayload(bh, w, r, &body, maxBulkDeleteBodySize, "bulk delete"); err != nil {
+		bh.Errors = append(bh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	var req bulkDeleteRequest
+	if err := json.Unmarshal(body.Bytes(), &req); err != nil {
+		bh.Errors = append(bh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	tags, err := bh.tagsToDelete(req)
+	if err != nil {
+		bh.Errors = append(bh.Errors, err)
+		return
+	}
+
+	for _, dgst := range req.Digests {
+		if err := dgst.Validate(); err != nil {
+			bh.Errors = append(bh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(dgst))
+			return
+		}
+	}
+
+	if len(tags)+len(req.Digests) > maxBulkDeleteItems {
+		bh.Errors = append(bh.Errors, v2.ErrorCodeRequestInvalid.WithMessage(fmt.Sprintf("at most %d items can be deleted at once", maxBulkDeleteItems)))
+		return
+	}
+
+	results := make([]bulkDeleteResult, 0, len(tags)+len(req.Digests))
+	for _, tag := range tags {
+		results = append(results, bulkDeleteResult{Tag: tag})
+	}
+	for _, dgst := range req.Digests {
+		results = append(results, bulkDeleteResult{Digest: dgst})
+	}
+
+	// Tags are deleted first so that they do not race with the deletion of
+	// the manifests they reference.
+	bh.run(results[:len(tags)], func(result *bulkDeleteResult) error {
+		return bh.deleteTag(r, result, req.DryRun)
+	})
+	bh.run(results[len(tags):], func(result *bulkDeleteResult) error {
+		return bh.deleteManifest(r, result, req.DryRun)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(bulkDeleteResponse{
+		Name:    bh.Repository.Named().Name(),
+		DryRun:  req.DryRun,
+		Results: results,
+	}); err != nil {
+		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// tagsToDelete returns the tags listed in the request and those matching
+// its pattern, without duplicates.
+func (bh *bulkDeleteHandler) tagsToDelete(req bulkDeleteRequest) ([]string, error) {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, tag := range req.Tags {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	if req.TagPattern == "" {
+		return tags, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + req.TagPattern + ")$")
+	if err != nil {
+		return nil, v2.ErrorCodeRequestInvalid.WithMessage("invalid tag pattern").WithDetail(err.Error())
+	}
+
+	all, err := bh.Repository.Tags(bh).All(bh)
+	if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrRepositoryUnknown:
+			return nil, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": bh.Repository.Named().Name()})
+		case errcode.Error:
+			return nil, err
+		default:
+			return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+	}
+
+	sort.Strings(all)
+	for _, tag := range all {
+		if re.MatchString(tag) && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags, nil
+}
+
+// run calls fn for each result with bounded concurrency, recording its
+// outcome in the result.
+func (bh *bulkDeleteHandler) run(results []bulkDeleteResult, fn func(result *bulkDeleteResult) error) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bulkDeleteConcurrency)
+
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *bulkDeleteResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := fn(result); err != nil {
+				result.Status = bulkStatusFailed
+				result.Error = toErrcodeError(err)
+			}
+		}(&results[i])
+	}
+
+	wg.Wait()
+}
+
+// deleteTag deletes the tag of the result, or checks that it could be
+// deleted.
+func (bh *bulkDeleteHandler) deleteTag(r *http.Request, result *bulkDeleteResult, dryRun bool) error {
+	if bh.App.tagImmutable(bh.Repository.Named().Name(), result.Tag) {
+		return errTagImmutable(result.Tag, "")
+	}
+
+	if dryRun {
+		desc, err := bh.Repository.Tags(bh).Get(bh, result.Tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				return v2.ErrorCodeManifestUnknown.WithDetail(err)
+			}
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		result.Digest = desc.Digest
+		result.Status = bulkStatusMatched
+		return nil
+	}
+
+	err := bh.App.untag(bh, bh.Repository, result.Tag)
+	bh.auditItem(r, result.Tag, "", err)
+	if err != nil {
+		return err
+	}
+	result.Status = bulkStatusDeleted
+	return nil
+}
+
+// deleteManifest deletes the manifest of the result and its tags, or
+// checks that they could be deleted.
+func (bh *bulkDeleteHandler) deleteManifest(r *http.Request, result *bulkDeleteResult, dryRun bool) error {
+	if err := bh.App.checkManifestDeletable(bh, bh.Repository, result.Digest); err != nil {
+		return err
+	}
+
+	if dryRun {
+		if !bh.App.deleteEnabled {
+			return errcode.ErrorCodeUnsupported
+		}
+
+		manifests, err := bh.Repository.Manifests(bh)
+		if err != nil {
+			return err
+		}
+		exists, err := manifests.Exists(bh, result.Digest)
+		if err != nil {
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		if !exists {
+			return v2.ErrorCodeManifestUnknown.WithDetail(result.Digest)
+		}
+
+		tags, err := currentTags(bh, bh.Repository, result.Digest)
+		if err != nil {
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		result.Tags = tags
+		result.Status = bulkStatusMatched
+		return nil
+	}
+
+	tags, err := bh.App.deleteManifest(bh, bh.Repository, result.Digest)
+	bh.auditItem(r, "", result.Digest, err)
+	if err != nil {
+		return err
+	}
+	result.Tags = tags
+	result.Status = bulkStatusDeleted
+	return nil
+}
+
+// auditItem records the outcome of the deletion of an item.
+func (bh *bulkDeleteHandler) auditItem(r *http.Request, tag string, dgst digest.Digest, err error) {
+	if bh.App.audit == nil {
+		return
+	}
+
+	record := audit.Record{
+		Type:       audit.TypeMutation,
+		Repository: bh.Repository.Named().Name(),
+		Action:     auditActionDelete,
+		Digest:     dgst,
+		Tag:        tag,
+		Result:     audit.ResultSuccess,
+	}
+	if err != nil {
+		record.Result = audit.ResultFailure
+		record.Reason = err.Error()
+	}
+
+	bh.App.writeAudit(bh.Context, r, record)
+}
+
+// toErrcodeError converts err to an errcode.Error, which can be serialized
+// in a response.
+func toErrcodeError(err error) *errcode.Error {
+	switch err := err.(type) {
+	case errcode.Error:
+		return &err
+	case errcode.ErrorCode:
+		e := err.WithDetail(nil)
+		return &e
+	default:
+		e := errcode.ErrorCodeUnknown.WithDetail(err.Error())
+		return &e
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestBulkDelete(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"delete":     configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bulk")
+	for _, tag := range []string{"v1", "v2", "keep"} {
+		createRepository(env, t, name.Name(), tag)
+	}
+	other := createRepository(env, t, name.Name(), "other")
+
+	bulkDelete := func(msg string, req bulkDeleteRequest, status int) bulkDeleteResponse {
+		u, err := env.builder.BuildBulkDeleteURL(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(u, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, msg, resp, status)
+		if status != http.StatusOK {
+			checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeRequestInvalid)
+			return bulkDeleteResponse{}
+		}
+
+		var result bulkDeleteResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("%s: error decoding response: %v", msg, err)
+		}
+		return result
+	}
+
+	statuses := func(resp bulkDeleteResponse) map[string]string {
+		m := make(map[string]string)
+		for _, result := range resp.Results {
+			key := result.Tag
+			if key == "" {
+				key = result.Digest.String()
+			}
+			m[key] = result.Status
+		}
+		return m
+	}
+
+	expectTags := func(msg string, expected []string) {
+		repo, err := env.app.registry.Repository(env.ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, err := repo.Tags(env.ctx).All(env.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(all, expected) {
+			t.Fatalf("%s: unexpected tags: %v != %v", msg, all, expected)
+		}
+	}
+
+	bulkDelete("invalid pattern", bulkDeleteRequest{TagPattern: "("}, http.StatusBadRequest)
+
+	resp := bulkDelete("dry run", bulkDeleteRequest{TagPattern: "v.*", DryRun: true}, http.StatusOK)
+	if !resp.DryRun {
+		t.Fatal("expected dry run response")
+	}
+	if s := statuses(resp); !reflect.DeepEqual(s, map[string]string{"v1": bulkStatusMatched, "v2": bulkStatusMatched}) {
+		t.Fatalf("unexpected dry run results: %v", s)
+	}
+	expectTags("after dry run", []string{"keep", "other", "v1", "v2"})
+
+	resp = bulkDelete("bulk delete", bulkDeleteRequest{
+		Tags:       []string{"missing", "v1"},
+		TagPattern: "v.*",
+		Digests:    []digest.Digest{other},
+	}, http.StatusOK)
+	expected := map[string]string{
+		"missing":      bulkStatusFailed,
+		"v1":           bulkStatusDeleted,
+		"v2":           bulkStatusDeleted,
+		other.String(): bulkStatusDeleted,
+	}
+	if s := statuses(resp); !reflect.DeepEqual(s, expected) {
+		t.Fatalf("unexpected results: %v", s)
+	}
+	for _, result := range resp.Results {
+		switch {
+		case result.Tag == "missing":
+			if result.Error == nil || result.Error.Code != v2.ErrorCodeManifestUnknown {
+				t.Errorf("unexpected error deleting missing tag: %v", result.Error)
+			}
+		case result.Digest == other:
+			if !reflect.DeepEqual(result.Tags, []string{"other"}) {
+				t.Errorf("unexpected tags deleted with manifest: %v", result.Tags)
+			}
+		}
+	}
+	expectTags("after bulk delete", []string{"keep"})
+}
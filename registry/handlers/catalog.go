@@ -7,8 +7,13 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/gorilla/handlers"
 )
@@ -33,9 +38,13 @@ type catalogAPIResponse struct {
 	Repositories []string `json:"repositories"`
 }
 
-func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
-	var moreEntries = true
+// catalogExtendedAPIResponse is returned for the extended view of the
+// catalog, describing each repository.
+type catalogExtendedAPIResponse struct {
+	Repositories []distribution.RepositoryDescription `json:"repositories"`
+}
 
+func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	lastEntry := q.Get("last")
 	maxEntries, err := strconv.Atoi(q.Get("n"))
@@ -43,18 +52,32 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 		maxEntries = maximumReturnedEntries
 	}
 
-	repos := make([]string, maxEntries)
-
-	filled, err := ch.App.registry.Repositories(ch.Context, repos, lastEntry)
-	_, pathNotFound := err.(driver.PathNotFoundError)
+	var extended bool
+	switch view := q.Get("view"); view {
+	case "", "names":
+	case "extended":
+		extended = true
+	default:
+		ch.Errors = append(ch.Errors, v2.ErrorCodeRequestInvalid.WithMessage(fmt.Sprintf("unknown catalog view %q", view)))
+		return
+	}
 
-	if err == io.EOF || pathNotFound {
-		moreEntries = false
-	} else if err != nil {
+	repos, moreEntries, err := ch.repositories(lastEntry, maxEntries, q.Get("prefix"), strings.ToLower(q.Get("q")))
+	if err != nil {
 		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 
+	var response interface{} = catalogAPIResponse{Repositories: repos}
+	if extended {
+		descriptions, err := ch.describe(repos)
+		if err != nil {
+			ch.Errors = append(ch.Errors, err)
+			return
+		}
+		response = catalogExtendedAPIResponse{Repositories: descriptions}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	// Add a link header if there are more entries to retrieve
@@ -69,14 +92,128 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	enc := json.NewEncoder(w)
-	if err := enc.Encode(catalogAPIResponse{
-		Repositories: repos[0:filled],
-	}); err != nil {
+	if err := enc.Encode(response); err != nil {
 		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 }
 
+// repositories returns up to maxEntries repositories after last whose names
+// start with prefix and contain query, and which the caller may list, along
+// with whether there may be more.
+func (ch *catalogHandler) repositories(last string, maxEntries int, prefix, query string) ([]string, bool, error) {
+	filtered := prefix != "" || query != "" || ch.restrictCatalog
+
+	repos := make([]string, maxEntries)
+	if !filtered || maxEntries == 0 {
+		filled, err := ch.App.registry.Repositories(ch.Context, repos, last)
+		done, err := catalogDone(err)
+		if err != nil {
+			return nil, false, err
+		}
+		return repos[:filled], !done, nil
+	}
+
+	pullable := func(string) bool { return true }
+	if ch.restrictCatalog {
+		var err error
+		pullable, err = ch.pullableRepositories()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	// repositories are read in pages, of at least the default size, until
+	// enough of them pass the filters.
+	if pageSize := maximumReturnedEntries; maxEntries < pageSize {
+		repos = make([]string, pageSize)
+	}
+
+	var result []string
+	for {
+		filled, err := ch.App.registry.Repositories(ch.Context, repos, last)
+		done, err := catalogDone(err)
+		if err != nil {
+			return nil, false, err
+		}
+
+		for i, repo := range repos[:filled] {
+			if !strings.HasPrefix(repo, prefix) || !strings.Contains(repo, query) || !pullable(repo) {
+				continue
+			}
+
+			result = append(result, repo)
+			if len(result) == maxEntries {
+				return result, !done || i < filled-1, nil
+			}
+		}
+
+		if done || filled == 0 {
+			return result, false, nil
+		}
+		last = repos[filled-1]
+	}
+}
+
+// catalogDone returns whether the error returned by Repositories marks the
+// end of the catalog, or the error if it is a failure.
+func catalogDone(err error) (bool, error) {
+	if err == nil {
+		return false, nil
+	}
+	if _, ok := err.(driver.PathNotFoundError); ok || err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// pullableRepositories returns a function reporting whether the caller may
+// pull from a repository. Access controllers which cannot resolve it from
+// their policy authorize each repository.
+func (ch *catalogHandler) pullableRepositories() (func(name string) bool, error) {
+	if filter, ok := ch.App.accessController.(auth.RepositoryFilter); ok {
+		return filter.PullableRepositories(ch.Context)
+	}
+
+	return func(name string) bool {
+		_, err := ch.App.accessController.Authorized(ch.Context, auth.Access{
+			Resource: auth.Resource{
+				Type: "repository",
+				Name: name,
+			},
+			Action: "pull",
+		})
+		return err == nil
+	}, nil
+}
+
+// describe returns the description of each repository.
+func (ch *catalogHandler) describe(repos []string) ([]distribution.RepositoryDescription, error) {
+	describer, ok := ch.App.registry.(distribution.RepositoryDescriber)
+	if !ok {
+		return nil, errcode.ErrorCodeUnsupported.WithDetail("the registry cannot describe repositories")
+	}
+
+	descriptions := make([]distribution.RepositoryDescription, 0, len(repos))
+	for _, repo := range repos {
+		named, err := reference.WithName(repo)
+		if err != nil {
+			return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+
+		description, err := describer.Describe(ch.Context, named)
+		if err != nil {
+			if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+				// removed since it was listed
+				continue
+			}
+			return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions, nil
+}
+
 // Use the original URL from the request to create a new URL for
 // the link header
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
@@ -85,9 +222,10 @@ func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string,
 		return "", err
 	}
 
-	v := url.Values{}
-	v.Add("n", strconv.Itoa(maxEntries))
-	v.Add("last", lastEntry)
+	// keep the other parameters, such as filters, of the original request
+	v := calledURL.Query()
+	v.Set("n", strconv.Itoa(maxEntries))
+	v.Set("last", lastEntry)
 
 	calledURL.RawQuery = v.Encode()
 
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/auth"
+	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	_ "github.com/distribution/distribution/v3/registry/auth/token"
+	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// publicAccessController grants pulls of the repositories under public/ and
+// denies everything else, including the catalog.
+type publicAccessController struct{}
+
+func (publicAccessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	for _, access := range accessRecords {
+		if access.Type != "repository" || access.Action != "pull" || !strings.HasPrefix(access.Name, "public/") {
+			return nil, auth.ErrAccessDenied
+		}
+	}
+	return auth.WithUser(ctx, auth.UserInfo{Name: "guest"}), nil
+}
+
+func init() {
+	auth.Register("publiccatalogtest", func(options map[string]interface{}) (auth.AccessController, error) {
+		return publicAccessController{}, nil
+	})
+}
+
+func getCatalog(t *testing.T, env *testEnv, values url.Values, v interface{}) *http.Response {
+	catalogURL, err := env.builder.BuildCatalogURL(values)
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+
+	resp, err := http.Get(catalogURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+	}
+	return resp
+}
+
+func TestCatalogAPIFilters(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	for _, image := range []string{"foo/aaaa", "foo/bbbb", "bar/cccc"} {
+		createRepository(env, t, image, "sometag")
+	}
+
+	var ctlg struct {
+		Repositories []string `json:"repositories"`
+	}
+
+	resp := getCatalog(t, env, url.Values{"prefix": []string{"foo/"}, "n": []string{"1"}}, &ctlg)
+	checkResponse(t, "listing catalog with prefix", resp, http.StatusOK)
+	if len(ctlg.Repositories) != 1 || ctlg.Repositories[0] != "foo/aaaa" {
+		t.Fatalf("unexpected repositories with prefix: %v", ctlg.Repositories)
+	}
+
+	values := checkLink(t, resp.Header.Get("Link"), 1, "foo/aaaa")
+	if values.Get("prefix") != "foo/" {
+		t.Fatalf("expected link to keep the prefix, got %v", values)
+	}
+
+	resp = getCatalog(t, env, values, &ctlg)
+	checkResponse(t, "listing next catalog page with prefix", resp, http.StatusOK)
+	if len(ctlg.Repositories) != 1 || ctlg.Repositories[0] != "foo/bbbb" {
+		t.Fatalf("unexpected repositories on the second page: %v", ctlg.Repositories)
+	}
+	if link := resp.Header.Get("Link"); link != "" {
+		t.Fatalf("unexpected link after the last repository with prefix: %s", link)
+	}
+
+	resp = getCatalog(t, env, url.Values{"q": []string{"CC"}}, &ctlg)
+	checkResponse(t, "searching catalog", resp, http.StatusOK)
+	if len(ctlg.Repositories) != 1 || ctlg.Repositories[0] != "bar/cccc" {
+		t.Fatalf("unexpected repositories matching query: %v", ctlg.Repositories)
+	}
+
+	var extended struct {
+		Repositories []distribution.RepositoryDescription `json:"repositories"`
+	}
+	resp = getCatalog(t, env, url.Values{"prefix": []string{"bar/"}, "view": []string{"extended"}}, &extended)
+	checkResponse(t, "listing extended catalog", resp, http.StatusOK)
+	if len(extended.Repositories) != 1 {
+		t.Fatalf("unexpected extended repositories: %v", extended.Repositories)
+	}
+	description := extended.Repositories[0]
+	if description.Name != "bar/cccc" || description.TagCount != 1 || description.Size == 0 || description.LastPush.IsZero() {
+		t.Fatalf("unexpected description of bar/cccc: %+v", description)
+	}
+
+	resp = getCatalog(t, env, url.Values{"view": []string{"bogus"}}, &ctlg)
+	checkResponse(t, "listing catalog with unknown view", resp, http.StatusBadRequest)
+}
+
+func TestCatalogAPIRestricted(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"publiccatalogtest": configuration.Parameters{},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	for _, name := range []string{"private/a", "public/a", "private/b", "public/b", "public/c"} {
+		named, _ := reference.WithName(name)
+		repo, err := env.app.registry.Repository(env.ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Tags(env.ctx).Tag(env.ctx, "latest", distribution.Descriptor{Digest: digest.FromString(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ctlg struct {
+		Repositories []string `json:"repositories"`
+	}
+
+	resp := getCatalog(t, env, url.Values{"n": []string{"2"}}, &ctlg)
+	checkResponse(t, "listing restricted catalog", resp, http.StatusOK)
+	if len(ctlg.Repositories) != 2 || ctlg.Repositories[0] != "public/a" || ctlg.Repositories[1] != "public/b" {
+		t.Fatalf("unexpected repositories in restricted catalog: %v", ctlg.Repositories)
+	}
+
+	resp = getCatalog(t, env, checkLink(t, resp.Header.Get("Link"), 2, "public/b"), &ctlg)
+	checkResponse(t, "listing next page of restricted catalog", resp, http.StatusOK)
+	if len(ctlg.Repositories) != 1 || ctlg.Repositories[0] != "public/c" {
+		t.Fatalf("unexpected repositories on the second page of restricted catalog: %v", ctlg.Repositories)
+	}
+}
+
+func TestCatalogAPIRestrictedToken(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "key.json")
+	if err := libtrust.SaveKey(keyPath, key); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	if err := ioutil.WriteFile(htpasswdPath, []byte("alice:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice may pull her repositories but not list the catalog
+	policyPath := filepath.Join(dir, "policy.yml")
+	policy := "rules:\n  - users: [alice]\n    repositories: [\"alice/**\"]\n    actions: [pull]\n"
+	if err := ioutil.WriteFile(policyPath, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"token": configuration.Parameters{
+				"realm":   "https://registry.example.com/auth/token",
+				"issuer":  "registry.example.com",
+				"service": "registry.example.com",
+				"server": map[interface{}]interface{}{
+					"signingkey": keyPath,
+					"policy":     policyPath,
+					"identity": map[interface{}]interface{}{
+						"htpasswd": map[interface{}]interface{}{
+							"realm": "test-realm",
+							"path":  htpasswdPath,
+						},
+					},
+				},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	for _, name := range []string{"alice/a", "bob/a", "alice/b/c", "bob/b"} {
+		named, _ := reference.WithName(name)
+		repo, err := env.app.registry.Repository(env.ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Tags(env.ctx).Tag(env.ctx, "latest", distribution.Descriptor{Digest: digest.FromString(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the token is requested for the catalog, which the policy does not grant
+	tokenURL := env.server.URL + "/auth/token?" + url.Values{
+		"service": {"registry.example.com"},
+		"scope":   {"registry:catalog:*"},
+	}.Encode()
+	req, err := http.NewRequest(http.MethodGet, tokenURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status requesting token: %v", resp.Status)
+	}
+	var tokenResponse struct {
+		Token string `json:"token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest(http.MethodGet, catalogURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenResponse.Token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "listing restricted catalog with a token", resp, http.StatusOK)
+
+	var ctlg struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctlg.Repositories) != 2 || ctlg.Repositories[0] != "alice/a" || ctlg.Repositories[1] != "alice/b/c" {
+		t.Fatalf("unexpected repositories in restricted catalog: %v", ctlg.Repositories)
+	}
+}
@@ -35,6 +35,10 @@ type Context struct {
 
 	urlBuilder *v2.URLBuilder
 
+	// restrictCatalog is set when the caller may not list the whole
+	// catalog, which then only lists the repositories they can pull.
+	restrictCatalog bool
+
 	// TODO(stevvooe): The goal is too completely factor this context and
 	// dispatching out of the web application. Ideally, we should lean on
 	// context.Context for injection of these resources.
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// copyDispatcher constructs the handler copying manifests between
+// repositories.
+func copyDispatcher(ctx *Context, r *http.Request) http.Handler {
+	copyHandler := &copyHandler{
+		manifestHandler: manifestHandler{
+			Context: ctx,
+		},
+	}
+
+	return handlers.MethodHandler{
+		"POST": http.HandlerFunc(copyHandler.CopyManifest),
+	}
+}
+
+// copyHandler copies a manifest from a source repository into the
+// repository of the request. Policies of the target repository are applied
+// as for a manifest push.
+type copyHandler struct {
+	manifestHandler
+
+	// source is the repository the manifest is copied from.
+	source distribution.Repository
+
+	// copied records the blobs and manifests already copied.
+	copied map[digest.Digest]bool
+}
+
+// CopyManifest copies the manifest identified by the reference parameter
+// from the repository named by the from parameter, linking the blobs and
+// manifests it references, and optionally tags it. A tag reference keeps
+// its name unless the tag parameter is given.
+//
+// The target tag is only updated once everything it references has been
+// copied, so clients never observe a partial copy.
+func (ch *copyHandler) CopyManifest(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(ch).Debug("CopyManifest")
+	defer func() {
+		ch.App.auditMutation(ch.Context, r, auditActionPush, ch.Tag, ch.Digest)
+	}()
+
+	if ch.App.isCache {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	sourceName, err := reference.WithName(r.FormValue("from"))
+	if err != nil {
+		ch.Errors = append(ch.Errors, v2.ErrorCodeNameInvalid.WithDetail(err))
+		return
+	}
+
+	ch.source, err = ch.App.registry.Repository(ch, sourceName)
+	if err != nil {
+		ch.Errors = append(ch.Errors, v2.ErrorCodeNameInvalid.WithDetail(err))
+		return
+	}
+
+	if err := ch.resolve(r.FormValue("reference"), r.FormValue("tag")); err != nil {
+		ch.Errors = append(ch.Errors, err)
+		return
+	}
+
+	sourceManifests, err := ch.source.Manifests(ch)
+	if err != nil {
+		ch.Errors = append(ch.Errors, err)
+		return
+	}
+
+	manifest, err := sourceManifests.Get(ch, ch.Digest)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			ch.Errors = append(ch.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		} else {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	desc := distribution.Descriptor{MediaType: mediaType, Digest: ch.Digest, Size: int64(len(payload))}
+
+	if err := ch.applyResourcePolicy(manifest); err != nil {
+		ch.Errors = append(ch.Errors, err)
+		return
+	}
+
+	if ch.Tag != "" {
+		if err := ch.verifySignature(ch.Digest, ch.Tag, manifest); err != nil {
+			ch.Errors = append(ch.Errors, err)
+			return
+		}
+
+		unlock, err := ch.App.lockTag(ch, ch.Repository.Named().Name(), ch.Tag)
+		if err != nil {
+			ch.Errors = append(ch.Errors, err)
+			return
+		}
+		defer unlock()
+
+		if err := ch.checkTagPreconditions(r, ch.Tag); err != nil {
+			ch.Errors = append(ch.Errors, err)
+			return
+		}
+
+		if err := ch.checkTagImmutability(ch.Tag, ch.Digest); err != nil {
+			ch.Errors = append(ch.Errors, err)
+			return
+		}
+	}
+
+	// Admission webhooks are called last, once the registry itself would
+	// accept the copy.
+	if err := ch.admit(r, manifest, desc, payload); err != nil {
+		ch.Errors = append(ch.Errors, err)
+		return
+	}
+
+	ch.copied = make(map[digest.Digest]bool)
+	if err := ch.copyManifest(manifest); err != nil {
+		if errs, ok := err.(errcode.Errors); ok {
+			ch.Errors = append(ch.Errors, errs...)
+		} else {
+			ch.Errors = append(ch.Errors, err)
+		}
+		return
+	}
+
+	if ch.Tag != "" {
+		if err := ch.Repository.Tags(ch).Tag(ch, ch.Tag, desc); err != nil {
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+
+	ref, err := reference.WithDigest(ch.Repository.Named(), ch.Digest)
+	if err != nil {
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	location, err := ch.urlBuilder.BuildManifestURL(ref)
+	if err != nil {
+		dcontext.GetLogger(ch).Errorf("error building manifest url from digest: %v", err)
+	}
+
+	w.Header().Set("Location", location)
+	w.Header().Set("Docker-Content-Digest", ch.Digest.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// resolve sets the digest of the manifest to copy and the target tag.
+func (ch *copyHandler) resolve(ref, tag string) error {
+	if tag != "" {
+		if _, err := reference.WithTag(ch.Repository.Named(), tag); err != nil {
+			return v2.ErrorCodeTagInvalid.WithDetail(err)
+		}
+	}
+
+	if dgst, err := digest.Parse(ref); err == nil {
+		ch.Digest = dgst
+		ch.Tag = tag
+		return nil
+	}
+
+	if _, err := reference.WithTag(ch.source.Named(), ref); err != nil {
+		return v2.ErrorCodeTagInvalid.WithDetail(err)
+	}
+
+	desc, err := ch.source.Tags(ch).Get(ch, ref)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return v2.ErrorCodeManifestUnknown.WithDetail(err)
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+
+	ch.Digest = desc.Digest
+	ch.Tag = tag
+	if ch.Tag == "" {
+		ch.Tag = ref
+	}
+	return nil
+}
+
+// copyManifest links the blobs referenced by the manifest into the target
+// repository, copying the manifests referenced by indexes recursively, and
+// then puts the manifest.
+func (ch *copyHandler) copyManifest(manifest distribution.Manifest) error {
+	_, isIndex := manifest.(*manifestlist.DeserializedManifestList)
+
+	for _, desc := range manifest.References() {
+		if ch.copied[desc.Digest] {
+			continue
+		}
+		ch.copied[desc.Digest] = true
+
+		if !isIndex {
+			if err := ch.mountBlob(desc.Digest); err != nil {
+				return err
+			}
+			continue
+		}
+
+		sourceManifests, err := ch.source.Manifests(ch)
+		if err != nil {
+			return err
+		}
+		child, err := sourceManifests.Get(ch, desc.Digest)
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				return v2.ErrorCodeManifestUnknown.WithDetail(err)
+			}
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		if err := ch.copyManifest(child); err != nil {
+			return err
+		}
+	}
+
+	manifests, err := ch.Repository.Manifests(ch)
+	if err != nil {
+		return err
+	}
+	if _, err := manifests.Put(ch, manifest); err != nil {
+		return manifestPutErrors(err)
+	}
+	return nil
+}
+
+// mountBlob links a blob of the source repository into the target
+// repository.
+func (ch *copyHandler) mountBlob(dgst digest.Digest) error {
+	if _, err := ch.source.Blobs(ch).Stat(ch, dgst); err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return v2.ErrorCodeBlobUnknown.WithDetail(dgst)
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+
+	canonical, err := reference.WithDigest(ch.source.Named(), dgst)
+	if err != nil {
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+
+	upload, err := ch.Repository.Blobs(ch).Create(ch, storage.WithMountFrom(canonical))
+	if err != nil {
+		if _, ok := err.(distribution.ErrBlobMounted); ok {
+			return nil
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+
+	// The mount failed and an upload was started instead.
+	if err := upload.Cancel(ch); err != nil {
+		dcontext.GetLogger(ch).Errorf("error canceling upload after failed mount: %v", err)
+	}
+	return v2.ErrorCodeBlobUnknown.WithDetail(dgst)
+}
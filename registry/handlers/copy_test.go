@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCopyManifest(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	staging, _ := reference.WithName("staging/app")
+	prod, _ := reference.WithName("prod/app")
+
+	pushBlob := func(mediaType string, content []byte) distribution.Descriptor {
+		dgst := digest.FromBytes(content)
+		uploadURLBase, _ := startPushLayer(t, env, staging)
+		pushLayer(t, env.builder, staging, dgst, uploadURLBase, bytes.NewReader(content))
+		return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+	}
+
+	putManifestPayload := func(name reference.Named, ref, mediaType string, payload []byte) {
+		var named reference.Named
+		if dgst, err := digest.Parse(ref); err == nil {
+			named, _ = reference.WithDigest(name, dgst)
+		} else {
+			named, _ = reference.WithTag(name, ref)
+		}
+		u, err := env.builder.BuildManifestURL(named)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", mediaType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	}
+
+	image, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+		Config:    pushBlob(v1.MediaTypeImageConfig, []byte("{}")),
+		Layers:    []distribution.Descriptor{pushBlob(v1.MediaTypeImageLayer, []byte("layer"))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, imagePayload, _ := image.Payload()
+	imageDigest := digest.FromBytes(imagePayload)
+	putManifestPayload(staging, "rc1", v1.MediaTypeImageManifest, imagePayload)
+
+	index, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{{
+		Descriptor: distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: imageDigest, Size: int64(len(imagePayload))},
+		Platform:   manifestlist.PlatformSpec{Architecture: "amd64", OS: "linux"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexMediaType, indexPayload, _ := index.Payload()
+	indexDigest := digest.FromBytes(indexPayload)
+	putManifestPayload(staging, indexDigest.String(), indexMediaType, indexPayload)
+
+	copyManifest := func(msg string, target reference.Named, values url.Values, status int) http.Header {
+		u, err := env.builder.BuildCopyURL(target, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(u, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, status)
+		if status == http.StatusNotFound {
+			checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeManifestUnknown)
+		}
+		return resp.Header
+	}
+
+	expectManifest := func(msg string, name reference.Named, ref string, expected digest.Digest) {
+		var named reference.Named
+		if dgst, err := digest.Parse(ref); err == nil {
+			named, _ = reference.WithDigest(name, dgst)
+		} else {
+			named, _ = reference.WithTag(name, ref)
+		}
+		u, err := env.builder.BuildManifestURL(named)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Accept", v1.MediaTypeImageManifest)
+		req.Header.Add("Accept", v1.MediaTypeImageIndex)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusOK)
+		if dgst := resp.Header.Get("Docker-Content-Digest"); dgst != expected.String() {
+			t.Fatalf("%s: unexpected digest %s != %s", msg, dgst, expected)
+		}
+	}
+
+	// Promote a tag under another name.
+	header := copyManifest("promoting tag", prod, url.Values{
+		"from":      []string{staging.Name()},
+		"reference": []string{"rc1"},
+		"tag":       []string{"1.0"},
+	}, http.StatusCreated)
+	if dgst := header.Get("Docker-Content-Digest"); dgst != imageDigest.String() {
+		t.Fatalf("unexpected digest of copied manifest: %s", dgst)
+	}
+	expectManifest("pulling promoted tag", prod, "1.0", imageDigest)
+
+	// The layers were linked into the target repository.
+	for _, desc := range image.References() {
+		ref, _ := reference.WithDigest(prod, desc.Digest)
+		u, _ := env.builder.BuildBlobURL(ref)
+		resp, err := http.Head(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		checkResponse(t, "checking copied blob", resp, http.StatusOK)
+	}
+
+	// Indexes are copied with the manifests they reference.
+	other, _ := reference.WithName("other/app")
+	copyManifest("copying index", other, url.Values{
+		"from":      []string{staging.Name()},
+		"reference": []string{indexDigest.String()},
+		"tag":       []string{"latest"},
+	}, http.StatusCreated)
+	expectManifest("pulling copied index", other, "latest", indexDigest)
+	expectManifest("pulling manifest of copied index", other, imageDigest.String(), imageDigest)
+
+	// The target tag must satisfy preconditions.
+	u, _ := env.builder.BuildCopyURL(prod, url.Values{
+		"from":      []string{staging.Name()},
+		"reference": []string{"rc1"},
+		"tag":       []string{"1.0"},
+	})
+	req, _ := http.NewRequest(http.MethodPost, u, nil)
+	req.Header.Set("If-None-Match", "*")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponse(t, "copying onto existing tag with If-None-Match", resp, http.StatusPreconditionFailed)
+	checkBodyHasErrorCodes(t, "copying onto existing tag with If-None-Match", resp, v2.ErrorCodePreconditionFailed)
+	resp.Body.Close()
+
+	copyManifest("copying unknown tag", prod, url.Values{
+		"from":      []string{staging.Name()},
+		"reference": []string{"missing"},
+	}, http.StatusNotFound)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/opencontainers/go-digest"
+)
+
+// immutableTagRule makes the tags matching one of a set of regular
+// expressions immutable in the repositories it matches.
+type immutableTagRule struct {
+	repositories *auth.RepositoryMatcher
+	tags         []*regexp.Regexp
+}
+
+// configureImmutableTags compiles the immutable tag rules.
+func (app *App) configureImmutableTags(config *configuration.Configuration) error {
+	for i, rc := range config.Policy.Tags.Immutable {
+		if len(rc.Repositories) == 0 || len(rc.Tags) == 0 {
+			return fmt.Errorf("immutable tag rule %d must list repositories and tags", i)
+		}
+
+		repositories, err := auth.NewRepositoryMatcher(rc.Repositories...)
+		if err != nil {
+			return fmt.Errorf("immutable tag rule %d: %v", i, err)
+		}
+
+		rule := immutableTagRule{repositories: repositories}
+		for _, expr := range rc.Tags {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return fmt.Errorf("immutable tag rule %d: invalid tag expression %q: %v", i, expr, err)
+			}
+			rule.tags = append(rule.tags, re)
+		}
+
+		app.immutableTags = append(app.immutableTags, rule)
+	}
+
+	return nil
+}
+
+// hasImmutableTags returns true if some tags of the repository are
+// immutable.
+func (app *App) hasImmutableTags(repository string) bool {
+	for _, rule := range app.immutableTags {
+		if rule.repositories.Match(repository) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagImmutable returns true if the tag of the repository is immutable.
+func (app *App) tagImmutable(repository, tag string) bool {
+	for _, rule := range app.immutableTags {
+		if !rule.repositories.Match(repository) {
+			continue
+		}
+		for _, re := range rule.tags {
+			if re.MatchString(tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errTagImmutable returns the error for an attempt to change an immutable
+// tag.
+func errTagImmutable(tag string, dgst digest.Digest) error {
+	detail := map[string]string{"tag": tag}
+	if dgst != "" {
+		detail["digest"] = dgst.String()
+	}
+	return errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf("tag %s is immutable", tag)).WithDetail(detail)
+}
+
+// checkTagImmutability returns a DENIED error if the tag is immutable and
+// already points to a manifest other than dgst. Pushing an immutable tag
+// for the first time or pushing the same manifest again is allowed.
+func (imh *manifestHandler) checkTagImmutability(tag string, dgst digest.Digest) error {
+	name := imh.Repository.Named().Name()
+	if !imh.App.tagImmutable(name, tag) {
+		return nil
+	}
+
+	desc, err := imh.Repository.Tags(imh).Get(imh, tag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return nil
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+
+	if desc.Digest == dgst {
+		return nil
+	}
+
+	return errTagImmutable(tag, desc.Digest)
+}
+
+// checkManifestDeletable returns a DENIED error if one of the tags of the
+// manifest dgst is immutable.
+func (app *App) checkManifestDeletable(ctx context.Context, repository distribution.Repository, dgst digest.Digest) error {
+	name := repository.Named().Name()
+	if !app.hasImmutableTags(name) {
+		return nil
+	}
+
+	tags, err := currentTags(ctx, repository, dgst)
+	if err != nil {
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+
+	for _, tag := range tags {
+		if app.tagImmutable(name, tag) {
+			return errTagImmutable(tag, dgst)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/schema1"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestImmutableTags(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"delete":     configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true
+	config.HTTP.Headers = headerConfig
+	config.Policy.Tags.Immutable = []configuration.ImmutableTagRule{
+		{Repositories: []string{"prod/**"}, Tags: []string{`v\d+\.\d+\.\d+`}},
+	}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	released := createRepository(env, t, "prod/app", "v1.0.0")
+
+	// Mutable tags can be moved.
+	createRepository(env, t, "prod/app", "latest")
+	createRepository(env, t, "prod/app", "latest")
+
+	imageName, _ := reference.WithName("prod/app")
+	manifestURL := func(ref string) string {
+		var named reference.Named
+		if dgst, err := digest.Parse(ref); err == nil {
+			named, _ = reference.WithDigest(imageName, dgst)
+		} else {
+			named, _ = reference.WithTag(imageName, ref)
+		}
+
+		u, err := env.builder.BuildManifestURL(named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	do := func(method, u, contentType string, body []byte) *http.Response {
+		req, err := http.NewRequest(method, u, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	expectDenied := func(msg string, resp *http.Response) {
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, http.StatusForbidden)
+		checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeDenied)
+	}
+
+	// Moving an immutable tag is denied.
+	other, err := schema1.Sign(&schema1.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 1},
+		Name:      "prod/app",
+		Tag:       "v1.0.0",
+		FSLayers:  []schema1.FSLayer{{BlobSum: digest.FromString("layer")}},
+		History:   []schema1.History{{V1Compatibility: ""}},
+	}, env.pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDenied("moving immutable tag", putManifest(t, "moving immutable tag", manifestURL("v1.0.0"), "", other))
+
+	// Pushing the same manifest again is allowed.
+	resp := do(http.MethodGet, manifestURL("v1.0.0"), "", nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponse(t, "getting immutable tag", resp, http.StatusOK)
+
+	resp = do(http.MethodPut, manifestURL("v1.0.0"), resp.Header.Get("Content-Type"), body)
+	resp.Body.Close()
+	checkResponse(t, "pushing immutable tag again", resp, http.StatusCreated)
+	if dgst := resp.Header.Get("Docker-Content-Digest"); dgst != released.String() {
+		t.Errorf("unexpected digest pushing immutable tag again: %s", dgst)
+	}
+
+	// Immutable tags and their manifests cannot be deleted.
+	expectDenied("deleting immutable tag", do(http.MethodDelete, manifestURL("v1.0.0"), "", nil))
+	expectDenied("deleting manifest of immutable tag", do(http.MethodDelete, manifestURL(released.String()), "", nil))
+
+	resp = do(http.MethodDelete, manifestURL("latest"), "", nil)
+	resp.Body.Close()
+	checkResponse(t, "deleting mutable tag", resp, http.StatusAccepted)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"mime"
 	"net/http"
@@ -83,6 +84,11 @@ type manifestHandler struct {
 // GetManifest fetches the image manifest from the storage backend, if it exists.
 func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("GetImageManifest")
+
+	if r.Method == http.MethodGet && !checkRateLimit(imh.Context, w, r, imh.App.rateLimits.manifests, 1) {
+		return
+	}
+
 	manifests, err := imh.Repository.Manifests(imh)
 	if err != nil {
 		imh.Errors = append(imh.Errors, err)
@@ -150,6 +156,14 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+
+	if r.Method == http.MethodGet {
+		if err := imh.verifySignature(imh.Digest, imh.Tag, manifest); err != nil {
+			imh.Errors = append(imh.Errors, err)
+			return
+		}
+	}
+
 	// determine the type of the returned manifest
 	manifestType := manifestSchema1
 	schema2Manifest, isSchema2 := manifest.(*schema2.DeserializedManifest)
@@ -289,6 +303,10 @@ func etagMatch(r *http.Request, etag string) bool {
 // PutManifest validates and stores a manifest in the registry.
 func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("PutImageManifest")
+	defer func() {
+		imh.App.auditMutation(imh.Context, r, auditActionPush, imh.Tag, imh.Digest)
+	}()
+
 	manifests, err := imh.Repository.Manifests(imh)
 	if err != nil {
 		imh.Errors = append(imh.Errors, err)
@@ -340,41 +358,43 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err = manifests.Put(imh, manifest, options...)
-	if err != nil {
-		// TODO(stevvooe): These error handling switches really need to be
-		// handled by an app global mapper.
-		if err == distribution.ErrUnsupported {
-			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
+	// Manifests must be signed before they are tagged.
+	if imh.Tag != "" {
+		if err := imh.verifySignature(desc.Digest, imh.Tag, manifest); err != nil {
+			imh.Errors = append(imh.Errors, err)
 			return
 		}
-		if err == distribution.ErrAccessDenied {
-			imh.Errors = append(imh.Errors, errcode.ErrorCodeDenied)
+	}
+
+	if imh.Tag != "" {
+		unlock, err := imh.App.lockTag(imh, imh.Repository.Named().Name(), imh.Tag)
+		if err != nil {
+			imh.Errors = append(imh.Errors, err)
 			return
 		}
-		switch err := err.(type) {
-		case distribution.ErrManifestVerification:
-			for _, verificationError := range err {
-				switch verificationError := verificationError.(type) {
-				case distribution.ErrManifestBlobUnknown:
-					imh.Errors = append(imh.Errors, v2.ErrorCodeManifestBlobUnknown.WithDetail(verificationError.Digest))
-				case distribution.ErrManifestNameInvalid:
-					imh.Errors = append(imh.Errors, v2.ErrorCodeNameInvalid.WithDetail(err))
-				case distribution.ErrManifestUnverified:
-					imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnverified)
-				default:
-					if verificationError == digest.ErrDigestInvalidFormat {
-						imh.Errors = append(imh.Errors, v2.ErrorCodeDigestInvalid)
-					} else {
-						imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown, verificationError)
-					}
-				}
-			}
-		case errcode.Error:
+		defer unlock()
+
+		if err := imh.checkTagPreconditions(r, imh.Tag); err != nil {
 			imh.Errors = append(imh.Errors, err)
-		default:
-			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
 		}
+
+		if err := imh.checkTagImmutability(imh.Tag, desc.Digest); err != nil {
+			imh.Errors = append(imh.Errors, err)
+			return
+		}
+	}
+
+	// Admission webhooks are called last, once the registry itself would
+	// accept the manifest.
+	if err := imh.admit(r, manifest, desc, jsonBuf.Bytes()); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
+	_, err = manifests.Put(imh, manifest, options...)
+	if err != nil {
+		imh.Errors = append(imh.Errors, manifestPutErrors(err)...)
 		return
 	}
 
@@ -485,6 +505,9 @@ func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest)
 // DeleteManifest removes the manifest with the given digest or the tag with the given name from the registry.
 func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("DeleteImageManifest")
+	defer func() {
+		imh.App.auditMutation(imh.Context, r, auditActionDelete, imh.Tag, imh.Digest)
+	}()
 
 	if imh.App.isCache {
 		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
@@ -493,58 +516,171 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 
 	if imh.Tag != "" {
 		dcontext.GetLogger(imh).Debug("DeleteImageTag")
-		tagService := imh.Repository.Tags(imh.Context)
-		if err := tagService.Untag(imh.Context, imh.Tag); err != nil {
-			switch err.(type) {
-			case distribution.ErrTagUnknown, driver.PathNotFoundError:
-				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
-			default:
-				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
-			}
+		if imh.App.tagImmutable(imh.Repository.Named().Name(), imh.Tag) {
+			imh.Errors = append(imh.Errors, errTagImmutable(imh.Tag, ""))
+			return
+		}
+
+		if err := imh.App.untag(imh, imh.Repository, imh.Tag); err != nil {
+			imh.Errors = append(imh.Errors, err)
 			return
 		}
 		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
-	manifests, err := imh.Repository.Manifests(imh)
-	if err != nil {
+	if err := imh.App.checkManifestDeletable(imh, imh.Repository, imh.Digest); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
+	if _, err := imh.App.deleteManifest(imh, imh.Repository, imh.Digest); err != nil {
 		imh.Errors = append(imh.Errors, err)
 		return
 	}
 
-	err = manifests.Delete(imh, imh.Digest)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// untag removes a tag of the repository while holding its lock.
+func (app *App) untag(ctx context.Context, repository distribution.Repository, tag string) error {
+	unlock, err := app.lockTag(ctx, repository.Named().Name(), tag)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := repository.Tags(ctx).Untag(ctx, tag); err != nil {
+		switch err.(type) {
+		case distribution.ErrTagUnknown, driver.PathNotFoundError:
+			return v2.ErrorCodeManifestUnknown.WithDetail(err)
+		default:
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+	}
+	return nil
+}
+
+// deleteManifest deletes the manifest dgst of the repository along with the
+// tags referencing it, which are returned. The tags are removed first, so
+// that a failure midway never leaves tags referencing a deleted manifest.
+func (app *App) deleteManifest(ctx context.Context, repository distribution.Repository, dgst digest.Digest) ([]string, error) {
+	if !app.deleteEnabled {
+		return nil, errcode.ErrorCodeUnsupported
+	}
+
+	manifests, err := repository.Manifests(ctx)
 	if err != nil {
+		return nil, err
+	}
+
+	exists, err := manifests.Exists(ctx, dgst)
+	if err != nil {
+		return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	if !exists {
+		return nil, v2.ErrorCodeManifestUnknown.WithDetail(dgst)
+	}
+
+	referencedTags, err := currentTags(ctx, repository, dgst)
+	if err != nil {
+		return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+
+	for _, tag := range referencedTags {
+		if err := app.untag(ctx, repository, tag); err != nil {
+			// The tag may have been removed concurrently.
+			if err, ok := err.(errcode.Error); ok && err.Code == v2.ErrorCodeManifestUnknown {
+				continue
+			}
+			return nil, err
+		}
+	}
+
+	if err := manifests.Delete(ctx, dgst); err != nil {
 		switch err {
-		case digest.ErrDigestUnsupported:
-		case digest.ErrDigestInvalidFormat:
-			imh.Errors = append(imh.Errors, v2.ErrorCodeDigestInvalid)
-			return
+		case digest.ErrDigestUnsupported, digest.ErrDigestInvalidFormat:
+			return nil, v2.ErrorCodeDigestInvalid
 		case distribution.ErrBlobUnknown:
-			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown)
-			return
+			return nil, v2.ErrorCodeManifestUnknown
 		case distribution.ErrUnsupported:
-			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
-			return
+			return nil, errcode.ErrorCodeUnsupported
 		default:
-			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown)
-			return
+			return nil, errcode.ErrorCodeUnknown.WithDetail(err)
 		}
 	}
 
-	tagService := imh.Repository.Tags(imh)
-	referencedTags, err := tagService.Lookup(imh, distribution.Descriptor{Digest: imh.Digest})
+	return referencedTags, nil
+}
+
+// currentTags returns the tags of the repository pointing at dgst. Rather
+// than looking them up in the tag reverse index, which may miss tags moved
+// concurrently or by an older registry, it reads the current revision of
+// every tag, so that deletions never leave a tag behind.
+func currentTags(ctx context.Context, repository distribution.Repository, dgst digest.Digest) ([]string, error) {
+	tagService := repository.Tags(ctx)
+
+	allTags, err := tagService.All(ctx)
 	if err != nil {
-		imh.Errors = append(imh.Errors, err)
-		return
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	for _, tag := range referencedTags {
-		if err := tagService.Untag(imh, tag); err != nil {
-			imh.Errors = append(imh.Errors, err)
-			return
+	var tags []string
+	for _, tag := range allTags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		if desc.Digest == dgst {
+			tags = append(tags, tag)
 		}
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	return tags, nil
+}
+
+// manifestPutErrors maps the error of a manifest put to the errors
+// reported to the client.
+func manifestPutErrors(err error) errcode.Errors {
+	// TODO(stevvooe): These error handling switches really need to be
+	// handled by an app global mapper.
+	if err == distribution.ErrUnsupported {
+		return errcode.Errors{errcode.ErrorCodeUnsupported}
+	}
+	if err == distribution.ErrAccessDenied {
+		return errcode.Errors{errcode.ErrorCodeDenied}
+	}
+
+	var errs errcode.Errors
+	switch err := err.(type) {
+	case distribution.ErrManifestVerification:
+		for _, verificationError := range err {
+			switch verificationError := verificationError.(type) {
+			case distribution.ErrManifestBlobUnknown:
+				errs = append(errs, v2.ErrorCodeManifestBlobUnknown.WithDetail(verificationError.Digest))
+			case distribution.ErrManifestNameInvalid:
+				errs = append(errs, v2.ErrorCodeNameInvalid.WithDetail(err))
+			case distribution.ErrManifestUnverified:
+				errs = append(errs, v2.ErrorCodeManifestUnverified)
+			default:
+				if verificationError == digest.ErrDigestInvalidFormat {
+					errs = append(errs, v2.ErrorCodeDigestInvalid)
+				} else {
+					errs = append(errs, errcode.ErrorCodeUnknown, verificationError)
+				}
+			}
+		}
+	case errcode.Error:
+		errs = append(errs, err)
+	default:
+		errs = append(errs, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+	return errs
 }
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/lock"
+)
+
+// configureTagLocks sets up the locks serializing updates of tags, kept in
+// redis when it is configured so that they hold across registry instances.
+func (app *App) configureTagLocks() {
+	if app.redis != nil {
+		app.tagLocks = lock.NewRedisLocker(app.redis, "taglock::")
+	} else {
+		app.tagLocks = lock.NewInMemoryLocker()
+	}
+}
+
+// lockTag acquires the lock of a tag of the repository, so that reading the
+// tag, checking preconditions and updating it happen atomically with
+// respect to other updates of the tag.
+func (app *App) lockTag(ctx context.Context, repository, tag string) (func(), error) {
+	unlock, err := app.tagLocks.Lock(ctx, repository+":"+tag)
+	if err != nil {
+		if err == lock.ErrTimeout {
+			return nil, errcode.ErrorCodeUnavailable.WithDetail(fmt.Sprintf("tag %s is being updated", tag))
+		}
+		return nil, errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	return unlock, nil
+}
+
+// checkTagPreconditions evaluates the If-Match and If-None-Match headers of
+// a request updating a tag against the digest the tag currently points to,
+// returning a PRECONDITION_FAILED error if they are not satisfied.
+//
+// Entity tags are manifest digests, as returned in the Etag header of
+// manifest GET requests. If-Match: * requires the tag to exist and
+// If-None-Match: * requires it not to exist.
+func (imh *manifestHandler) checkTagPreconditions(r *http.Request, tag string) error {
+	ifMatch := parseETags(r.Header["If-Match"])
+	ifNoneMatch := parseETags(r.Header["If-None-Match"])
+	if ifMatch == nil && ifNoneMatch == nil {
+		return nil
+	}
+
+	var current string
+	desc, err := imh.Repository.Tags(imh).Get(imh, tag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); !ok {
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+	} else {
+		current = desc.Digest.String()
+	}
+
+	detail := map[string]string{"tag": tag}
+	if current != "" {
+		detail["digest"] = current
+	}
+
+	if ifMatch != nil && (current == "" || !etagsMatch(ifMatch, current)) {
+		return v2.ErrorCodePreconditionFailed.WithMessage(fmt.Sprintf("tag %s does not match If-Match", tag)).WithDetail(detail)
+	}
+
+	if ifNoneMatch != nil && current != "" && etagsMatch(ifNoneMatch, current) {
+		return v2.ErrorCodePreconditionFailed.WithMessage(fmt.Sprintf("tag %s matches If-None-Match", tag)).WithDetail(detail)
+	}
+
+	return nil
+}
+
+// parseETags returns the entity tags listed in the values of a
+// precondition header, without quotes or weakness indicators, or nil if
+// the header is absent.
+func parseETags(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	etags := []string{}
+	for _, value := range values {
+		for _, etag := range strings.Split(value, ",") {
+			etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+			etag = strings.Trim(etag, `"`) // allow quoted or unquoted
+			if etag != "" {
+				etags = append(etags, etag)
+			}
+		}
+	}
+	return etags
+}
+
+// etagsMatch returns true if one of etags is the wildcard or equal to etag.
+func etagsMatch(etags []string, etag string) bool {
+	for _, e := range etags {
+		if e == "*" || e == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestTagPreconditions(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/conditional")
+
+	pushBlob := func(content []byte) distribution.Descriptor {
+		dgst := digest.FromBytes(content)
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(content))
+		return distribution.Descriptor{MediaType: v1.MediaTypeImageLayer, Digest: dgst, Size: int64(len(content))}
+	}
+
+	imageConfig := pushBlob([]byte("{}"))
+	imageConfig.MediaType = v1.MediaTypeImageConfig
+	newManifest := func(layer string) ([]byte, digest.Digest) {
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:    imageConfig,
+			Layers:    []distribution.Descriptor{pushBlob([]byte(layer))},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, payload, _ := m.Payload()
+		return payload, digest.FromBytes(payload)
+	}
+
+	tagRef, _ := reference.WithTag(name, "latest")
+	u, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(msg string, payload []byte, header, value string, status int) {
+		req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", v1.MediaTypeImageManifest)
+		req.Header.Set(header, value)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, msg, resp, status)
+		if status == http.StatusPreconditionFailed {
+			checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodePreconditionFailed)
+		}
+	}
+
+	first, firstDigest := newManifest("first")
+	second, secondDigest := newManifest("second")
+
+	put("if-match on missing tag", first, "If-Match", "*", http.StatusPreconditionFailed)
+	put("create-only on missing tag", first, "If-None-Match", "*", http.StatusCreated)
+	put("create-only on existing tag", second, "If-None-Match", "*", http.StatusPreconditionFailed)
+
+	put("if-match with other digest", second, "If-Match", fmt.Sprintf(`"%s"`, secondDigest), http.StatusPreconditionFailed)
+	put("if-none-match with current digest", second, "If-None-Match", fmt.Sprintf(`"%s"`, firstDigest), http.StatusPreconditionFailed)
+	put("if-match with current digest", second, "If-Match", fmt.Sprintf(`"%s", "%s"`, digest.FromString("other"), firstDigest), http.StatusCreated)
+
+	// The tag was moved, so the previous digest no longer matches.
+	put("if-match with stale digest", first, "If-Match", fmt.Sprintf(`"%s"`, firstDigest), http.StatusPreconditionFailed)
+	put("if-match on existing tag", first, "If-Match", "*", http.StatusCreated)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
+	"github.com/docker/go-metrics"
+)
+
+// Names of the rate limits, used in bucket keys and metrics.
+const (
+	rateLimitManifests = "manifests"
+	rateLimitBlobBytes = "blobbytes"
+	rateLimitUploads   = "uploads"
+)
+
+// Keys rate limits may be applied by.
+const (
+	rateLimitKeyUser       = "user"
+	rateLimitKeyIP         = "ip"
+	rateLimitKeyRepository = "repository"
+)
+
+var rateLimitRejections = prometheus.RateLimitNamespace.NewLabeledCounter("rejections", "The number of requests rejected by rate limits", "limit")
+
+func init() {
+	metrics.Register(prometheus.RateLimitNamespace)
+}
+
+// rateLimit is a single configured rate limit.
+type rateLimit struct {
+	name    string
+	key     string
+	limiter ratelimit.Limiter
+}
+
+// rateLimits holds the configured rate limits. A nil limit is disabled.
+type rateLimits struct {
+	manifests *rateLimit
+	blobBytes *rateLimit
+	uploads   *rateLimit
+}
+
+// configureRateLimits sets up the rate limits, keeping their buckets in
+// redis or in memory.
+func (app *App) configureRateLimits(config *configuration.Configuration) error {
+	if !config.RateLimit.Enabled {
+		return nil
+	}
+
+	switch config.RateLimit.Backend {
+	case "", "inmemory":
+	case "redis":
+		if app.redis == nil {
+			return fmt.Errorf("redis configuration required to use redis rate limits")
+		}
+	default:
+		return fmt.Errorf("unknown rate limit backend %q", config.RateLimit.Backend)
+	}
+
+	var err error
+	for _, limit := range []struct {
+		name   string
+		config configuration.RateLimitBucket
+		dest   **rateLimit
+	}{
+		{rateLimitManifests, config.RateLimit.Manifests, &app.rateLimits.manifests},
+		{rateLimitBlobBytes, config.RateLimit.BlobBytes, &app.rateLimits.blobBytes},
+		{rateLimitUploads, config.RateLimit.Uploads, &app.rateLimits.uploads},
+	} {
+		*limit.dest, err = app.newRateLimit(limit.name, config.RateLimit.Backend, limit.config)
+		if err != nil {
+			return fmt.Errorf("ratelimit %s: %v", limit.name, err)
+		}
+	}
+
+	return nil
+}
+
+// newRateLimit creates the named rate limit, returning nil if it has no rate.
+func (app *App) newRateLimit(name, backend string, config configuration.RateLimitBucket) (*rateLimit, error) {
+	if config.Rate == 0 {
+		return nil, nil
+	}
+
+	switch config.Key {
+	case "":
+		config.Key = rateLimitKeyUser
+	case rateLimitKeyUser, rateLimitKeyIP, rateLimitKeyRepository:
+	default:
+		return nil, fmt.Errorf("unknown key %q", config.Key)
+	}
+
+	rate := ratelimit.Rate{PerSecond: config.Rate, Burst: config.Burst}
+	if rate.Burst == 0 {
+		rate.Burst = int64(math.Ceil(config.Rate))
+	}
+
+	var (
+		limiter ratelimit.Limiter
+		err     error
+	)
+	if backend == "redis" {
+		limiter, err = ratelimit.NewRedisLimiter(app.redis, "ratelimit::", rate)
+	} else {
+		limiter, err = ratelimit.NewInMemoryLimiter(rate)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &rateLimit{name: name, key: config.Key, limiter: limiter}, nil
+}
+
+// bucketKey returns the key of the bucket the request takes tokens from.
+// Anonymous requests limited by user are limited by IP instead.
+func (limit *rateLimit) bucketKey(ctx *Context, r *http.Request) string {
+	var key string
+	switch limit.key {
+	case rateLimitKeyUser:
+		if user := dcontext.GetStringValue(ctx, auth.UserNameKey); user != "" {
+			key = "user:" + user
+		} else {
+			key = "ip:" + dcontext.RemoteIP(r)
+		}
+	case rateLimitKeyIP:
+		key = "ip:" + dcontext.RemoteIP(r)
+	case rateLimitKeyRepository:
+		key = "repository:" + getName(ctx)
+	}
+
+	return limit.name + ":" + key
+}
+
+// checkRateLimit takes n tokens from the request's bucket of limit. When
+// the bucket is exhausted it sets the Retry-After header, records a
+// TOOMANYREQUESTS error and returns false; the request must not proceed.
+// Errors from the limiter are logged and the request is allowed.
+func checkRateLimit(ctx *Context, w http.ResponseWriter, r *http.Request, limit *rateLimit, n int64) bool {
+	if limit == nil {
+		return true
+	}
+
+	key := limit.bucketKey(ctx, r)
+
+	ok, retryAfter, err := limit.limiter.Allow(ctx, key, n)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error checking rate limit %s: %v", key, err)
+		return true
+	}
+	if ok {
+		return true
+	}
+
+	rateLimitRejections.WithValues(limit.name).Inc(1)
+
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+
+	dcontext.GetLogger(ctx).Infof("rate limit %s exceeded, retry after %v", key, retryAfter)
+
+	ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithDetail(map[string]interface{}{
+		"limit":      limit.name,
+		"retryAfter": (time.Duration(seconds) * time.Second).String(),
+	}))
+
+	return false
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestRateLimits(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.RateLimit.Enabled = true
+	config.RateLimit.Manifests = configuration.RateLimitBucket{Key: "repository", Rate: 0.01, Burst: 2}
+	config.RateLimit.Uploads = configuration.RateLimitBucket{Key: "ip", Rate: 0.01, Burst: 1}
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	limited, _ := reference.WithName("foo/limited")
+	other, _ := reference.WithName("foo/other")
+
+	get := func(name reference.Named) *http.Response {
+		ref, _ := reference.WithTag(name, "latest")
+		u, err := env.builder.BuildManifestURL(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := get(limited)
+		checkResponse(t, "getting manifest within burst", resp, http.StatusNotFound)
+		resp.Body.Close()
+	}
+
+	resp := get(limited)
+	defer resp.Body.Close()
+	checkResponse(t, "getting manifest beyond burst", resp, http.StatusTooManyRequests)
+	checkBodyHasErrorCodes(t, "getting manifest beyond burst", resp, errcode.ErrorCodeTooManyRequests)
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "100" {
+		t.Errorf("unexpected Retry-After: %q", retryAfter)
+	}
+
+	// HEAD requests are not limited, nor are other repositories.
+	ref, _ := reference.WithTag(limited, "latest")
+	u, _ := env.builder.BuildManifestURL(ref)
+	headResp, err := http.Head(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headResp.Body.Close()
+	checkResponse(t, "checking manifest beyond burst", headResp, http.StatusNotFound)
+
+	otherResp := get(other)
+	otherResp.Body.Close()
+	checkResponse(t, "getting manifest of other repository", otherResp, http.StatusNotFound)
+
+	upload := func() *http.Response {
+		u, err := env.builder.BuildBlobUploadURL(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(u, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	uploadResp := upload()
+	uploadResp.Body.Close()
+	checkResponse(t, "starting upload within burst", uploadResp, http.StatusAccepted)
+
+	uploadResp = upload()
+	defer uploadResp.Body.Close()
+	checkResponse(t, "starting upload beyond burst", uploadResp, http.StatusTooManyRequests)
+	checkBodyHasErrorCodes(t, "starting upload beyond burst", uploadResp, errcode.ErrorCodeTooManyRequests)
+}
+
+func TestServedLength(t *testing.T) {
+	desc := distribution.Descriptor{Digest: digest.FromString("blob"), Size: 1000}
+	etag := `"` + desc.Digest.String() + `"`
+
+	for _, testcase := range []struct {
+		rangeHeader string
+		ifRange     string
+		expected    int64
+	}{
+		{"", "", 1000},
+		{"bytes=0-99", "", 100},
+		{"bytes=900-", "", 100},
+		{"bytes=-10", "", 10},
+		{"bytes=-5000", "", 1000},
+		{"bytes=990-5000", "", 10},
+		{"bytes=0-9, 20-29", "", 20},
+		{"bytes=2000-", "", 0},
+		{"bytes=0-99", etag, 100},
+		{"bytes=0-99", `"sha256:other"`, 1000},
+		{"bytes=0-999, 0-999", "", 1000},
+		{"bytes=a-b", "", 1000},
+		{"items=0-9", "", 1000},
+	} {
+		r, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testcase.rangeHeader != "" {
+			r.Header.Set("Range", testcase.rangeHeader)
+		}
+		if testcase.ifRange != "" {
+			r.Header.Set("If-Range", testcase.ifRange)
+		}
+
+		if actual := servedLength(r, desc); actual != testcase.expected {
+			t.Errorf("Range %q, If-Range %q: expected %d, got %d", testcase.rangeHeader, testcase.ifRange, testcase.expected, actual)
+		}
+	}
+}
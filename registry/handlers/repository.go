@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/gorilla/handlers"
+)
+
+// repositoryDispatcher constructs the handler of operations on a repository
+// as a whole.
+func repositoryDispatcher(ctx *Context, r *http.Request) http.Handler {
+	repositoryHandler := &repositoryHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		"DELETE": http.HandlerFunc(repositoryHandler.DeleteRepository),
+	}
+}
+
+// repositoryHandler handles requests for a repository.
+type repositoryHandler struct {
+	*Context
+}
+
+// DeleteRepository removes the tags, manifests and layer links of a
+// repository. Blobs are left for the garbage collector.
+func (rh *repositoryHandler) DeleteRepository(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(rh).Debug("DeleteRepository")
+	defer func() {
+		rh.App.auditMutation(rh.Context, r, auditActionDelete, "", "")
+	}()
+
+	if rh.App.isCache || !rh.App.deleteEnabled || rh.RepositoryRemover == nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	name := rh.Repository.Named().Name()
+	tags, err := rh.Repository.Tags(rh).All(rh)
+	if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrRepositoryUnknown:
+			rh.Errors = append(rh.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": name}))
+		case errcode.Error:
+			rh.Errors = append(rh.Errors, err)
+		default:
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	// Deleting the repository would delete its immutable tags.
+	for _, tag := range tags {
+		if rh.App.tagImmutable(name, tag) {
+			rh.Errors = append(rh.Errors, errTagImmutable(tag, ""))
+			return
+		}
+	}
+
+	if err := rh.RepositoryRemover.Remove(rh, rh.Repository.Named()); err != nil {
+		switch err.(type) {
+		case driver.PathNotFoundError:
+			rh.Errors = append(rh.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": name}))
+		default:
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+)
+
+func TestDeleteRepository(t *testing.T) {
+	newEnv := func(deleteEnabled bool) *testEnv {
+		config := configuration.Configuration{
+			Storage: configuration.Storage{
+				"testdriver": configuration.Parameters{},
+				"delete":     configuration.Parameters{"enabled": deleteEnabled},
+				"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+					"enabled": false,
+				}},
+			},
+		}
+		config.Compatibility.Schema1.Enabled = true
+		config.HTTP.Headers = headerConfig
+		config.Policy.Tags.Immutable = []configuration.ImmutableTagRule{
+			{Repositories: []string{"prod/**"}, Tags: []string{"v.*"}},
+		}
+		return newTestEnvWithConfig(t, &config)
+	}
+
+	do := func(env *testEnv, method, name string) *http.Response {
+		named, _ := reference.WithName(name)
+
+		var (
+			u   string
+			err error
+		)
+		if method == http.MethodDelete {
+			u, err = env.builder.BuildRepositoryURL(named)
+		} else {
+			u, err = env.builder.BuildTagsURL(named)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	expect := func(msg string, resp *http.Response, status int, code errcode.ErrorCode) {
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, status)
+		if code != 0 {
+			checkBodyHasErrorCodes(t, msg, resp, code)
+		}
+	}
+
+	env := newEnv(false)
+	createRepository(env, t, "foo/bar", "latest")
+	expect("deleting repository with delete disabled", do(env, http.MethodDelete, "foo/bar"), http.StatusMethodNotAllowed, errcode.ErrorCodeUnsupported)
+	expect("listing tags with delete disabled", do(env, http.MethodGet, "foo/bar"), http.StatusOK, 0)
+	env.Shutdown()
+
+	env = newEnv(true)
+	defer env.Shutdown()
+
+	createRepository(env, t, "foo/bar", "latest")
+	createRepository(env, t, "foo/bar", "other")
+	createRepository(env, t, "foo/baz", "latest")
+
+	expect("deleting repository", do(env, http.MethodDelete, "foo/bar"), http.StatusAccepted, 0)
+	expect("listing tags of deleted repository", do(env, http.MethodGet, "foo/bar"), http.StatusNotFound, v2.ErrorCodeNameUnknown)
+	expect("deleting deleted repository", do(env, http.MethodDelete, "foo/bar"), http.StatusNotFound, v2.ErrorCodeNameUnknown)
+	expect("listing tags of other repository", do(env, http.MethodGet, "foo/baz"), http.StatusOK, 0)
+
+	// Repositories nested under the name of a deleted one are kept.
+	createRepository(env, t, "foo", "latest")
+	createRepository(env, t, "foo/bar", "latest")
+	expect("deleting parent repository", do(env, http.MethodDelete, "foo"), http.StatusAccepted, 0)
+	expect("listing tags of deleted parent repository", do(env, http.MethodGet, "foo"), http.StatusNotFound, v2.ErrorCodeNameUnknown)
+	expect("listing tags of nested repository", do(env, http.MethodGet, "foo/bar"), http.StatusOK, 0)
+	expect("listing tags of other nested repository", do(env, http.MethodGet, "foo/baz"), http.StatusOK, 0)
+
+	// Repositories with immutable tags cannot be deleted.
+	createRepository(env, t, "prod/app", "v1")
+	expect("deleting repository with immutable tags", do(env, http.MethodDelete, "prod/app"), http.StatusForbidden, errcode.ErrorCodeDenied)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/signature"
+	"github.com/opencontainers/go-digest"
+)
+
+// configureSignatures sets up the policies requiring manifests to be signed.
+func (app *App) configureSignatures(config *configuration.Configuration) error {
+	for i, pc := range config.Signatures.Policies {
+		var keys []crypto.PublicKey
+		for _, path := range pc.Keys {
+			key, err := signature.LoadPublicKey(path)
+			if err != nil {
+				return fmt.Errorf("signature policy %d: %v", i, err)
+			}
+			keys = append(keys, key)
+		}
+
+		policy, err := signature.NewPolicy(pc.Repositories, pc.Mode, keys...)
+		if err != nil {
+			return fmt.Errorf("signature policy %d: %v", i, err)
+		}
+
+		app.signaturePolicies = append(app.signaturePolicies, policy)
+	}
+
+	return nil
+}
+
+// signaturePolicy returns the first policy applying to the repository, or
+// nil.
+func (app *App) signaturePolicy(name string) *signature.Policy {
+	for _, policy := range app.signaturePolicies {
+		if policy.Matches(name) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// verifySignature applies the signature policy of the repository to the
+// manifest dgst, referenced by tag if not empty, returning a DENIED error if
+// the policy is enforced and the manifest is not signed. Signatures
+// themselves need not be signed.
+func (imh *manifestHandler) verifySignature(dgst digest.Digest, tag string, manifest distribution.Manifest) error {
+	name := imh.Repository.Named().Name()
+
+	policy := imh.App.signaturePolicy(name)
+	if policy == nil {
+		return nil
+	}
+
+	signed, err := imh.isSignatureTag(tag, manifest)
+	if err != nil {
+		dcontext.GetLogger(imh).Errorf("error checking signature tag %s:%s: %v", name, tag, err)
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	if signed {
+		return nil
+	}
+
+	err = policy.Verify(imh, imh.Repository, dgst)
+	switch {
+	case err == nil:
+		return nil
+	case err != signature.ErrNotSigned:
+		dcontext.GetLogger(imh).Errorf("error verifying signature of %s@%s: %v", name, dgst, err)
+		if !policy.Enforced() {
+			return nil
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	case !policy.Enforced():
+		dcontext.GetLogger(imh).Warnf("signature policy violation (audit only): %s@%s: %v", name, dgst, err)
+		return nil
+	}
+
+	dcontext.GetLogger(imh).Infof("signature policy violation: %s@%s: %v", name, dgst, err)
+	return errcode.ErrorCodeDenied.WithMessage(err.Error()).WithDetail(map[string]string{
+		"digest": dgst.String(),
+	})
+}
+
+// isSignatureTag returns true if manifest is a signature manifest tagged with
+// the signature tag of a manifest of the repository. Any other manifest,
+// whatever its tag, must be signed itself.
+func (imh *manifestHandler) isSignatureTag(tag string, manifest distribution.Manifest) (bool, error) {
+	subject, ok := signature.SignedDigest(tag)
+	if !ok || !signature.IsSignature(manifest) {
+		return false, nil
+	}
+
+	manifests, err := imh.Repository.Manifests(imh)
+	if err != nil {
+		return false, err
+	}
+	return manifests.Exists(imh, subject)
+}
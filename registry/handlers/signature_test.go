@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/signature"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSignaturePolicy(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"testdriver": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Signatures: configuration.Signatures{
+			Policies: []configuration.SignaturePolicy{
+				{Repositories: []string{"prod/**"}, Keys: []string{keyPath}},
+				{Repositories: []string{"staging/**"}, Mode: "audit", Keys: []string{keyPath}},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	pushBlob := func(name reference.Named, mediaType string, content []byte) distribution.Descriptor {
+		dgst := digest.FromBytes(content)
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(content))
+		return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+	}
+
+	newManifest := func(name reference.Named, layers ...distribution.Descriptor) *ocischema.DeserializedManifest {
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+			Config:    pushBlob(name, v1.MediaTypeImageConfig, []byte("{}")),
+			Layers:    layers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	do := func(method string, name reference.Named, ref string, m *ocischema.DeserializedManifest) *http.Response {
+		var named reference.Named
+		if dgst, err := digest.Parse(ref); err == nil {
+			named, _ = reference.WithDigest(name, dgst)
+		} else {
+			named, _ = reference.WithTag(name, ref)
+		}
+
+		u, err := env.builder.BuildManifestURL(named)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body []byte
+		if m != nil {
+			_, body, _ = m.Payload()
+		}
+
+		req, err := http.NewRequest(method, u, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", v1.MediaTypeImageManifest)
+		req.Header.Set("Accept", v1.MediaTypeImageManifest)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	expectStatus := func(msg string, resp *http.Response, status int) {
+		defer resp.Body.Close()
+		checkResponse(t, msg, resp, status)
+		if status == http.StatusForbidden {
+			checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeDenied)
+		}
+	}
+
+	prod, _ := reference.WithName("prod/app")
+	image := newManifest(prod, pushBlob(prod, v1.MediaTypeImageLayer, []byte("layer")))
+	_, payload, _ := image.Payload()
+	dgst := digest.FromBytes(payload)
+
+	expectStatus("tagging unsigned manifest", do(http.MethodPut, prod, "latest", image), http.StatusForbidden)
+	expectStatus("pushing unsigned manifest by digest", do(http.MethodPut, prod, dgst.String(), image), http.StatusCreated)
+	expectStatus("pulling unsigned manifest", do(http.MethodGet, prod, dgst.String(), nil), http.StatusForbidden)
+	expectStatus("checking unsigned manifest", do(http.MethodHead, prod, dgst.String(), nil), http.StatusOK)
+
+	// Sign the manifest the way cosign does.
+	signed := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"prod/app"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, dgst))
+	sum := sha256.Sum256(signed)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigLayer := pushBlob(prod, "application/vnd.dev.cosign.simplesigning.v1+json", signed)
+	sigLayer.Annotations = map[string]string{signature.SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+
+	sigTag := signature.SignatureTag(dgst)
+	expectStatus("pushing signature", do(http.MethodPut, prod, sigTag, newManifest(prod, sigLayer)), http.StatusCreated)
+	expectStatus("pulling signature", do(http.MethodGet, prod, sigTag, nil), http.StatusOK)
+
+	// Only signatures of existing manifests are exempt, under their signature tag.
+	other := newManifest(prod, pushBlob(prod, v1.MediaTypeImageLayer, []byte("other")))
+	_, payload, _ = other.Payload()
+	otherTag := signature.SignatureTag(digest.FromBytes(payload))
+	expectStatus("tagging unsigned image with signature tag", do(http.MethodPut, prod, otherTag, other), http.StatusForbidden)
+	expectStatus("pushing unsigned image by digest", do(http.MethodPut, prod, digest.FromBytes(payload).String(), other), http.StatusCreated)
+	expectStatus("tagging image with its own signature tag", do(http.MethodPut, prod, otherTag, other), http.StatusForbidden)
+
+	annotated := newManifest(prod, sigLayer, pushBlob(prod, v1.MediaTypeImageLayer, []byte("other")))
+	expectStatus("tagging image annotated as signature", do(http.MethodPut, prod, otherTag, annotated), http.StatusForbidden)
+
+	orphan := signature.SignatureTag(digest.FromString("missing"))
+	expectStatus("pushing signature of missing manifest", do(http.MethodPut, prod, orphan, newManifest(prod, sigLayer)), http.StatusForbidden)
+
+	expectStatus("tagging signed manifest", do(http.MethodPut, prod, "latest", image), http.StatusCreated)
+	expectStatus("pulling signed manifest", do(http.MethodGet, prod, "latest", nil), http.StatusOK)
+
+	// Violations of audit-only policies are allowed.
+	staging, _ := reference.WithName("staging/app")
+	unsigned := newManifest(staging, pushBlob(staging, v1.MediaTypeImageLayer, []byte("layer")))
+	expectStatus("tagging unsigned manifest with audit policy", do(http.MethodPut, staging, "latest", unsigned), http.StatusCreated)
+	expectStatus("pulling unsigned manifest with audit policy", do(http.MethodGet, staging, "latest", nil), http.StatusOK)
+
+	// Repositories without policy are not checked.
+	dev, _ := reference.WithName("dev/app")
+	unsigned = newManifest(dev, pushBlob(dev, v1.MediaTypeImageLayer, []byte("layer")))
+	expectStatus("tagging unsigned manifest without policy", do(http.MethodPut, dev, "latest", unsigned), http.StatusCreated)
+}
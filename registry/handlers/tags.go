@@ -3,7 +3,6 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"sort"
 	"strconv"
 
 	"github.com/distribution/distribution/v3"
@@ -37,8 +36,29 @@ type tagsAPIResponse struct {
 func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	q := r.URL.Query()
+	lastEntry := q.Get("last")
+
+	// without `n`, every tag after `last` is returned
+	maxEntries := -1
+	if n := q.Get("n"); n != "" {
+		parsedMax, err := strconv.Atoi(n)
+		if err != nil || parsedMax < 0 {
+			th.Errors = append(th.Errors, v2.ErrorCodePaginationNumberInvalid.WithDetail(map[string]string{"n": n}))
+			return
+		}
+		maxEntries = parsedMax
+	}
+
+	// request one more tag than asked for, to know whether
+	// there are tags left the user needs.
+	limit := maxEntries
+	if limit >= 0 {
+		limit++
+	}
+
 	tagService := th.Repository.Tags(th)
-	tags, err := tagService.All(th)
+	tags, err := tagService.List(th, limit, lastEntry)
 	if err != nil {
 		switch err := err.(type) {
 		case distribution.ErrRepositoryUnknown:
@@ -51,39 +71,10 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// do pagination if requested
-	q := r.URL.Query()
-	// get entries after latest, if any specified
-	if lastEntry := q.Get("last"); lastEntry != "" {
-		lastEntryIndex := sort.SearchStrings(tags, lastEntry)
-
-		// as`sort.SearchStrings` can return len(tags), if the
-		// specified `lastEntry` is not found, we need to
-		// ensure it does not panic when slicing.
-		if lastEntryIndex == len(tags) {
-			tags = []string{}
-		} else {
-			tags = tags[lastEntryIndex+1:]
-		}
-	}
-
-	// if no error, means that the user requested `n` entries
-	if n := q.Get("n"); n != "" {
-		maxEntries, err := strconv.Atoi(n)
-		if err != nil || maxEntries < 0 {
-			th.Errors = append(th.Errors, v2.ErrorCodePaginationNumberInvalid.WithDetail(map[string]string{"n": n}))
-			return
-		}
+	if maxEntries >= 0 && len(tags) > maxEntries {
+		tags = tags[:maxEntries]
 
-		// if there is requested more than or
-		// equal to the amount of tags we have,
-		// then set the request to equal `len(tags)`.
-		// the reason for the `=`, is so the else
-		// clause will only activate if there
-		// are tags left the user needs.
-		if maxEntries >= len(tags) {
-			maxEntries = len(tags)
-		} else if maxEntries > 0 {
+		if maxEntries > 0 {
 			// defined in `catalog.go`
 			urlStr, err := createLinkEntry(r.URL.String(), maxEntries, tags[maxEntries-1])
 			if err != nil {
@@ -92,8 +83,6 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 			}
 			w.Header().Set("Link", urlStr)
 		}
-
-		tags = tags[:maxEntries]
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,77 @@
+// Package lock provides mutual exclusion between requests updating the same
+// resource, within a registry instance or, with Redis, across instances.
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrTimeout is returned when a lock could not be acquired in time.
+var ErrTimeout = errors.New("lock: timed out acquiring lock")
+
+// Locker acquires locks identified by keys.
+type Locker interface {
+	// Lock blocks until the lock for key is acquired, returning a function
+	// releasing it. It returns ErrTimeout, or the error of ctx, if the lock
+	// cannot be acquired.
+	Lock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// inMemoryLocker holds locks local to the process. Each lock is a channel
+// with a buffer of one, which is full while the lock is held.
+type inMemoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]*inMemoryLock
+}
+
+type inMemoryLock struct {
+	ch      chan struct{}
+	waiters int
+}
+
+// NewInMemoryLocker returns a locker holding locks in memory, which only
+// excludes requests served by this registry instance.
+func NewInMemoryLocker() Locker {
+	return &inMemoryLocker{locks: make(map[string]*inMemoryLock)}
+}
+
+func (l *inMemoryLocker) Lock(ctx context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	lk, ok := l.locks[key]
+	if !ok {
+		lk = &inMemoryLock{ch: make(chan struct{}, 1)}
+		l.locks[key] = lk
+	}
+	lk.waiters++
+	l.mu.Unlock()
+
+	select {
+	case lk.ch <- struct{}{}:
+	case <-ctx.Done():
+		l.release(key, lk, false)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(key, lk, true) })
+	}, nil
+}
+
+// release gives up interest in the lock, unlocking it if it was held, and
+// forgets it once nobody waits for it.
+func (l *inMemoryLocker) release(key string, lk *inMemoryLock, held bool) {
+	if held {
+		<-lk.ch
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lk.waiters--
+	if lk.waiters == 0 {
+		delete(l.locks, key)
+	}
+}
@@ -0,0 +1,90 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryLocker(t *testing.T) {
+	locker := NewInMemoryLocker()
+	ctx := context.Background()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		holders int
+		counter int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			unlock, err := locker.Lock(ctx, "repo:tag")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer unlock()
+
+			mu.Lock()
+			holders++
+			if holders > 1 {
+				t.Error("lock held concurrently")
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+			counter++
+
+			mu.Lock()
+			holders--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if counter != 20 {
+		t.Errorf("unexpected counter: %d", counter)
+	}
+
+	if n := len(locker.(*inMemoryLocker).locks); n != 0 {
+		t.Errorf("expected released locks to be forgotten, %d left", n)
+	}
+}
+
+func TestInMemoryLockerTimeout(t *testing.T) {
+	locker := NewInMemoryLocker()
+
+	unlock, err := locker.Lock(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other keys are independent.
+	unlockOther, err := locker.Lock(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockOther()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := locker.Lock(ctx, "a"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	unlock()
+	// Releasing twice is harmless.
+	unlock()
+
+	unlock, err = locker.Lock(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
@@ -0,0 +1,118 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/gomodule/redigo/redis"
+)
+
+// unlockScript deletes a lock only if it is still held with the given
+// token, so that a lock which expired and was acquired by another instance
+// is not released.
+var unlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+const (
+	// redisLockTTL is how long a lock is held if it is not released, such
+	// as when the instance holding it dies.
+	redisLockTTL = 30 * time.Second
+
+	// redisLockTimeout is how long to wait for a lock before giving up.
+	redisLockTimeout = 10 * time.Second
+
+	// redisLockRetry is how often a held lock is polled.
+	redisLockRetry = 20 * time.Millisecond
+)
+
+// redisLocker holds locks in Redis, shared by all registry instances. Locks
+// are first taken in memory, so that requests to the same instance queue up
+// locally rather than poll Redis.
+type redisLocker struct {
+	pool   *redis.Pool
+	prefix string
+	local  Locker
+}
+
+// NewRedisLocker returns a locker holding locks in Redis, with keys prefixed
+// with prefix.
+func NewRedisLocker(pool *redis.Pool, prefix string) Locker {
+	return &redisLocker{
+		pool:   pool,
+		prefix: prefix,
+		local:  NewInMemoryLocker(),
+	}
+}
+
+func (l *redisLocker) Lock(ctx context.Context, key string) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, redisLockTimeout)
+	defer cancel()
+
+	unlockLocal, err := l.local.Lock(ctx, key)
+	if err != nil {
+		return nil, timeoutError(err)
+	}
+
+	p := make([]byte, 16)
+	if _, err := rand.Read(p); err != nil {
+		unlockLocal()
+		return nil, err
+	}
+	token := hex.EncodeToString(p)
+	redisKey := l.prefix + key
+
+	for {
+		acquired, err := l.tryLock(redisKey, token)
+		if err != nil {
+			unlockLocal()
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-time.After(redisLockRetry):
+		case <-ctx.Done():
+			unlockLocal()
+			return nil, timeoutError(ctx.Err())
+		}
+	}
+
+	return func() {
+		conn := l.pool.Get()
+		defer conn.Close()
+
+		if _, err := unlockScript.Do(conn, redisKey, token); err != nil {
+			// The lock expires on its own.
+			dcontext.GetLogger(ctx).Errorf("lock: error releasing %s: %v", redisKey, err)
+		}
+		unlockLocal()
+	}, nil
+}
+
+// tryLock takes the lock if it is free.
+func (l *redisLocker) tryLock(key, token string) (bool, error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("SET", key, token, "NX", "PX", int64(redisLockTTL/time.Millisecond))
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+func timeoutError(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
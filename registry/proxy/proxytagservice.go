@@ -61,6 +61,17 @@ func (pt proxyTagService) All(ctx context.Context) ([]string, error) {
 	return pt.localTags.All(ctx)
 }
 
+func (pt proxyTagService) List(ctx context.Context, limit int, last string) ([]string, error) {
+	err := pt.authChallenger.tryEstablishChallenges(ctx)
+	if err == nil {
+		tags, err := pt.remoteTags.List(ctx, limit, last)
+		if err == nil {
+			return tags, err
+		}
+	}
+	return pt.localTags.List(ctx, limit, last)
+}
+
 func (pt proxyTagService) Lookup(ctx context.Context, digest distribution.Descriptor) ([]string, error) {
 	return []string{}, distribution.ErrUnsupported
 }
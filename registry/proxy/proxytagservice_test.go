@@ -58,6 +58,24 @@ func (m *mockTagStore) All(ctx context.Context) ([]string, error) {
 	return tags, nil
 }
 
+func (m *mockTagStore) List(ctx context.Context, limit int, last string) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var tags []string
+	for tag := range m.mapping {
+		if tag > last {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	if limit >= 0 && limit < len(tags) {
+		tags = tags[:limit]
+	}
+	return tags, nil
+}
+
 func (m *mockTagStore) Lookup(ctx context.Context, digest distribution.Descriptor) ([]string, error) {
 	panic("not implemented")
 }
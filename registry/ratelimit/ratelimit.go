@@ -0,0 +1,134 @@
+// Package ratelimit provides token bucket rate limiters, keeping their state
+// in memory or in Redis.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate is the rate at which a bucket is refilled and the number of tokens
+// it holds when full.
+type Rate struct {
+	// PerSecond is the number of tokens added to the bucket every second.
+	PerSecond float64
+
+	// Burst is the capacity of the bucket.
+	Burst int64
+}
+
+// Validate checks that the rate can be used by a limiter.
+func (r Rate) Validate() error {
+	if r.PerSecond <= 0 || math.IsInf(r.PerSecond, 0) || math.IsNaN(r.PerSecond) {
+		return errors.New("ratelimit: rate must be positive")
+	}
+	if r.Burst <= 0 {
+		return errors.New("ratelimit: burst must be positive")
+	}
+	return nil
+}
+
+// wait returns the time needed to refill the bucket with tokens.
+func (r Rate) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / r.PerSecond * float64(time.Second)))
+}
+
+// Limiter takes tokens from buckets identified by keys.
+type Limiter interface {
+	// Allow takes n tokens from the bucket for key. If there are not enough
+	// tokens, it returns false along with the time after which the request
+	// may be retried.
+	//
+	// Requests for more tokens than the burst succeed once the bucket is
+	// full, leaving it in debt, so that large requests are slowed down
+	// rather than rejected forever.
+	Allow(ctx context.Context, key string, n int64) (ok bool, retryAfter time.Duration, err error)
+}
+
+// sweepInterval is how often the in-memory limiter forgets about buckets
+// which have refilled.
+const sweepInterval = time.Minute
+
+// bucket is the state of an in-memory token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type inMemoryLimiter struct {
+	rate Rate
+	now  func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewInMemoryLimiter returns a limiter keeping buckets in memory, local to
+// this process.
+func NewInMemoryLimiter(rate Rate) (Limiter, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &inMemoryLimiter{
+		rate:    rate,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}, nil
+}
+
+func (l *inMemoryLimiter) Allow(ctx context.Context, key string, n int64) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	l.refill(b, now)
+
+	need := float64(n)
+	if need > float64(l.rate.Burst) {
+		need = float64(l.rate.Burst)
+	}
+
+	if b.tokens < need {
+		return false, l.rate.wait(need - b.tokens), nil
+	}
+
+	b.tokens -= float64(n)
+	return true, 0, nil
+}
+
+// refill adds the tokens accumulated since the bucket was last used.
+func (l *inMemoryLimiter) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(l.rate.Burst), b.tokens+elapsed.Seconds()*l.rate.PerSecond)
+	}
+	b.last = now
+}
+
+// sweep removes full buckets, which are the same as absent ones, so that
+// clients seen once do not hold memory forever.
+func (l *inMemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.rate.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
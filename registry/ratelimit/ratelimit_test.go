@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInMemoryLimiter(t *testing.T) {
+	limiter, err := NewInMemoryLimiter(Rate{PerSecond: 2, Burst: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	limiter.(*inMemoryLimiter).now = func() time.Time { return now }
+
+	ctx := context.Background()
+
+	allow := func(key string, n int64) (bool, time.Duration) {
+		ok, retryAfter, err := limiter.Allow(ctx, key, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok, retryAfter
+	}
+
+	for i := 0; i < 4; i++ {
+		if ok, _ := allow("alice", 1); !ok {
+			t.Fatalf("request %d: expected burst to be allowed", i)
+		}
+	}
+
+	ok, retryAfter := allow("alice", 1)
+	if ok {
+		t.Fatal("expected request beyond burst to be rejected")
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("unexpected retry after: %v", retryAfter)
+	}
+
+	// Buckets are independent.
+	if ok, _ := allow("bob", 1); !ok {
+		t.Error("expected request for another key to be allowed")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := allow("alice", 2); !ok {
+		t.Error("expected request to be allowed after refill")
+	}
+	if ok, _ := allow("alice", 1); ok {
+		t.Error("expected request to be rejected once refill is spent")
+	}
+
+	// Requests larger than the burst are allowed from a full bucket and
+	// leave it in debt.
+	now = now.Add(time.Minute)
+	if ok, _ := allow("alice", 10); !ok {
+		t.Fatal("expected large request to be allowed from a full bucket")
+	}
+	ok, retryAfter = allow("alice", 1)
+	if ok {
+		t.Fatal("expected request to be rejected while in debt")
+	}
+	if retryAfter != 3500*time.Millisecond {
+		t.Errorf("unexpected retry after in debt: %v", retryAfter)
+	}
+
+	// Full buckets are forgotten.
+	now = now.Add(time.Hour)
+	allow("carol", 1)
+	if n := len(limiter.(*inMemoryLimiter).buckets); n != 1 {
+		t.Errorf("expected only the new bucket to be kept, got %d", n)
+	}
+}
+
+func TestRateValidate(t *testing.T) {
+	for _, rate := range []Rate{{0, 1}, {-1, 1}, {1, 0}} {
+		if _, err := NewInMemoryLimiter(rate); err == nil {
+			t.Errorf("expected error for rate %+v", rate)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// allowScript refills and takes tokens from a bucket atomically. Buckets are
+// hashes holding the tokens and the time of the last refill, in
+// milliseconds, which expire once they would be full again.
+//
+//	KEYS[1] bucket
+//	ARGV    rate per millisecond, burst, now in milliseconds, tokens to take
+//
+// It returns whether the tokens were taken and otherwise the number of
+// milliseconds to wait.
+var allowScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+
+if now > last then
+	tokens = math.min(burst, tokens + (now - last) * rate)
+end
+
+local need = math.min(n, burst)
+if tokens < need then
+	redis.call("HMSET", KEYS[1], "tokens", tokens, "last", now)
+	return {0, math.ceil((need - tokens) / rate)}
+end
+
+tokens = tokens - n
+redis.call("HMSET", KEYS[1], "tokens", tokens, "last", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return {1, 0}
+`)
+
+type redisLimiter struct {
+	pool   *redis.Pool
+	prefix string
+	rate   Rate
+	now    func() time.Time
+}
+
+// NewRedisLimiter returns a limiter keeping buckets in Redis, shared by all
+// registry instances using pool. Bucket keys are prefixed with prefix.
+func NewRedisLimiter(pool *redis.Pool, prefix string, rate Rate) (Limiter, error) {
+	if err := rate.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &redisLimiter{
+		pool:   pool,
+		prefix: prefix,
+		rate:   rate,
+		now:    time.Now,
+	}, nil
+}
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, n int64) (bool, time.Duration, error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Int64s(allowScript.Do(conn,
+		l.prefix+key,
+		strconv.FormatFloat(l.rate.PerSecond/1000, 'g', -1, 64),
+		l.rate.Burst,
+		l.now().UnixNano()/int64(time.Millisecond),
+		n))
+	if err != nil {
+		return false, 0, err
+	}
+
+	if len(reply) != 2 {
+		return false, 0, redis.Error("ratelimit: unexpected script reply")
+	}
+
+	if reply[0] == 1 {
+		return true, 0, nil
+	}
+
+	return false, time.Duration(reply[1]) * time.Millisecond, nil
+}
@@ -28,6 +28,8 @@ import (
 	"github.com/distribution/distribution/v3/configuration"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/health"
+	"github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	"github.com/distribution/distribution/v3/registry/auth/robot"
 	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/listener"
 	"github.com/distribution/distribution/v3/uuid"
@@ -114,6 +116,15 @@ var ServeCmd = &cobra.Command{
 			logrus.Fatalln(err)
 		}
 
+		// reload the htpasswd files in use on SIGHUP
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				htpasswd.Reload()
+			}
+		}()
+
 		if config.HTTP.Debug.Prometheus.Enabled {
 			path := config.HTTP.Debug.Prometheus.Path
 			if path == "" {
@@ -123,6 +134,22 @@ var ServeCmd = &cobra.Command{
 			http.Handle(path, metrics.Handler())
 		}
 
+		if recent := registry.app.RecentEvents(); recent != nil && config.HTTP.Debug.Addr != "" {
+			logrus.Info("providing recent events on /debug/events")
+			http.Handle("/debug/events", recent)
+		}
+
+		if credentials := registry.app.Credentials(); credentials != nil && config.HTTP.Debug.Addr != "" {
+			if token := config.HTTP.Debug.Credentials.Token; token != "" {
+				logrus.Info("providing the credentials admin API on /debug/credentials")
+				admin := robot.AdminHandler("/debug/credentials", credentials, token)
+				http.Handle("/debug/credentials", admin)
+				http.Handle("/debug/credentials/", admin)
+			} else {
+				logrus.Warn("not providing the credentials admin API: http.debug.credentials.token is not set")
+			}
+		}
+
 		if err = registry.ListenAndServe(); err != nil {
 			logrus.Fatalln(err)
 		}
@@ -1,12 +1,19 @@
 package registry
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
 	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/metadata"
 	"github.com/distribution/distribution/v3/version"
 	"github.com/docker/libtrust"
 	"github.com/spf13/cobra"
@@ -19,6 +26,21 @@ func init() {
 	RootCmd.AddCommand(GCCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
+	RootCmd.AddCommand(TagIndexCmd)
+	RootCmd.AddCommand(CatalogIndexCmd)
+	CatalogIndexCmd.Flags().BoolVarP(&checkOnly, "check", "c", false, "report the differences between the index and the storage without rebuilding the index")
+	RootCmd.AddCommand(ImportMetadataCmd)
+	RootCmd.AddCommand(CheckCmd)
+	CheckCmd.Flags().BoolVarP(&repair, "repair", "r", false, "remove the dangling and corrupt links and tags found")
+	RootCmd.AddCommand(MigrateCmd)
+	RootCmd.AddCommand(ExportCmd)
+	RootCmd.AddCommand(ImportCmd)
+	ImportCmd.Flags().BoolVarP(&force, "force", "f", false, "overwrite the tags of the repository which point to other manifests")
+	MigrateCmd.Flags().StringVar(&migrateFrom, "from", "", "configuration of the source registry")
+	MigrateCmd.Flags().StringVar(&migrateTo, "to", "", "configuration of the destination registry")
+	MigrateCmd.Flags().IntVarP(&parallelism, "parallel", "p", 4, "number of files copied concurrently")
+	MigrateCmd.Flags().StringVarP(&checkpoint, "checkpoint", "c", "", "local file recording the blobs verified on the destination, to resume an interrupted migration")
+	MigrateCmd.Flags().BoolVar(&prune, "prune", false, "remove the tags, links and repositories of the destination missing from the source")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -27,6 +49,9 @@ var RootCmd = &cobra.Command{
 	Use:   "registry",
 	Short: "`registry`",
 	Long:  "`registry`",
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		closeMetadataStores()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if showVersion {
 			version.PrintVersion()
@@ -49,32 +74,42 @@ var GCCmd = &cobra.Command{
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
 			cmd.Usage()
-			os.Exit(1)
+			exit(1)
 		}
 
 		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		ctx := dcontext.Background()
 		ctx, err = configureLogging(ctx, config)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		k, err := libtrust.GenerateECP256PrivateKey()
 		if err != nil {
 			fmt.Fprint(os.Stderr, err)
-			os.Exit(1)
+			exit(1)
+		}
+
+		options := []storage.RegistryOption{storage.Schema1SigningKey(k)}
+		metadataStore, err := openMetadataStore(config, dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open metadata store: %v", err)
+			exit(1)
+		}
+		if metadataStore != nil {
+			options = append(options, storage.MetadataStore(metadataStore))
 		}
 
-		registry, err := storage.NewRegistry(ctx, driver, storage.Schema1SigningKey(k))
+		registry, err := storage.NewRegistry(ctx, driver, options...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
-			os.Exit(1)
+			exit(1)
 		}
 
 		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
@@ -83,7 +118,506 @@ var GCCmd = &cobra.Command{
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
-			os.Exit(1)
+			exit(1)
+		}
+	},
+}
+
+// TagIndexCmd is the cobra command that corresponds to the rebuild-tag-index subcommand
+var TagIndexCmd = &cobra.Command{
+	Use:   "rebuild-tag-index <config>",
+	Short: "`rebuild-tag-index` rebuilds the index of tags by manifest digest",
+	Long:  "`rebuild-tag-index` rebuilds the index of tags by manifest digest of every repository, used to find the tags of a manifest without reading every tag",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			exit(1)
+		}
+
+		var options []storage.RegistryOption
+		metadataStore, err := openMetadataStore(config, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open metadata store: %v", err)
+			exit(1)
+		}
+		if metadataStore != nil {
+			options = append(options, storage.MetadataStore(metadataStore))
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, options...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			exit(1)
+		}
+
+		err = storage.RebuildTagReverseIndex(ctx, registry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rebuild tag index: %v", err)
+			exit(1)
+		}
+	},
+}
+
+var checkOnly bool
+
+// CatalogIndexCmd is the cobra command that corresponds to the rebuild-catalog-index subcommand
+var CatalogIndexCmd = &cobra.Command{
+	Use:   "rebuild-catalog-index <config>",
+	Short: "`rebuild-catalog-index` rebuilds the index of repositories",
+	Long:  "`rebuild-catalog-index` rebuilds the index of repositories used to serve the catalog without walking every repository, or checks it against the storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			exit(1)
+		}
+
+		var options []storage.RegistryOption
+		metadataStore, err := openMetadataStore(config, checkOnly)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open metadata store: %v", err)
+			exit(1)
+		}
+		if metadataStore != nil {
+			options = append(options, storage.MetadataStore(metadataStore))
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, options...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			exit(1)
+		}
+
+		if !checkOnly {
+			err = storage.RebuildRepositoryIndex(ctx, registry)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to rebuild catalog index: %v", err)
+				exit(1)
+			}
+			return
+		}
+
+		report, err := storage.CheckRepositoryIndex(ctx, registry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to check catalog index: %v", err)
+			exit(1)
+		}
+
+		if !report.Complete {
+			fmt.Println("index incomplete, the catalog is served by walking the storage")
+		}
+		for _, repo := range report.Missing {
+			fmt.Printf("missing from index: %s\n", repo)
+		}
+		for _, repo := range report.Stale {
+			fmt.Printf("stale index entry: %s\n", repo)
+		}
+		if !report.Consistent() {
+			exit(1)
+		}
+	},
+}
+
+// ImportMetadataCmd is the cobra command that corresponds to the import-metadata subcommand
+var ImportMetadataCmd = &cobra.Command{
+	Use:   "import-metadata <config>",
+	Short: "`import-metadata` builds the metadata store from the storage",
+	Long:  "`import-metadata` copies the tags and links of every repository from the storage into the configured metadata store",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			exit(1)
+		}
+
+		metadataStore, err := openMetadataStore(config, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open metadata store: %v", err)
+			exit(1)
+		}
+		if metadataStore == nil {
+			fmt.Fprintf(os.Stderr, "no metadata store configured")
+			exit(1)
+		}
+
+		err = storage.ImportMetadata(ctx, driver, metadataStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import metadata: %v", err)
+			exit(1)
 		}
 	},
 }
+
+var repair bool
+
+// CheckCmd is the cobra command that corresponds to the check subcommand
+var CheckCmd = &cobra.Command{
+	Use:   "check <config>",
+	Short: "`check` verifies the consistency of the storage",
+	Long:  "`check` re-hashes every blob and verifies the links, tags, manifests and uploads of every repository, printing the findings as a JSON report",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			exit(1)
+		}
+
+		// links written while checking would be removed as dangling
+		if repair && !readOnlyEnabled(config) {
+			fmt.Fprintln(os.Stderr, "--repair requires the storage to be in read-only maintenance mode")
+			exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			exit(1)
+		}
+
+		k, err := libtrust.GenerateECP256PrivateKey()
+		if err != nil {
+			fmt.Fprint(os.Stderr, err)
+			exit(1)
+		}
+
+		// schema1 manifests are read to check the blobs they reference
+		options := []storage.RegistryOption{storage.Schema1SigningKey(k), storage.EnableSchema1}
+		metadataStore, err := openMetadataStore(config, !repair)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open metadata store: %v", err)
+			exit(1)
+		}
+		if metadataStore != nil {
+			options = append(options, storage.MetadataStore(metadataStore))
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, options...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			exit(1)
+		}
+
+		report, err := storage.Check(ctx, driver, registry, storage.CheckOpts{
+			Repair: repair,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to check storage: %v", err)
+			exit(1)
+		}
+
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode report: %v", err)
+			exit(1)
+		}
+		fmt.Println(string(out))
+
+		for _, finding := range report.Findings {
+			if !finding.Repaired {
+				exit(1)
+			}
+		}
+	},
+}
+
+var migrateFrom string
+var migrateTo string
+var parallelism int
+var checkpoint string
+var prune bool
+
+// MigrateCmd is the cobra command that corresponds to the migrate subcommand
+var MigrateCmd = &cobra.Command{
+	Use:   "migrate --from <config> --to <config>",
+	Short: "`migrate` copies the storage of a registry to another",
+	Long:  "`migrate` copies the blobs, repositories, tags and links of the storage of a registry to the storage of another, verifying every blob on the destination. Running it again copies only what changed since the previous run",
+	Run: func(cmd *cobra.Command, args []string) {
+		if migrateFrom == "" || migrateTo == "" {
+			fmt.Fprintln(os.Stderr, "both --from and --to configurations are required")
+			cmd.Usage()
+			exit(1)
+		}
+
+		fromConfig, err := resolveConfiguration([]string{migrateFrom})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			exit(1)
+		}
+		toConfig, err := resolveConfiguration([]string{migrateTo})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, fromConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			exit(1)
+		}
+
+		fromDriver, from, err := openStorage(ctx, fromConfig, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open source storage: %v", err)
+			exit(1)
+		}
+
+		toDriver, to, err := openStorage(ctx, toConfig, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open destination storage: %v", err)
+			exit(1)
+		}
+
+		report, err := storage.Migrate(ctx, fromDriver, from, toDriver, to, storage.MigrateOpts{
+			Parallelism: parallelism,
+			Checkpoint:  checkpoint,
+			Prune:       prune,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate: %v", err)
+			exit(1)
+		}
+
+		fmt.Printf("%d blobs copied, %d blobs already migrated\n", report.BlobsCopied, report.BlobsSkipped)
+		fmt.Printf("%d files copied, %d files already migrated, %d files pruned\n", report.FilesCopied, report.FilesSkipped, report.FilesPruned)
+	},
+}
+
+// ExportCmd is the cobra command that corresponds to the export subcommand
+var ExportCmd = &cobra.Command{
+	Use:   "export <config> <repository[:tag]> <dir|tar>",
+	Short: "`export` writes images of a repository to an OCI image layout",
+	Long:  "`export` writes the image of a tag, or of every tag of the repository without one, to an OCI image layout in a directory, or in a tar archive if the path ends with .tar",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			cmd.Usage()
+			exit(1)
+		}
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			exit(1)
+		}
+
+		ref, err := parseRepositoryReference(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid reference %s: %v\n", args[1], err)
+			exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			exit(1)
+		}
+
+		_, registry, err := openStorage(ctx, config, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open storage: %v", err)
+			exit(1)
+		}
+
+		err = storage.ExportImageLayout(ctx, registry, ref, args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to export %s: %v", args[1], err)
+			exit(1)
+		}
+	},
+}
+
+var force bool
+
+// ImportCmd is the cobra command that corresponds to the import subcommand
+var ImportCmd = &cobra.Command{
+	Use:   "import <config> <dir|tar> <repository[:tag]>",
+	Short: "`import` ingests the images of an OCI image layout into a repository",
+	Long:  "`import` ingests the images of an OCI image layout, in a directory or a tar archive, into a repository with the tags recorded in the layout, or only the image of the given tag",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			cmd.Usage()
+			exit(1)
+		}
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			exit(1)
+		}
+
+		ref, err := parseRepositoryReference(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid reference %s: %v\n", args[2], err)
+			exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			exit(1)
+		}
+
+		_, registry, err := openStorage(ctx, config, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open storage: %v", err)
+			exit(1)
+		}
+
+		err = storage.ImportImageLayout(ctx, registry, args[1], ref, storage.ImportOpts{Force: force})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import %s: %v", args[1], err)
+			exit(1)
+		}
+	},
+}
+
+// parseRepositoryReference parses a repository name as stored by the
+// registry, with an optional tag or digest.
+func parseRepositoryReference(s string) (reference.Named, error) {
+	ref, err := reference.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	named, ok := ref.(reference.Named)
+	if !ok {
+		return nil, fmt.Errorf("repository name missing")
+	}
+	return named, nil
+}
+
+// openStorage constructs the storage driver of the configuration and a
+// registry on top of it and its metadata store, if there is one.
+func openStorage(ctx context.Context, config *configuration.Configuration, readOnly bool) (storagedriver.StorageDriver, distribution.Namespace, error) {
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to construct %s driver: %v", config.Storage.Type(), err)
+	}
+
+	var options []storage.RegistryOption
+	metadataStore, err := openMetadataStore(config, readOnly)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open metadata store: %v", err)
+	}
+	if metadataStore != nil {
+		options = append(options, storage.MetadataStore(metadataStore))
+	}
+
+	registry, err := storage.NewRegistry(ctx, driver, options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to construct registry: %v", err)
+	}
+
+	return driver, registry, nil
+}
+
+// openMetadataStores are the metadata stores opened by the running command.
+var openMetadataStores []metadata.Store
+
+// openMetadataStore opens the metadata store of the storage configuration,
+// returning nil if there is none. Commands which only read the storage open
+// it read-only, so that they may run alongside each other. The store is
+// closed once the command completes or exits.
+func openMetadataStore(config *configuration.Configuration, readOnly bool) (metadata.Store, error) {
+	parameters, ok := config.Storage["metadata"]
+	if !ok {
+		return nil, nil
+	}
+
+	create := metadata.Create
+	if readOnly {
+		create = metadata.CreateReadOnly
+	}
+
+	store, err := create(parameters)
+	if err != nil {
+		return nil, err
+	}
+	openMetadataStores = append(openMetadataStores, store)
+	return store, nil
+}
+
+// readOnlyEnabled returns true if the configuration puts the storage in
+// read-only maintenance mode.
+func readOnlyEnabled(config *configuration.Configuration) bool {
+	readOnly, ok := config.Storage["maintenance"]["readonly"].(map[interface{}]interface{})
+	if !ok {
+		return false
+	}
+	enabled, _ := readOnly["enabled"].(bool)
+	return enabled
+}
+
+// closeMetadataStores closes the metadata stores opened by the command.
+func closeMetadataStores() {
+	for _, store := range openMetadataStores {
+		if err := store.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close metadata store: %v\n", err)
+		}
+	}
+	openMetadataStores = nil
+}
+
+// exit closes the metadata stores before exiting with code, as deferred
+// calls do not run on os.Exit.
+func exit(code int) {
+	closeMetadataStores()
+	os.Exit(code)
+}
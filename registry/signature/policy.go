@@ -0,0 +1,69 @@
+package signature
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// Modes of policies.
+const (
+	// ModeEnforce rejects manifests which are not signed.
+	ModeEnforce = "enforce"
+
+	// ModeAudit logs manifests which are not signed without rejecting
+	// them.
+	ModeAudit = "audit"
+)
+
+// Policy requires the manifests of a set of repositories to be signed.
+type Policy struct {
+	*Verifier
+
+	mode         string
+	repositories *auth.RepositoryMatcher
+}
+
+// NewPolicy returns a policy requiring manifests in the repositories
+// matching the glob patterns to be signed by one of keys.
+func NewPolicy(repositories []string, mode string, keys ...crypto.PublicKey) (*Policy, error) {
+	switch mode {
+	case "":
+		mode = ModeEnforce
+	case ModeEnforce, ModeAudit:
+	default:
+		return nil, fmt.Errorf("unknown signature policy mode %q", mode)
+	}
+
+	if len(repositories) == 0 {
+		return nil, errors.New("signature policy must list repositories")
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("signature policy must list keys")
+	}
+
+	matcher, err := auth.NewRepositoryMatcher(repositories...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Policy{
+		Verifier:     NewVerifier(keys...),
+		mode:         mode,
+		repositories: matcher,
+	}, nil
+}
+
+// Matches returns true if the policy applies to the repository.
+func (p *Policy) Matches(repository string) bool {
+	return p.repositories.Match(repository)
+}
+
+// Enforced returns true if unsigned manifests are rejected, rather than
+// only logged.
+func (p *Policy) Enforced() bool {
+	return p.mode == ModeEnforce
+}
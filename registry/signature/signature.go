@@ -0,0 +1,276 @@
+// Package signature verifies cosign-style signatures of manifests.
+//
+// A signature of a manifest is stored in the same repository as a signature
+// manifest tagged with the digest of the signed manifest, such as
+// "sha256-<hex>.sig". Each layer of the signature manifest is a detached
+// signature: a simple signing payload naming the signed digest, with the
+// base64 encoded signature of the payload in an annotation of the layer.
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// SignatureAnnotation holds the base64 encoded signature of a layer of
+	// a signature manifest.
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	// simpleSigningType is the type of simple signing payloads of cosign
+	// signatures.
+	simpleSigningType = "cosign container image signature"
+
+	// maxPayloadSize bounds the size of signed payloads read from storage.
+	maxPayloadSize = 1 << 20
+)
+
+// ErrNotSigned is returned when a manifest has no signature verified by a
+// trusted key.
+var ErrNotSigned = errors.New("manifest is not signed by a trusted key")
+
+// signatureTagRegexp matches the tags of signature manifests.
+var signatureTagRegexp = regexp.MustCompile(`^([a-z0-9]+)-([a-f0-9]+)\.sig$`)
+
+// SignatureTag returns the tag of the signature manifest of dgst.
+func SignatureTag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sig", dgst.Algorithm(), dgst.Encoded())
+}
+
+// SignedDigest returns the digest of the manifest signed by the signature
+// manifest tagged tag, if tag is the tag of a signature manifest.
+func SignedDigest(tag string) (digest.Digest, bool) {
+	match := signatureTagRegexp.FindStringSubmatch(tag)
+	if match == nil {
+		return "", false
+	}
+
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(match[1]), match[2])
+	if dgst.Validate() != nil {
+		return "", false
+	}
+	return dgst, true
+}
+
+// IsSignature returns true if the manifest is a signature manifest: an image
+// manifest each layer of which is a signature.
+func IsSignature(manifest distribution.Manifest) bool {
+	var layers []distribution.Descriptor
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		layers = m.Layers
+	case *schema2.DeserializedManifest:
+		layers = m.Layers
+	}
+	if len(layers) == 0 {
+		return false
+	}
+
+	for _, ref := range layers {
+		if _, ok := ref.Annotations[SignatureAnnotation]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// simpleSigning is the signed payload of a signature.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// LoadPublicKey reads a PEM encoded public key from path. ECDSA, RSA and
+// Ed25519 keys are supported.
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	p, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(p)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM encoded key found", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, key)
+	}
+}
+
+// verified is how long a verified signature is remembered.
+const verifiedTTL = time.Minute
+
+// Verifier verifies signatures of manifests with a set of trusted keys.
+type Verifier struct {
+	keys []crypto.PublicKey
+
+	mu       sync.Mutex
+	verified map[string]time.Time
+}
+
+// NewVerifier returns a verifier trusting keys.
+func NewVerifier(keys ...crypto.PublicKey) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		verified: make(map[string]time.Time),
+	}
+}
+
+// Verify checks that the manifest dgst in repo has a signature verified by
+// one of the trusted keys, returning ErrNotSigned if it has not. Other
+// errors are returned when the signature cannot be read.
+func (v *Verifier) Verify(ctx context.Context, repo distribution.Repository, dgst digest.Digest) error {
+	cacheKey := repo.Named().Name() + "@" + dgst.String()
+	if v.cached(cacheKey) {
+		return nil
+	}
+
+	desc, err := repo.Tags(ctx).Get(ctx, SignatureTag(dgst))
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return ErrNotSigned
+		}
+		return err
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := manifests.Get(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	blobs := repo.Blobs(ctx)
+	for _, layer := range manifest.References() {
+		encoded, ok := layer.Annotations[SignatureAnnotation]
+		if !ok {
+			continue
+		}
+
+		if layer.Size > maxPayloadSize {
+			dcontext.GetLogger(ctx).Warnf("signature: skipping oversized payload %s of %s", layer.Digest, dgst)
+			continue
+		}
+
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+
+		payload, err := blobs.Get(ctx, layer.Digest)
+		if err != nil {
+			if err == distribution.ErrBlobUnknown {
+				continue
+			}
+			return err
+		}
+
+		if v.verifyPayload(payload, sig, dgst) {
+			v.remember(cacheKey)
+			return nil
+		}
+	}
+
+	return ErrNotSigned
+}
+
+// verifyPayload returns true if sig is a signature of payload by a trusted
+// key and payload is a simple signing payload of dgst.
+func (v *Verifier) verifyPayload(payload, sig []byte, dgst digest.Digest) bool {
+	trusted := false
+	for _, key := range v.keys {
+		if verifySignature(key, payload, sig) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return false
+	}
+
+	var ss simpleSigning
+	if err := json.Unmarshal(payload, &ss); err != nil {
+		return false
+	}
+
+	return ss.Critical.Type == simpleSigningType && ss.Critical.Image.DockerManifestDigest == dgst
+}
+
+func (v *Verifier) cached(key string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	t, ok := v.verified[key]
+	if ok && time.Since(t) > verifiedTTL {
+		delete(v.verified, key)
+		return false
+	}
+	return ok
+}
+
+func (v *Verifier) remember(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	for k, t := range v.verified {
+		if now.Sub(t) > verifiedTTL {
+			delete(v.verified, k)
+		}
+	}
+	v.verified[key] = now
+}
+
+// verifySignature verifies sig over payload with key, the way cosign signs:
+// ECDSA and RSA PKCS #1 v1.5 signatures of the SHA-256 digest, and Ed25519
+// signatures of the payload itself.
+func verifySignature(key crypto.PublicKey, payload, sig []byte) bool {
+	sum := sha256.Sum256(payload)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, sum[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+
+	return false
+}
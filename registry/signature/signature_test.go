@@ -0,0 +1,233 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type testRepository struct {
+	t    *testing.T
+	ctx  context.Context
+	repo distribution.Repository
+}
+
+func newTestRepository(t *testing.T, name string) *testRepository {
+	ctx := context.Background()
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testRepository{t: t, ctx: ctx, repo: repo}
+}
+
+// putManifest stores an OCI manifest with the given layers, returning its
+// digest.
+func (tr *testRepository) putManifest(layers ...distribution.Descriptor) digest.Digest {
+	config, err := tr.repo.Blobs(tr.ctx).Put(tr.ctx, v1.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+		Config:    config,
+		Layers:    layers,
+	})
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+
+	manifests, err := tr.repo.Manifests(tr.ctx)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+
+	dgst, err := manifests.Put(tr.ctx, m)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+
+	return dgst
+}
+
+// putImage stores an image manifest with a random layer.
+func (tr *testRepository) putImage() digest.Digest {
+	content := make([]byte, 32)
+	rand.Read(content)
+
+	layer, err := tr.repo.Blobs(tr.ctx).Put(tr.ctx, v1.MediaTypeImageLayer, content)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+
+	return tr.putManifest(layer)
+}
+
+// sign stores a signature of the manifest target by key, signing a payload
+// naming signed.
+func (tr *testRepository) sign(key *ecdsa.PrivateKey, target, signed digest.Digest) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, tr.repo.Named().Name(), signed))
+
+	sum := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+
+	layer, err := tr.repo.Blobs(tr.ctx).Put(tr.ctx, "application/vnd.dev.cosign.simplesigning.v1+json", payload)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	layer.Annotations = map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+
+	dgst := tr.putManifest(layer)
+
+	if err := tr.repo.Tags(tr.ctx).Tag(tr.ctx, SignatureTag(target), distribution.Descriptor{Digest: dgst}); err != nil {
+		tr.t.Fatal(err)
+	}
+}
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerify(t *testing.T) {
+	tr := newTestRepository(t, "prod/app")
+
+	trusted := generateKey(t)
+	untrusted := generateKey(t)
+
+	verifier := NewVerifier(trusted.Public())
+
+	signed := tr.putImage()
+	tr.sign(trusted, signed, signed)
+
+	unsigned := tr.putImage()
+
+	signedByOther := tr.putImage()
+	tr.sign(untrusted, signedByOther, signedByOther)
+
+	// A signature of another manifest copied to the tag of this one.
+	mismatched := tr.putImage()
+	tr.sign(trusted, mismatched, signed)
+
+	if err := verifier.Verify(tr.ctx, tr.repo, signed); err != nil {
+		t.Errorf("expected signed manifest to be verified, got %v", err)
+	}
+
+	for name, dgst := range map[string]digest.Digest{
+		"unsigned":        unsigned,
+		"signed by other": signedByOther,
+		"mismatched":      mismatched,
+	} {
+		if err := verifier.Verify(tr.ctx, tr.repo, dgst); err != ErrNotSigned {
+			t.Errorf("%s: expected ErrNotSigned, got %v", name, err)
+		}
+	}
+
+	manifests, err := tr.repo.Manifests(tr.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := tr.repo.Tags(tr.ctx).Get(tr.ctx, SignatureTag(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigManifest, err := manifests.Get(tr.ctx, desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSignature(sigManifest) {
+		t.Error("expected signature manifest to be recognized")
+	}
+	signedManifest, err := manifests.Get(tr.ctx, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsSignature(signedManifest) {
+		t.Error("expected signed manifest not to be recognized as a signature")
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	key := generateKey(t)
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadPublicKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+		t.Error("loaded key does not match")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not a key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPublicKey(path); err == nil {
+		t.Error("expected error loading invalid key")
+	}
+}
+
+func TestSignedDigest(t *testing.T) {
+	dgst := digest.FromString("manifest")
+
+	for tag, expected := range map[string]digest.Digest{
+		SignatureTag(dgst):                  dgst,
+		"sha256-" + dgst.Encoded() + ".att": "",
+		"sha256-abc.sig":                    "",
+		"latest":                            "",
+		"v1.2.3":                            "",
+		"sha256-" + dgst.Encoded():          "",
+	} {
+		signed, ok := SignedDigest(tag)
+		if signed != expected || ok != (expected != "") {
+			t.Errorf("%q: expected signed digest %q, got %q", tag, expected, signed)
+		}
+	}
+}
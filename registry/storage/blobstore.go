@@ -17,6 +17,10 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+
+	// metadata holds the links and tags of repositories in place of driver,
+	// if set.
+	metadata driver.StorageDriver
 }
 
 var _ distribution.BlobProvider = &blobStore{}
@@ -134,12 +138,21 @@ func (bs *blobStore) path(dgst digest.Digest) (string, error) {
 func (bs *blobStore) link(ctx context.Context, path string, dgst digest.Digest) error {
 	// The contents of the "link" file are the exact string contents of the
 	// digest, which is specified in that package.
-	return bs.driver.PutContent(ctx, path, []byte(dgst))
+	return bs.linkDriver().PutContent(ctx, path, []byte(dgst))
+}
+
+// linkDriver returns the driver holding the links and tags of repositories,
+// which is the metadata store of the registry, if any.
+func (bs *blobStore) linkDriver() driver.StorageDriver {
+	if bs.metadata != nil {
+		return bs.metadata
+	}
+	return bs.driver
 }
 
 // readlink returns the linked digest at path.
 func (bs *blobStore) readlink(ctx context.Context, path string) (digest.Digest, error) {
-	content, err := bs.driver.GetContent(ctx, path)
+	content, err := bs.linkDriver().GetContent(ctx, path)
 	if err != nil {
 		return "", err
 	}
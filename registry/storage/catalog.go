@@ -7,54 +7,70 @@ import (
 	"path"
 	"strings"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
 )
 
 // Returns a list, or partial list, of repositories in the registry.
-// Because it's a quite expensive operation, it should only be used when building up
-// an initial set of repositories.
+// Because it's a quite expensive operation without a complete repository
+// index, it should only be used when building up an initial set of
+// repositories.
 func (reg *registry) Repositories(ctx context.Context, repos []string, last string) (n int, err error) {
-	var finishedWalk bool
-	var foundRepos []string
-
 	if len(repos) == 0 {
 		return 0, errors.New("no space in slice")
 	}
 
-	root, err := pathFor(repositoriesRootPathSpec{})
+	complete, err := reg.repositoryIndexComplete(ctx)
 	if err != nil {
 		return 0, err
 	}
 
-	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
-		err := handleRepository(fileInfo, root, last, func(repoPath string) error {
-			foundRepos = append(foundRepos, repoPath)
-			return nil
-		})
+	if complete {
+		root, err := pathFor(repositoryIndexPathSpec{})
 		if err != nil {
-			return err
+			return 0, err
 		}
+		return walkRepositories(ctx, reg.blobStore.linkDriver(), root, repos, last, handleIndexedRepository)
+	}
+
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return 0, err
+	}
+	return walkRepositories(ctx, reg.blobStore.linkDriver(), root, repos, last, handleRepository)
+}
+
+// walkRepositories fills repos with the repositories found by handler while
+// walking root, returning io.EOF if there are no more repositories.
+func walkRepositories(ctx context.Context, d driver.StorageDriver, root string, repos []string, last string, handler func(driver.FileInfo, string, string, func(string) error) error) (n int, err error) {
+	var finishedWalk bool
+	var foundRepos []string
 
+	err = d.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
 		// if we've filled our array, no need to walk any further
 		if len(foundRepos) == len(repos) {
 			finishedWalk = true
-			return driver.ErrSkipDir
+			return errFinishedWalk
 		}
 
-		return nil
+		return handler(fileInfo, root, last, func(repoPath string) error {
+			foundRepos = append(foundRepos, repoPath)
+			return nil
+		})
 	})
 
 	n = copy(repos, foundRepos)
 
-	if err != nil {
+	if err != nil && !errors.Is(err, errFinishedWalk) {
 		return n, err
 	} else if !finishedWalk {
 		// We didn't fill buffer. No more records are available.
 		return n, io.EOF
 	}
 
-	return n, err
+	return n, nil
 }
 
 // Enumerate applies ingester to each repository
@@ -64,21 +80,154 @@ func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error)
 		return err
 	}
 
-	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+	err = reg.blobStore.linkDriver().Walk(ctx, root, func(fileInfo driver.FileInfo) error {
 		return handleRepository(fileInfo, root, "", ingester)
 	})
 
 	return err
 }
 
-// Remove removes a repository from storage
+// Remove removes the tags, manifests, layer links and uploads of a
+// repository from storage. Repositories nested under its name are kept.
 func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 	root, err := pathFor(repositoriesRootPathSpec{})
 	if err != nil {
 		return err
 	}
 	repoDir := path.Join(root, name.Name())
-	return reg.driver.Delete(ctx, repoDir)
+
+	// the uploads of the repository, if any, are left in the driver when
+	// the links are kept in a metadata store
+	drivers := []driver.StorageDriver{reg.driver}
+	if reg.blobStore.metadata != nil {
+		drivers = append(drivers, reg.blobStore.metadata)
+	}
+
+	found := false
+	for _, d := range drivers {
+		removed, err := removeRepositoryFiles(ctx, d, repoDir)
+		if err != nil {
+			return err
+		}
+		found = found || removed
+	}
+	if !found {
+		return driver.PathNotFoundError{Path: repoDir}
+	}
+
+	return removeRepositoryIndexEntry(ctx, reg.blobStore.linkDriver(), name.Name())
+}
+
+// removeRepositoryFiles deletes the directories of the repository at
+// repoDir, whose names start with an underscore unlike those of nested
+// repositories, returning whether there were any.
+func removeRepositoryFiles(ctx context.Context, d driver.StorageDriver, repoDir string) (bool, error) {
+	children, err := d.List(ctx, repoDir)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	removed := false
+	for _, child := range children {
+		if !strings.HasPrefix(path.Base(child), "_") {
+			continue
+		}
+		if err := d.Delete(ctx, child); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return removed, err
+		}
+		removed = true
+	}
+	return removed, nil
+}
+
+// Describe summarizes the tags, manifests and layers of a repository. The
+// last push is the latest modification time of its tag and manifest links.
+func (reg *registry) Describe(ctx context.Context, name reference.Named) (distribution.RepositoryDescription, error) {
+	description := distribution.RepositoryDescription{Name: name.Name()}
+	blobs := make(map[digest.Digest]struct{})
+
+	tagsPath, err := pathFor(manifestTagsPathSpec{name: name.Name()})
+	if err != nil {
+		return description, err
+	}
+	revisionsPath, err := pathFor(manifestRevisionsPathSpec{name: name.Name()})
+	if err != nil {
+		return description, err
+	}
+	layersPath, err := pathFor(layersPathSpec{name: name.Name()})
+	if err != nil {
+		return description, err
+	}
+
+	found := false
+	for _, root := range []string{tagsPath, revisionsPath, layersPath} {
+		err := reg.blobStore.linkDriver().Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+			// tags are <tag>/current/link and <tag>/index/..., links are
+			// <algorithm>/<hex digest>/link
+			parts := strings.Split(strings.TrimPrefix(fileInfo.Path(), root+"/"), "/")
+			if fileInfo.IsDir() {
+				if root == tagsPath && len(parts) == 2 && parts[1] == "index" {
+					return driver.ErrSkipDir
+				}
+				return nil
+			}
+			if len(parts) != 3 || parts[2] != "link" {
+				return nil
+			}
+
+			if root == tagsPath {
+				if parts[1] != "current" {
+					return nil
+				}
+				description.TagCount++
+			} else {
+				dgst := digest.NewDigestFromHex(parts[0], parts[1])
+				if dgst.Validate() != nil {
+					return nil
+				}
+				blobs[dgst] = struct{}{}
+				if root == layersPath {
+					return nil
+				}
+			}
+
+			if fileInfo.ModTime().After(description.LastPush) {
+				description.LastPush = fileInfo.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return description, err
+		}
+		found = true
+	}
+
+	if !found {
+		return description, distribution.ErrRepositoryUnknown{Name: name.Name()}
+	}
+
+	for dgst := range blobs {
+		desc, err := reg.statter.Stat(ctx, dgst)
+		if err != nil {
+			if err == distribution.ErrBlobUnknown {
+				// dangling links do not reference any content
+				continue
+			}
+			return description, err
+		}
+		description.Size += desc.Size
+	}
+
+	return description, nil
 }
 
 // lessPath returns true if one path a is less than path b.
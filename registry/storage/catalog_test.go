@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"path"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
@@ -243,6 +244,145 @@ func TestCatalogWalkError(t *testing.T) {
 	}
 }
 
+func TestRemoveKeepsNestedRepositories(t *testing.T) {
+	env := setupFS(t)
+	makeRepo(env.ctx, t, "foo", env.registry)
+
+	named, err := reference.WithName("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remover := env.registry.(distribution.RepositoryRemover)
+	if err := remover.Remove(env.ctx, named); err != nil {
+		t.Fatal(err)
+	}
+	if err := remover.Remove(env.ctx, named); err == nil {
+		t.Fatal("expected an error removing a removed repository")
+	}
+
+	p := make([]string, 50)
+	n, _ := env.registry.Repositories(env.ctx, p, "")
+	if n != len(env.expected) || !testEq(p, env.expected, n) {
+		t.Errorf("unexpected catalog after removing a parent repository: expected %v, got %v", env.expected, p[:n])
+	}
+
+	named, err = reference.WithName("foo/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := env.registry.Repository(env.ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Tags(env.ctx).All(env.ctx); err == nil {
+		t.Fatal("expected no tags in the nested repository")
+	}
+	described, err := env.registry.(distribution.RepositoryDescriber).Describe(env.ctx, named)
+	if err != nil {
+		t.Fatalf("failed to describe the nested repository: %v", err)
+	}
+	if described.Size == 0 {
+		t.Errorf("expected the links of the nested repository to be kept")
+	}
+}
+
+func TestCatalogIndex(t *testing.T) {
+	env := setupFS(t)
+
+	indexRoot, err := pathFor(repositoryIndexPathSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first repository of the registry starts the index
+	report, err := CheckRepositoryIndex(env.ctx, env.registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Complete || !report.Consistent() {
+		t.Fatalf("unexpected report of a new registry: %+v", report)
+	}
+
+	// the catalog must be served from the index alone
+	repositoriesRoot, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.driver.Move(env.ctx, repositoriesRoot, repositoriesRoot+"-moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]string, 50)
+	n, err := env.registry.Repositories(env.ctx, p, "")
+	if err != io.EOF {
+		t.Errorf("unexpected error listing the catalog: %v", err)
+	}
+	if n != len(env.expected) || !testEq(p, env.expected, n) {
+		t.Errorf("unexpected catalog from the index: expected %v, got %v", env.expected, p[:n])
+	}
+
+	if err := env.driver.Move(env.ctx, repositoriesRoot+"-moved", repositoriesRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	named, err := reference.WithName("foo/d/in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.registry.(distribution.RepositoryRemover).Remove(env.ctx, named); err != nil {
+		t.Fatal(err)
+	}
+	n, _ = env.registry.Repositories(env.ctx, p, "foo/b")
+	if n == 0 || p[0] != "foo-bar/a" {
+		t.Errorf("expected removed repository to be removed from the index, got %v", p[:n])
+	}
+	makeRepo(env.ctx, t, "foo/d/in", env.registry)
+
+	// registries created before the index walk the storage until rebuilt
+	if err := env.driver.Delete(env.ctx, indexRoot); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.driver.PutContent(env.ctx, path.Join(indexRoot, "stale", "_repository"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	makeRepo(env.ctx, t, "new", env.registry)
+	expected := append([]string{}, env.expected...)
+	expected = append(expected[:8], append([]string{"new"}, expected[8:]...)...)
+
+	n, _ = env.registry.Repositories(env.ctx, p, "")
+	if n != len(expected) || !testEq(p, expected, n) {
+		t.Errorf("unexpected catalog from storage: expected %v, got %v", expected, p[:n])
+	}
+
+	report, err = CheckRepositoryIndex(env.ctx, env.registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Complete {
+		t.Errorf("expected index to be incomplete")
+	}
+	if len(report.Missing) != len(env.expected) || len(report.Stale) != 1 || report.Stale[0] != "stale" {
+		t.Errorf("unexpected report of an incomplete index: %+v", report)
+	}
+
+	if err := RebuildRepositoryIndex(env.ctx, env.registry); err != nil {
+		t.Fatal(err)
+	}
+	report, err = CheckRepositoryIndex(env.ctx, env.registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Complete || !report.Consistent() {
+		t.Errorf("unexpected report of a rebuilt index: %+v", report)
+	}
+
+	chunk := make([]string, 4)
+	n, err = env.registry.Repositories(env.ctx, chunk, "foo/b")
+	if err != nil || !testEq(chunk, expected[5:9], n) {
+		t.Errorf("unexpected catalog chunk from the rebuilt index: expected %v, got %v (%v)", expected[5:9], chunk[:n], err)
+	}
+}
+
 func BenchmarkPathCompareEqual(B *testing.B) {
 	B.StopTimer()
 	pp := randomPath(100)
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// The repository index mirrors the names of the repositories of the registry
+// with one small file per repository, so that the catalog is served without
+// walking the tags, links and uploads of every repository. Entries are added
+// before the first manifest or tag of a repository is written and removed
+// after the repository is deleted, so the index may hold stale entries but
+// never misses a repository.
+//
+// Registries created before the index was introduced hold repositories
+// without entries. The index is only used once it is marked complete, either
+// because it was started along with the first repository of the registry or
+// because it was rebuilt with RebuildRepositoryIndex.
+
+// RepositoryIndexReport describes the differences between the repository
+// index and the repositories found by walking the storage.
+type RepositoryIndexReport struct {
+	// Complete is true if the index is used to serve the catalog.
+	Complete bool
+
+	// Missing lists the repositories without an entry in the index.
+	Missing []string
+
+	// Stale lists the entries of the index without a repository.
+	Stale []string
+}
+
+// Consistent returns whether the index holds exactly the repositories found
+// in storage.
+func (r RepositoryIndexReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0
+}
+
+// RebuildRepositoryIndex adds an entry for every repository of the registry
+// to the repository index, removes the entries without a repository and
+// marks the index complete. Repositories created while the index is being
+// rebuilt may be missing from it, so the registry should not accept pushes
+// while this runs.
+func RebuildRepositoryIndex(ctx context.Context, registry distribution.Namespace) error {
+	d := linkDriverOf(registry)
+	if d == nil {
+		return fmt.Errorf("unable to convert Namespace to a storage registry")
+	}
+
+	completePath, err := pathFor(repositoryIndexCompletePathSpec{})
+	if err != nil {
+		return err
+	}
+
+	// the catalog is walked from storage until the index is complete again
+	if err := d.Delete(ctx, completePath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	repositories, err := enumerateRepositories(ctx, registry, func(repoName string) error {
+		emit(repoName)
+		return putRepositoryIndexEntry(ctx, d, repoName)
+	})
+	if err != nil {
+		return err
+	}
+
+	indexed, err := indexedRepositories(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	for _, repoName := range indexed {
+		if _, ok := repositories[repoName]; ok {
+			continue
+		}
+		if err := removeRepositoryIndexEntry(ctx, d, repoName); err != nil {
+			return err
+		}
+	}
+
+	return d.PutContent(ctx, completePath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// CheckRepositoryIndex compares the repository index of the registry with the
+// repositories found by walking the storage.
+func CheckRepositoryIndex(ctx context.Context, registry distribution.Namespace) (RepositoryIndexReport, error) {
+	var report RepositoryIndexReport
+
+	d := linkDriverOf(registry)
+	if d == nil {
+		return report, fmt.Errorf("unable to convert Namespace to a storage registry")
+	}
+
+	complete, err := repositoryIndexComplete(ctx, d)
+	if err != nil {
+		return report, err
+	}
+	report.Complete = complete
+
+	repositories, err := enumerateRepositories(ctx, registry, func(string) error { return nil })
+	if err != nil {
+		return report, err
+	}
+
+	indexed, err := indexedRepositories(ctx, d)
+	if err != nil {
+		return report, err
+	}
+
+	for _, repoName := range indexed {
+		if _, ok := repositories[repoName]; ok {
+			delete(repositories, repoName)
+			continue
+		}
+		report.Stale = append(report.Stale, repoName)
+	}
+
+	for repoName := range repositories {
+		report.Missing = append(report.Missing, repoName)
+	}
+	sort.Strings(report.Missing)
+
+	return report, nil
+}
+
+// enumerateRepositories returns the set of repositories found by walking the
+// storage, calling fn on each of them.
+func enumerateRepositories(ctx context.Context, registry distribution.Namespace, fn func(string) error) (map[string]struct{}, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	repositories := make(map[string]struct{})
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		repositories[repoName] = struct{}{}
+		return fn(repoName)
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return repositories, nil
+}
+
+// indexedRepositories returns the names of the repositories in the index.
+func indexedRepositories(ctx context.Context, d storagedriver.StorageDriver) ([]string, error) {
+	root, err := pathFor(repositoryIndexPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories []string
+	err = d.Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		return handleIndexedRepository(fileInfo, root, "", func(repoName string) error {
+			repositories = append(repositories, repoName)
+			return nil
+		})
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+
+	sort.Strings(repositories)
+	return repositories, nil
+}
+
+// indexRepository adds the repository to the repository index, if missing.
+// The index is marked complete if the repository is the first of the
+// registry.
+func (repo *repository) indexRepository(ctx context.Context) error {
+	d := repo.blobStore.linkDriver()
+
+	entryPath, err := pathFor(repositoryIndexEntryPathSpec{name: repo.name.Name()})
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.Stat(ctx, entryPath); err == nil {
+		return nil
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return err
+	}
+
+	if err := startRepositoryIndex(ctx, d); err != nil {
+		return err
+	}
+
+	return putRepositoryIndexEntry(ctx, d, repo.name.Name())
+}
+
+// startRepositoryIndex marks the repository index of a registry without any
+// repository as complete, since all of its repositories will be added to the
+// index.
+func startRepositoryIndex(ctx context.Context, d storagedriver.StorageDriver) error {
+	complete, err := repositoryIndexComplete(ctx, d)
+	if err != nil || complete {
+		return err
+	}
+
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	n, err := walkRepositories(ctx, d, root, make([]string, 1), "", handleRepository)
+	if err != nil && err != io.EOF {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	if n > 0 {
+		return nil
+	}
+
+	completePath, err := pathFor(repositoryIndexCompletePathSpec{})
+	if err != nil {
+		return err
+	}
+
+	return d.PutContent(ctx, completePath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// repositoryIndexComplete returns whether the repository index holds all the
+// repositories of the registry.
+func repositoryIndexComplete(ctx context.Context, d storagedriver.StorageDriver) (bool, error) {
+	completePath, err := pathFor(repositoryIndexCompletePathSpec{})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := d.Stat(ctx, completePath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// repositoryIndexComplete returns whether the repository index of the
+// registry holds all of its repositories.
+func (reg *registry) repositoryIndexComplete(ctx context.Context) (bool, error) {
+	return repositoryIndexComplete(ctx, reg.blobStore.linkDriver())
+}
+
+// putRepositoryIndexEntry records that the repository exists, if not
+// already recorded.
+func putRepositoryIndexEntry(ctx context.Context, d storagedriver.StorageDriver, name string) error {
+	entryPath, err := pathFor(repositoryIndexEntryPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.Stat(ctx, entryPath); err == nil {
+		return nil
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return err
+	}
+
+	return d.PutContent(ctx, entryPath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// removeRepositoryIndexEntry removes the record of the repository. The
+// records of the repositories nested under its name are kept.
+func removeRepositoryIndexEntry(ctx context.Context, d storagedriver.StorageDriver, name string) error {
+	entryPath, err := pathFor(repositoryIndexEntryPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	if err := d.Delete(ctx, entryPath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleIndexedRepository calls function fn with a repository path if
+// fileInfo is the entry of a repository in the repository index under root
+// and that it is lexographically after last. Directories holding no entry
+// after last are skipped. This should be used with Walk over the repository
+// index.
+func handleIndexedRepository(fileInfo storagedriver.FileInfo, root, last string, fn func(repoPath string) error) error {
+	filePath := fileInfo.Path()
+
+	// lop the base path off
+	repo := filePath[len(root)+1:]
+
+	_, file := path.Split(repo)
+	if strings.HasPrefix(file, "_") {
+		if fileInfo.IsDir() {
+			return storagedriver.ErrSkipDir
+		}
+
+		// returning ErrSkipDir for a file would end the walk
+		if file == "_repository" {
+			repo = path.Dir(repo)
+			if lessPath(last, repo) {
+				return fn(repo)
+			}
+		}
+		return nil
+	}
+
+	if last != "" && lessPath(repo, last) && !strings.HasPrefix(last, repo+"/") {
+		return storagedriver.ErrSkipDir
+	}
+
+	return nil
+}
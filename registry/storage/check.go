@@ -0,0 +1,544 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// Kinds of findings of the storage consistency check.
+const (
+	// FindingCorruptBlob is a blob whose data does not match its digest.
+	FindingCorruptBlob = "corrupt-blob"
+
+	// FindingCorruptLink is a link file which does not hold a digest.
+	FindingCorruptLink = "corrupt-link"
+
+	// FindingDanglingLayerLink is a layer link to a missing blob.
+	FindingDanglingLayerLink = "dangling-layer-link"
+
+	// FindingDanglingRevisionLink is a manifest revision link to a missing
+	// blob.
+	FindingDanglingRevisionLink = "dangling-revision-link"
+
+	// FindingDanglingTag is a tag pointing at a missing manifest.
+	FindingDanglingTag = "dangling-tag"
+
+	// FindingUnreadableManifest is a manifest which cannot be parsed.
+	FindingUnreadableManifest = "unreadable-manifest"
+
+	// FindingMissingReference is a blob referenced by a manifest which is
+	// missing from the blob store.
+	FindingMissingReference = "missing-reference"
+
+	// FindingOrphanedUpload is an upload directory without a readable start
+	// time, which upload purging never removes.
+	FindingOrphanedUpload = "orphaned-upload"
+)
+
+// CheckOpts contains options for the storage consistency check
+type CheckOpts struct {
+	// Repair removes the dangling and corrupt links and tags found.
+	Repair bool
+}
+
+// CheckFinding describes an inconsistency found in storage.
+type CheckFinding struct {
+	Kind       string        `json:"kind"`
+	Path       string        `json:"path"`
+	Repository string        `json:"repository,omitempty"`
+	Tag        string        `json:"tag,omitempty"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+	Detail     string        `json:"detail,omitempty"`
+	Repaired   bool          `json:"repaired,omitempty"`
+}
+
+// CheckReport is the outcome of the storage consistency check.
+type CheckReport struct {
+	Blobs        int            `json:"blobs"`
+	Repositories int            `json:"repositories"`
+	Findings     []CheckFinding `json:"findings"`
+}
+
+// checker holds the state of a consistency check.
+type checker struct {
+	storageDriver driver.StorageDriver
+	linkDriver    driver.StorageDriver
+	registry      distribution.Namespace
+	opts          CheckOpts
+
+	blobs   map[digest.Digest]struct{}
+	repairs []repair
+	report  *CheckReport
+}
+
+// repair is a path to remove to repair a finding, along with the link found
+// dangling or corrupt and its content.
+type repair struct {
+	finding  int
+	path     string
+	linkPath string
+	content  []byte
+}
+
+// Check verifies the blobs, links, tags and uploads in storage. Blobs are
+// re-hashed against their digests, links and tags are checked against the
+// blobs they point at and manifests against the blobs they reference. With
+// the Repair option, dangling and corrupt links are removed; other findings
+// are only reported. Links are checked again before being removed, and are
+// kept if they were written to or no longer dangle. The registry should be
+// in read-only mode or not running while this runs.
+func Check(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts CheckOpts) (*CheckReport, error) {
+	linkDriver := linkDriverOf(registry)
+	if linkDriver == nil {
+		return nil, fmt.Errorf("unable to convert Namespace to a storage registry")
+	}
+
+	c := &checker{
+		storageDriver: storageDriver,
+		linkDriver:    linkDriver,
+		registry:      registry,
+		opts:          opts,
+		blobs:         make(map[digest.Digest]struct{}),
+		report:        &CheckReport{Findings: []CheckFinding{}},
+	}
+
+	if err := c.checkBlobs(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check blobs: %v", err)
+	}
+
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		c.report.Repositories++
+		dcontext.GetLogger(ctx).Infof("checking repository %s", repoName)
+		if err := c.checkRepository(ctx, repoName); err != nil {
+			return fmt.Errorf("failed to check repository %s: %v", repoName, err)
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return nil, err
+		}
+	}
+
+	if err := c.checkUploads(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check uploads: %v", err)
+	}
+
+	return c.report, nil
+}
+
+// checkBlobs re-hashes every blob, recording those present in the blob
+// store.
+func (c *checker) checkBlobs(ctx context.Context) error {
+	root, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	err = c.storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "data" {
+			return nil
+		}
+
+		c.report.Blobs++
+		dgst, err := digestFromPath(fileInfo.Path())
+		if err != nil {
+			c.addFinding(CheckFinding{
+				Kind:   FindingCorruptBlob,
+				Path:   fileInfo.Path(),
+				Detail: err.Error(),
+			})
+			return nil
+		}
+
+		if !dgst.Algorithm().Available() {
+			c.addFinding(CheckFinding{
+				Kind:   FindingCorruptBlob,
+				Path:   fileInfo.Path(),
+				Digest: dgst,
+				Detail: fmt.Sprintf("unsupported digest algorithm %s", dgst.Algorithm()),
+			})
+			return nil
+		}
+
+		reader, err := c.storageDriver.Reader(ctx, fileInfo.Path(), 0)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		actual, err := dgst.Algorithm().FromReader(reader)
+		if err != nil {
+			return err
+		}
+		if actual != dgst {
+			c.addFinding(CheckFinding{
+				Kind:   FindingCorruptBlob,
+				Path:   fileInfo.Path(),
+				Digest: dgst,
+				Detail: fmt.Sprintf("content hashes to %s", actual),
+			})
+		}
+
+		// links to corrupt blobs are not dangling, the blob is reported
+		c.blobs[dgst] = struct{}{}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// checkRepository checks the layer links, manifest revisions and tags of a
+// repository.
+func (c *checker) checkRepository(ctx context.Context, repoName string) error {
+	layersPath, err := pathFor(layersPathSpec{name: repoName})
+	if err != nil {
+		return err
+	}
+
+	err = c.walkLinks(ctx, layersPath, func(linkPath string, parts []string) error {
+		if len(parts) != 3 {
+			return nil
+		}
+		dgst, ok, err := c.readLink(ctx, repoName, linkPath, path.Dir(linkPath))
+		if err != nil || !ok {
+			return err
+		}
+
+		if _, ok := c.blobs[dgst]; !ok {
+			return c.addRepairableFinding(ctx, CheckFinding{
+				Kind:       FindingDanglingLayerLink,
+				Path:       linkPath,
+				Repository: repoName,
+				Digest:     dgst,
+			}, linkPath, []byte(dgst), path.Dir(linkPath))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return err
+	}
+	repository, err := c.registry.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+
+	revisionsPath, err := pathFor(manifestRevisionsPathSpec{name: repoName})
+	if err != nil {
+		return err
+	}
+
+	revisions := make(map[digest.Digest]struct{})
+	err = c.walkLinks(ctx, revisionsPath, func(linkPath string, parts []string) error {
+		if len(parts) != 3 {
+			return nil
+		}
+		dgst, ok, err := c.readLink(ctx, repoName, linkPath, path.Dir(linkPath))
+		if err != nil || !ok {
+			return err
+		}
+
+		if _, ok := c.blobs[dgst]; !ok {
+			return c.addRepairableFinding(ctx, CheckFinding{
+				Kind:       FindingDanglingRevisionLink,
+				Path:       linkPath,
+				Repository: repoName,
+				Digest:     dgst,
+			}, linkPath, []byte(dgst), path.Dir(linkPath))
+		}
+		revisions[dgst] = struct{}{}
+
+		manifest, err := manifests.Get(ctx, dgst)
+		if err != nil {
+			c.addFinding(CheckFinding{
+				Kind:       FindingUnreadableManifest,
+				Path:       linkPath,
+				Repository: repoName,
+				Digest:     dgst,
+				Detail:     err.Error(),
+			})
+			return nil
+		}
+
+		for _, ref := range manifest.References() {
+			if len(ref.URLs) > 0 {
+				// foreign layers are not stored in the registry
+				continue
+			}
+			if _, ok := c.blobs[ref.Digest]; !ok {
+				c.addFinding(CheckFinding{
+					Kind:       FindingMissingReference,
+					Path:       linkPath,
+					Repository: repoName,
+					Digest:     ref.Digest,
+					Detail:     fmt.Sprintf("referenced by manifest %s", dgst),
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tagsPath, err := pathFor(manifestTagsPathSpec{name: repoName})
+	if err != nil {
+		return err
+	}
+
+	err = c.walkLinks(ctx, tagsPath, func(linkPath string, parts []string) error {
+		// only the current link of tags, <tag>/current/link, is checked
+		if len(parts) != 3 || parts[1] != "current" {
+			return nil
+		}
+
+		tag := parts[0]
+		tagPath, err := pathFor(manifestTagPathSpec{name: repoName, tag: tag})
+		if err != nil {
+			return err
+		}
+
+		dgst, ok, err := c.readLink(ctx, repoName, linkPath, tagPath)
+		if err != nil || !ok {
+			return err
+		}
+
+		if _, ok := revisions[dgst]; !ok {
+			return c.addRepairableFinding(ctx, CheckFinding{
+				Kind:       FindingDanglingTag,
+				Path:       linkPath,
+				Repository: repoName,
+				Tag:        tag,
+				Digest:     dgst,
+			}, linkPath, []byte(dgst), tagPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.repair(ctx)
+}
+
+// walkLinks calls fn with every link file under root in the link driver,
+// along with the components of its path relative to root. Tag indexes are
+// skipped.
+func (c *checker) walkLinks(ctx context.Context, root string, fn func(linkPath string, parts []string) error) error {
+	err := c.linkDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		parts := strings.Split(strings.TrimPrefix(fileInfo.Path(), root+"/"), "/")
+		if fileInfo.IsDir() {
+			if len(parts) == 2 && parts[1] == "index" {
+				return driver.ErrSkipDir
+			}
+			return nil
+		}
+
+		if parts[len(parts)-1] != "link" {
+			return nil
+		}
+		return fn(fileInfo.Path(), parts)
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// readLink reads the digest of the link file, recording a corrupt link and
+// removing repairPath when repairing if it does not hold one.
+func (c *checker) readLink(ctx context.Context, repoName, linkPath, repairPath string) (digest.Digest, bool, error) {
+	content, err := c.linkDriver.GetContent(ctx, linkPath)
+	if err != nil {
+		return "", false, err
+	}
+
+	dgst, err := digest.Parse(string(content))
+	if err != nil {
+		return "", false, c.addRepairableFinding(ctx, CheckFinding{
+			Kind:       FindingCorruptLink,
+			Path:       linkPath,
+			Repository: repoName,
+			Detail:     err.Error(),
+		}, linkPath, content, repairPath)
+	}
+	return dgst, true, nil
+}
+
+// checkUploads finds the upload directories without a readable start time.
+func (c *checker) checkUploads(ctx context.Context) error {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	var uploads []string
+	err = c.storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			return nil
+		}
+
+		parent, file := path.Split(fileInfo.Path())
+		if path.Base(parent) == "_uploads" {
+			uploads = append(uploads, fileInfo.Path())
+			return driver.ErrSkipDir
+		}
+		if strings.HasPrefix(file, "_") && file != "_uploads" {
+			return driver.ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	for _, uploadPath := range uploads {
+		repoName := strings.TrimPrefix(path.Dir(path.Dir(uploadPath)), root+"/")
+		id := path.Base(uploadPath)
+
+		startedAtPath, err := pathFor(uploadStartedAtPathSpec{name: repoName, id: id})
+		if err != nil {
+			return err
+		}
+
+		if _, err := readStartedAtFile(c.storageDriver, startedAtPath); err != nil {
+			c.addFinding(CheckFinding{
+				Kind:       FindingOrphanedUpload,
+				Path:       uploadPath,
+				Repository: repoName,
+				Detail:     err.Error(),
+			})
+		}
+	}
+	return nil
+}
+
+// addFinding records a finding.
+func (c *checker) addFinding(finding CheckFinding) {
+	c.report.Findings = append(c.report.Findings, finding)
+}
+
+// addRepairableFinding records a finding about the link at linkPath,
+// holding content, scheduling the removal of repairPath from the link driver
+// when repairing.
+func (c *checker) addRepairableFinding(ctx context.Context, finding CheckFinding, linkPath string, content []byte, repairPath string) error {
+	c.addFinding(finding)
+	if c.opts.Repair {
+		c.repairs = append(c.repairs, repair{
+			finding:  len(c.report.Findings) - 1,
+			path:     repairPath,
+			linkPath: linkPath,
+			content:  content,
+		})
+	}
+	return nil
+}
+
+// repair removes the paths scheduled for removal. Paths are not removed while
+// walking, since removing the directory being walked would fail the walk on
+// some drivers.
+func (c *checker) repair(ctx context.Context) error {
+	for _, r := range c.repairs {
+		broken, err := c.stillBroken(ctx, r)
+		if err != nil {
+			return err
+		}
+		if !broken {
+			dcontext.GetLogger(ctx).Infof("keeping %s, changed since checked", r.path)
+			continue
+		}
+
+		dcontext.GetLogger(ctx).Infof("removing %s", r.path)
+		if err := c.linkDriver.Delete(ctx, r.path); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+		c.report.Findings[r.finding].Repaired = true
+	}
+	c.repairs = nil
+	return nil
+}
+
+// stillBroken re-reads the link of a repair and checks the blob or revision
+// it points at again, since they may have been written since the link was
+// checked.
+func (c *checker) stillBroken(ctx context.Context, r repair) (bool, error) {
+	content, err := c.linkDriver.GetContent(ctx, r.linkPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	if !bytes.Equal(content, r.content) {
+		return false, nil
+	}
+
+	finding := c.report.Findings[r.finding]
+	switch finding.Kind {
+	case FindingDanglingLayerLink, FindingDanglingRevisionLink:
+		return c.blobMissing(ctx, finding.Digest)
+	case FindingDanglingTag:
+		revisionLinkPath, err := pathFor(manifestRevisionLinkPathSpec{name: finding.Repository, revision: finding.Digest})
+		if err != nil {
+			return false, err
+		}
+		content, err := c.linkDriver.GetContent(ctx, revisionLinkPath)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				return true, nil
+			}
+			return false, err
+		}
+		dgst, err := digest.Parse(string(content))
+		if err != nil {
+			return true, nil
+		}
+		return c.blobMissing(ctx, dgst)
+	}
+	return true, nil
+}
+
+// blobMissing returns true if the blob store holds no data for dgst.
+func (c *checker) blobMissing(ctx context.Context, dgst digest.Digest) (bool, error) {
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := c.storageDriver.Stat(ctx, blobPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/uuid"
+	"github.com/opencontainers/go-digest"
+)
+
+func checkFindingKinds(report *CheckReport) []string {
+	var kinds []string
+	for _, finding := range report.Findings {
+		kind := finding.Kind
+		if finding.Repaired {
+			kind += " (repaired)"
+		}
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func TestCheckConsistent(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "consistent")
+
+	image1 := uploadRandomSchema1Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "schema1", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag: %v", err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "schema2", distribution.Descriptor{Digest: image2.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag: %v", err)
+	}
+
+	report, err := Check(ctx, inmemoryDriver, registry, CheckOpts{})
+	if err != nil {
+		t.Fatalf("failed to check: %v", err)
+	}
+
+	if len(report.Findings) != 0 {
+		t.Fatalf("unexpected findings: %v", report.Findings)
+	}
+	if report.Repositories != 1 {
+		t.Fatalf("unexpected number of repositories: %d != 1", report.Repositories)
+	}
+	if blobs := allBlobs(t, registry); report.Blobs != len(blobs) {
+		t.Fatalf("unexpected number of blobs: %d != %d", report.Blobs, len(blobs))
+	}
+}
+
+func TestCheckRepair(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "inconsistent")
+
+	image := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag: %v", err)
+	}
+
+	// corrupt one of the layers
+	var layer digest.Digest
+	for dgst := range image.layers {
+		layer = dgst
+		break
+	}
+	dataPath, err := pathFor(blobDataPathSpec{digest: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, dataPath, []byte("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// link a missing layer and tag a missing manifest
+	missing := digest.FromString("missing")
+	layerLinkPath, err := pathFor(layerLinkPathSpec{name: "inconsistent", digest: missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, layerLinkPath, []byte(missing)); err != nil {
+		t.Fatal(err)
+	}
+	tagPath, err := pathFor(manifestTagCurrentPathSpec{name: "inconsistent", tag: "dangling"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, tagPath, []byte(missing)); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt a revision link
+	revisionLinkPath, err := pathFor(manifestRevisionLinkPathSpec{name: "inconsistent", revision: digest.FromString("corrupt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, revisionLinkPath, []byte("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// leave an upload without start time
+	uploadPath, err := pathFor(uploadDataPathSpec{name: "inconsistent", id: uuid.Generate().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, uploadPath, []byte("upload")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Check(ctx, inmemoryDriver, registry, CheckOpts{})
+	if err != nil {
+		t.Fatalf("failed to check: %v", err)
+	}
+	expected := []string{
+		FindingCorruptBlob,
+		FindingCorruptLink,
+		FindingDanglingLayerLink,
+		FindingDanglingTag,
+		FindingOrphanedUpload,
+	}
+	if kinds := checkFindingKinds(report); !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("unexpected findings: %v != %v", kinds, expected)
+	}
+
+	report, err = Check(ctx, inmemoryDriver, registry, CheckOpts{Repair: true})
+	if err != nil {
+		t.Fatalf("failed to check: %v", err)
+	}
+	expected = []string{
+		FindingCorruptBlob,
+		FindingCorruptLink + " (repaired)",
+		FindingDanglingLayerLink + " (repaired)",
+		FindingDanglingTag + " (repaired)",
+		FindingOrphanedUpload,
+	}
+	if kinds := checkFindingKinds(report); !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("unexpected findings: %v != %v", kinds, expected)
+	}
+
+	// only the findings which are not repaired remain
+	report, err = Check(ctx, inmemoryDriver, registry, CheckOpts{})
+	if err != nil {
+		t.Fatalf("failed to check: %v", err)
+	}
+	expected = []string{
+		FindingCorruptBlob,
+		FindingOrphanedUpload,
+	}
+	if kinds := checkFindingKinds(report); !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("unexpected findings: %v != %v", kinds, expected)
+	}
+
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+}
+
+func TestCheckRepairKeepsChangedLinks(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	c := &checker{
+		storageDriver: inmemoryDriver,
+		linkDriver:    inmemoryDriver,
+		opts:          CheckOpts{Repair: true},
+		report:        &CheckReport{},
+	}
+
+	// links found dangling or corrupt, then written to before the repair
+	missing := digest.FromString("missing")
+	layerLinkPath, err := pathFor(layerLinkPathSpec{name: "busy", digest: missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagPath, err := pathFor(manifestTagPathSpec{name: "busy", tag: "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagLinkPath, err := pathFor(manifestTagCurrentPathSpec{name: "busy", tag: "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptLinkPath, err := pathFor(manifestTagCurrentPathSpec{name: "busy", tag: "corrupt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, linkPath := range []string{layerLinkPath, tagLinkPath} {
+		if err := inmemoryDriver.PutContent(ctx, linkPath, []byte(missing)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := inmemoryDriver.PutContent(ctx, corruptLinkPath, []byte("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+
+	c.addRepairableFinding(ctx, CheckFinding{Kind: FindingDanglingLayerLink, Repository: "busy", Digest: missing}, layerLinkPath, []byte(missing), path.Dir(layerLinkPath))
+	c.addRepairableFinding(ctx, CheckFinding{Kind: FindingDanglingTag, Repository: "busy", Digest: missing}, tagLinkPath, []byte(missing), tagPath)
+	c.addRepairableFinding(ctx, CheckFinding{Kind: FindingCorruptLink, Repository: "busy"}, corruptLinkPath, []byte("corrupt"), path.Dir(path.Dir(corruptLinkPath)))
+
+	dataPath, err := pathFor(blobDataPathSpec{digest: missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, dataPath, []byte("missing")); err != nil {
+		t.Fatal(err)
+	}
+	revisionLinkPath, err := pathFor(manifestRevisionLinkPathSpec{name: "busy", revision: missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, revisionLinkPath, []byte(missing)); err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, corruptLinkPath, []byte(missing)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.repair(ctx); err != nil {
+		t.Fatalf("failed to repair: %v", err)
+	}
+
+	for _, finding := range c.report.Findings {
+		if finding.Repaired {
+			t.Errorf("unexpected repair of %s", finding.Kind)
+		}
+	}
+	for _, linkPath := range []string{layerLinkPath, tagLinkPath, corruptLinkPath} {
+		if _, err := inmemoryDriver.Stat(ctx, linkPath); err != nil {
+			t.Errorf("expected %s to be kept: %v", linkPath, err)
+		}
+	}
+}
@@ -238,3 +238,39 @@ func (base *Base) Walk(ctx context.Context, path string, f storagedriver.WalkFn)
 
 	return base.setDriverName(base.StorageDriver.Walk(ctx, path, f))
 }
+
+// WalkStartAfter wraps WalkStartAfter of underlying storage driver,
+// returning an ErrUnsupportedMethod if it does not implement it.
+func (base *Base) WalkStartAfter(ctx context.Context, path, startAfter string, f storagedriver.WalkFn) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("%s.WalkStartAfter(%q, %q)", base.Name(), path, startAfter)
+
+	if !storagedriver.PathRegexp.MatchString(path) && path != "/" {
+		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
+	}
+
+	walker, ok := base.StorageDriver.(storagedriver.StartAfterWalker)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{DriverName: base.StorageDriver.Name()}
+	}
+
+	return base.setDriverName(walker.WalkStartAfter(ctx, path, startAfter, f))
+}
+
+// SetModTime wraps SetModTime of underlying storage driver, returning an
+// ErrUnsupportedMethod if it does not implement it.
+func (base *Base) SetModTime(ctx context.Context, path string, modTime time.Time) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("%s.SetModTime(%q)", base.Name(), path)
+
+	if !storagedriver.PathRegexp.MatchString(path) {
+		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
+	}
+
+	setter, ok := base.StorageDriver.(storagedriver.ModTimeSetter)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{DriverName: base.StorageDriver.Name()}
+	}
+
+	return base.setDriverName(setter.SetModTime(ctx, path, modTime))
+}
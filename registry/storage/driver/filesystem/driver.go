@@ -289,6 +289,15 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 	return "", storagedriver.ErrUnsupportedMethod{}
 }
 
+// SetModTime sets the modification time of the file at "path".
+func (d *driver) SetModTime(ctx context.Context, subPath string, modTime time.Time) error {
+	err := os.Chtimes(d.fullPath(subPath), modTime, modTime)
+	if os.IsNotExist(err) {
+		return storagedriver.PathNotFoundError{Path: subPath}
+	}
+	return err
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file and directory
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
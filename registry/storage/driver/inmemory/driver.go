@@ -244,6 +244,22 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 	return "", storagedriver.ErrUnsupportedMethod{}
 }
 
+// SetModTime sets the modification time of the file at "path".
+func (d *driver) SetModTime(ctx context.Context, path string, modTime time.Time) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	normalized := normalize(path)
+	found := d.root.find(normalized)
+	f, ok := found.(*file)
+	if !ok || found.path() != normalized {
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+
+	f.mod = modTime
+	return nil
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file and directory
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
@@ -962,6 +962,17 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file
 func (d *driver) Walk(ctx context.Context, from string, f storagedriver.WalkFn) error {
+	return d.walk(ctx, from, "", f)
+}
+
+// WalkStartAfter traverses a filesystem defined within driver, starting
+// from the given path, skipping the objects whose keys sort before or at
+// startAfter.
+func (d *driver) WalkStartAfter(ctx context.Context, from, startAfter string, f storagedriver.WalkFn) error {
+	return d.walk(ctx, from, startAfter, f)
+}
+
+func (d *driver) walk(ctx context.Context, from, startAfter string, f storagedriver.WalkFn) error {
 	path := from
 	if !strings.HasSuffix(path, "/") {
 		path = path + "/"
@@ -973,7 +984,10 @@ func (d *driver) Walk(ctx context.Context, from string, f storagedriver.WalkFn)
 	}
 
 	var objectCount int64
-	if err := d.doWalk(ctx, &objectCount, d.s3Path(path), prefix, f); err != nil {
+	if startAfter != "" {
+		startAfter = d.s3Path(startAfter)
+	}
+	if err := d.doWalk(ctx, &objectCount, d.s3Path(path), prefix, startAfter, f); err != nil {
 		return err
 	}
 
@@ -985,7 +999,7 @@ func (d *driver) Walk(ctx context.Context, from string, f storagedriver.WalkFn)
 	return nil
 }
 
-func (d *driver) doWalk(parentCtx context.Context, objectCount *int64, path, prefix string, f storagedriver.WalkFn) error {
+func (d *driver) doWalk(parentCtx context.Context, objectCount *int64, path, prefix, startAfter string, f storagedriver.WalkFn) error {
 	var (
 		retError error
 		// the most recent directory walked for de-duping
@@ -1000,6 +1014,9 @@ func (d *driver) doWalk(parentCtx context.Context, objectCount *int64, path, pre
 		Prefix:  aws.String(path),
 		MaxKeys: aws.Int64(listMax),
 	}
+	if startAfter != "" {
+		listObjectsInput.StartAfter = aws.String(startAfter)
+	}
 
 	ctx, done := dcontext.WithTrace(parentCtx)
 	defer done("s3aws.ListObjectsV2Pages(%s)", path)
@@ -1045,7 +1062,7 @@ func (d *driver) doWalk(parentCtx context.Context, objectCount *int64, path, pre
 
 		for _, walkInfo := range walkInfos {
 			// skip any results under the last skip directory
-			if prevSkipDir != "" && strings.HasPrefix(walkInfo.Path(), prevSkipDir) {
+			if prevSkipDir != "" && strings.HasPrefix(walkInfo.Path(), prevSkipDir+"/") {
 				continue
 			}
 
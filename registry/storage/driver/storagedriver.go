@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Version is a string representing the storage driver version, of the form
@@ -92,6 +93,25 @@ type StorageDriver interface {
 	Walk(ctx context.Context, path string, f WalkFn) error
 }
 
+// StartAfterWalker is an optional interface of storage drivers which can
+// begin a walk part way through, without listing the paths before it.
+type StartAfterWalker interface {
+	// WalkStartAfter is like Walk, except that the paths under path which
+	// sort before or at startAfter may be skipped. It is only a hint: callers
+	// must still filter the paths they are given. May return an
+	// ErrUnsupportedMethod when the underlying driver cannot skip paths.
+	WalkStartAfter(ctx context.Context, path, startAfter string, f WalkFn) error
+}
+
+// ModTimeSetter is an optional interface of storage drivers which can set
+// the modification time of a file, so that copies keep the time of the
+// original.
+type ModTimeSetter interface {
+	// SetModTime sets the modification time of the file at path. May return
+	// an ErrUnsupportedMethod when the underlying driver cannot set it.
+	SetModTime(ctx context.Context, path string, modTime time.Time) error
+}
+
 // FileWriter provides an abstraction for an opened writable file-like object in
 // the storage backend. The FileWriter must flush all content written to it on
 // the call to Close, but is only required to make its content readable on a
@@ -169,3 +189,8 @@ type Error struct {
 func (err Error) Error() string {
 	return fmt.Sprintf("%s: %s", err.DriverName, err.Enclosed)
 }
+
+// Unwrap returns the error enclosed by the driver.
+func (err Error) Unwrap() error {
+	return err.Enclosed
+}
@@ -60,22 +60,26 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
 		}
 
+		// Read the current revision of every tag once, rather than looking
+		// up the tags of each manifest. The tag reverse index is not used,
+		// since it may miss tags moved concurrently or by an older registry.
+		var (
+			allTags []string
+			tagged  map[digest.Digest]struct{}
+		)
+		if opts.RemoveUntagged {
+			allTags, tagged, err = currentRevisions(ctx, repository.Tags(ctx))
+			if err != nil {
+				return fmt.Errorf("failed to retrieve tags of %s: %v", repoName, err)
+			}
+		}
+
 		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
 			if opts.RemoveUntagged {
-				// fetch all tags where this manifest is the latest one
-				tags, err := repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
-				if err != nil {
-					return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
-				}
-				if len(tags) == 0 {
+				if _, ok := tagged[dgst]; !ok {
 					emit("manifest eligible for deletion: %s", dgst)
-					// fetch all tags from repository
-					// all of these tags could contain manifest in history
+					// all of the tags could contain manifest in history
 					// which means that we need check (and delete) those references when deleting manifest
-					allTags, err := repository.Tags(ctx).All(ctx)
-					if err != nil {
-						return fmt.Errorf("failed to retrieve tags %v", err)
-					}
 					manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
 					return nil
 				}
@@ -116,6 +120,7 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 
 	// sweep
 	vacuum := NewVacuum(ctx, storageDriver)
+	vacuum.metadata = metadataOf(registry)
 	if !opts.DryRun {
 		for _, obj := range manifestArr {
 			err = vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags)
@@ -150,3 +155,28 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 
 	return err
 }
+
+// currentRevisions returns every tag of the repository along with the set of
+// revisions they currently point at.
+func currentRevisions(ctx context.Context, tagService distribution.TagService) ([]string, map[digest.Digest]struct{}, error) {
+	allTags, err := tagService.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return nil, nil, err
+		}
+	}
+
+	revisions := make(map[digest.Digest]struct{}, len(allTags))
+	for _, tag := range allTags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, nil, err
+		}
+		revisions[desc.Digest] = struct{}{}
+	}
+
+	return allTags, revisions, nil
+}
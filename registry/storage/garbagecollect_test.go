@@ -296,6 +296,39 @@ func TestDeleteManifestIfTagNotFound(t *testing.T) {
 	}
 }
 
+func TestGCKeepsTaggedManifestMissingFromTagIndex(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "unindexed")
+	manifestService := makeManifestService(t, repo)
+
+	image := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	// Drop the reverse index entry, as when a concurrent update of the tag
+	// removed it or an older registry moved the tag.
+	ts := repo.Tags(ctx).(*tagStore)
+	if err := ts.removeReverseIndexEntry(ctx, "latest", image.manifestDigest); err != nil {
+		t.Fatalf("failed to remove reverse index entry: %v", err)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	if _, ok := allManifests(t, manifestService)[image.manifestDigest]; !ok {
+		t.Fatalf("tagged manifest %s was garbage collected", image.manifestDigest)
+	}
+}
+
 func TestGCWithMissingManifests(t *testing.T) {
 	ctx := context.Background()
 	d := inmemory.New()
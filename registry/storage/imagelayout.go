@@ -0,0 +1,813 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/schema1"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// An OCI image layout holds the oci-layout version file, the index.json file
+// listing the manifests of the layout, and the manifests and blobs they
+// reference under blobs/<algorithm>/<hex digest>. Manifests are exported and
+// imported with their original payload, so that their digests and
+// annotations are preserved. The tags of the manifests are recorded in
+// index.json with the org.opencontainers.image.ref.name annotation.
+//
+// A layout is either a directory or, if its path ends with .tar, a tar
+// archive of that directory.
+
+// maxImageLayoutManifestSize is the largest manifest read from a layout,
+// matching the largest manifest accepted by the registry.
+const maxImageLayoutManifestSize = 4 << 20
+
+// ExportImageLayout writes the manifests of the repository matching ref,
+// along with the manifests and blobs they reference, to an OCI image layout
+// at target. The manifest of the tag or digest of ref is exported if it has
+// one, and the manifests of every tag of the repository otherwise. Docker
+// schema1 manifests cannot be exported.
+func ExportImageLayout(ctx context.Context, registry distribution.Namespace, ref reference.Named, target string) error {
+	repository, err := registry.Repository(ctx, reference.TrimNamed(ref))
+	if err != nil {
+		return err
+	}
+
+	roots, err := exportRoots(ctx, repository, ref)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+
+	w, err := newImageLayoutWriter(target)
+	if err != nil {
+		return err
+	}
+
+	e := &imageLayoutExporter{
+		blobs:     repository.Blobs(ctx),
+		manifests: manifests,
+		w:         w,
+		exported:  make(map[digest.Digest]v1.Descriptor),
+	}
+
+	if err := e.export(ctx, roots); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// exportRoot is a manifest listed in index.json.
+type exportRoot struct {
+	tag    string
+	digest digest.Digest
+}
+
+// exportRoots returns the manifests of the repository matching ref.
+func exportRoots(ctx context.Context, repository distribution.Repository, ref reference.Named) ([]exportRoot, error) {
+	if digested, ok := ref.(reference.Digested); ok {
+		var tag string
+		if tagged, ok := ref.(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+		return []exportRoot{{tag: tag, digest: digested.Digest()}}, nil
+	}
+
+	tags := repository.Tags(ctx)
+
+	var names []string
+	if tagged, ok := ref.(reference.Tagged); ok {
+		names = []string{tagged.Tag()}
+	} else {
+		all, err := tags.All(ctx)
+		if err != nil {
+			return nil, err
+		}
+		names = all
+	}
+
+	roots := make([]exportRoot, 0, len(names))
+	for _, tag := range names {
+		desc, err := tags.Get(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, exportRoot{tag: tag, digest: desc.Digest})
+	}
+	return roots, nil
+}
+
+// imageLayoutExporter writes manifests and blobs to an image layout.
+type imageLayoutExporter struct {
+	blobs     distribution.BlobStore
+	manifests distribution.ManifestService
+	w         imageLayoutWriter
+
+	// exported holds the descriptors of the manifests and blobs written
+	exported map[digest.Digest]v1.Descriptor
+}
+
+// export writes the layout with the given manifests listed in index.json.
+func (e *imageLayoutExporter) export(ctx context.Context, roots []exportRoot) error {
+	layout, err := json.Marshal(v1.ImageLayout{Version: v1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := e.w.WriteFile(v1.ImageLayoutFile, int64(len(layout)), bytes.NewReader(layout)); err != nil {
+		return err
+	}
+
+	index := v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: make([]v1.Descriptor, 0, len(roots)),
+	}
+	for _, root := range roots {
+		desc, err := e.exportManifest(ctx, root.digest)
+		if err != nil {
+			return fmt.Errorf("failed to export manifest %s: %v", root.digest, err)
+		}
+
+		if root.tag != "" {
+			desc.Annotations = map[string]string{v1.AnnotationRefName: root.tag}
+		}
+		index.Manifests = append(index.Manifests, desc)
+	}
+
+	content, err := json.MarshalIndent(index, "", "   ")
+	if err != nil {
+		return err
+	}
+	return e.w.WriteFile("index.json", int64(len(content)), bytes.NewReader(content))
+}
+
+// exportManifest writes the manifest with the given digest along with the
+// manifests and blobs it references.
+func (e *imageLayoutExporter) exportManifest(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
+	if desc, ok := e.exported[dgst]; ok {
+		return desc, nil
+	}
+
+	manifest, err := e.manifests.Get(ctx, dgst)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	switch m := manifest.(type) {
+	case *schema1.SignedManifest:
+		return v1.Descriptor{}, fmt.Errorf("schema1 manifests cannot be exported to an image layout")
+	case *manifestlist.DeserializedManifestList:
+		for _, child := range m.Manifests {
+			if _, err := e.exportManifest(ctx, child.Digest); err != nil {
+				return v1.Descriptor{}, fmt.Errorf("failed to export manifest %s: %v", child.Digest, err)
+			}
+		}
+	default:
+		for _, ref := range manifest.References() {
+			if err := e.exportBlob(ctx, ref); err != nil {
+				return v1.Descriptor{}, fmt.Errorf("failed to export blob %s: %v", ref.Digest, err)
+			}
+		}
+	}
+
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	if digest.FromBytes(payload) != dgst {
+		return v1.Descriptor{}, fmt.Errorf("payload of manifest %s does not match its digest", dgst)
+	}
+
+	if err := e.w.WriteFile(imageLayoutBlobPath(dgst), int64(len(payload)), bytes.NewReader(payload)); err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	desc := v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}
+	e.exported[dgst] = desc
+	return desc, nil
+}
+
+// exportBlob writes the blob of the descriptor. Blobs with URLs missing from
+// the registry are left out of the layout, as they are in the registry.
+func (e *imageLayoutExporter) exportBlob(ctx context.Context, ref distribution.Descriptor) error {
+	if _, ok := e.exported[ref.Digest]; ok {
+		return nil
+	}
+
+	desc, err := e.blobs.Stat(ctx, ref.Digest)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown && len(ref.URLs) > 0 {
+			dcontext.GetLogger(ctx).Infof("skipping foreign blob %s", ref.Digest)
+			return nil
+		}
+		return err
+	}
+
+	reader, err := e.blobs.Open(ctx, ref.Digest)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := e.w.WriteFile(imageLayoutBlobPath(ref.Digest), desc.Size, reader); err != nil {
+		return err
+	}
+
+	e.exported[ref.Digest] = v1.Descriptor{MediaType: desc.MediaType, Digest: ref.Digest, Size: desc.Size}
+	return nil
+}
+
+// ImportOpts contains options for the import of an image layout
+type ImportOpts struct {
+	// Force overwrites the tags of the repository which point to other
+	// manifests than the tags of the layout.
+	Force bool
+}
+
+// ImportImageLayout ingests the manifests of the OCI image layout at source,
+// along with the manifests and blobs they reference, into the repository of
+// ref, and tags them with the tags recorded in the layout. If ref has a tag,
+// only the manifest recorded with that tag is imported, or the only manifest
+// of the layout if it has a single one, and it is tagged with the tag of ref.
+//
+// Tags are written directly to the storage, without the tag policies of the
+// registry, so the import fails before writing anything if a tag already
+// points to another manifest, unless opts.Force is set.
+func ImportImageLayout(ctx context.Context, registry distribution.Namespace, source string, ref reference.Named, opts ImportOpts) error {
+	r, err := newImageLayoutReader(source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	index, err := readImageLayoutIndex(r)
+	if err != nil {
+		return err
+	}
+
+	repository, err := registry.Repository(ctx, reference.TrimNamed(ref))
+	if err != nil {
+		return err
+	}
+
+	roots, err := importRoots(repository.Named(), index, ref)
+	if err != nil {
+		return err
+	}
+
+	tags := repository.Tags(ctx)
+	if !opts.Force {
+		if err := checkImportTags(ctx, tags, roots); err != nil {
+			return err
+		}
+	}
+
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+
+	i := &imageLayoutImporter{
+		r:         r,
+		blobs:     repository.Blobs(ctx),
+		manifests: manifests,
+		loaded:    make(map[digest.Digest]distribution.Manifest),
+		blobRefs:  make(map[digest.Digest]distribution.Descriptor),
+		put:       make(map[digest.Digest]struct{}),
+	}
+
+	if err := i.load(ctx, roots); err != nil {
+		return err
+	}
+	if err := i.ingestBlobs(ctx); err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		if err := i.putManifest(ctx, root.Digest); err != nil {
+			return err
+		}
+	}
+
+	for _, root := range roots {
+		if root.tag == "" {
+			continue
+		}
+		if err := tags.Tag(ctx, root.tag, distribution.Descriptor{Digest: root.Digest}); err != nil {
+			return fmt.Errorf("failed to tag %s: %v", root.tag, err)
+		}
+	}
+	return nil
+}
+
+// checkImportTags returns an error if a tag of the roots already points to
+// another manifest.
+func checkImportTags(ctx context.Context, tags distribution.TagService, roots []importRoot) error {
+	for _, root := range roots {
+		if root.tag == "" {
+			continue
+		}
+
+		desc, err := tags.Get(ctx, root.tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return err
+		}
+		if desc.Digest != root.Digest {
+			return fmt.Errorf("tag %s already points to manifest %s", root.tag, desc.Digest)
+		}
+	}
+	return nil
+}
+
+// importRoot is a manifest of index.json to import, with the tag it is
+// imported with, if any.
+type importRoot struct {
+	v1.Descriptor
+	tag string
+}
+
+// importRoots returns the manifests of the index matching ref.
+func importRoots(name reference.Named, index v1.Index, ref reference.Named) ([]importRoot, error) {
+	var roots []importRoot
+	for _, desc := range index.Manifests {
+		roots = append(roots, importRoot{
+			Descriptor: desc,
+			tag:        imageLayoutTag(name, desc.Annotations[v1.AnnotationRefName]),
+		})
+	}
+
+	tagged, ok := ref.(reference.Tagged)
+	if !ok {
+		return roots, nil
+	}
+
+	for _, root := range roots {
+		if root.tag == tagged.Tag() {
+			return []importRoot{root}, nil
+		}
+	}
+	if len(roots) == 1 {
+		roots[0].tag = tagged.Tag()
+		return roots, nil
+	}
+	return nil, fmt.Errorf("no manifest for tag %s in the image layout", tagged.Tag())
+}
+
+// imageLayoutTag returns the tag of the reference name annotation of a
+// manifest of index.json, which is either a tag or a full reference.
+func imageLayoutTag(name reference.Named, refName string) string {
+	if refName == "" {
+		return ""
+	}
+	if named, err := reference.ParseNormalizedNamed(refName); err == nil {
+		if tagged, ok := named.(reference.Tagged); ok {
+			return tagged.Tag()
+		}
+	}
+	if _, err := reference.WithTag(name, refName); err == nil {
+		return refName
+	}
+	return ""
+}
+
+// imageLayoutImporter ingests manifests and blobs from an image layout.
+type imageLayoutImporter struct {
+	r         imageLayoutReader
+	blobs     distribution.BlobStore
+	manifests distribution.ManifestService
+
+	// loaded holds the manifests read from the layout
+	loaded map[digest.Digest]distribution.Manifest
+
+	// blobRefs holds the blobs referenced by the loaded manifests
+	blobRefs map[digest.Digest]distribution.Descriptor
+
+	// put holds the manifests put in the repository
+	put map[digest.Digest]struct{}
+}
+
+// load reads the given manifests and the manifests they reference from the
+// layout, one level of indexes at a time, and records the blobs they
+// reference.
+func (i *imageLayoutImporter) load(ctx context.Context, roots []importRoot) error {
+	pending := make(map[digest.Digest]string)
+	for _, root := range roots {
+		pending[root.Digest] = root.MediaType
+	}
+
+	for len(pending) > 0 {
+		names := make(map[string]struct{}, len(pending))
+		for dgst := range pending {
+			names[imageLayoutBlobPath(dgst)] = struct{}{}
+		}
+
+		next := make(map[digest.Digest]string)
+		err := i.r.ReadFiles(names, func(name string, r io.Reader) error {
+			dgst, err := imageLayoutBlobDigest(name)
+			if err != nil {
+				return err
+			}
+
+			payload, err := ioutil.ReadAll(io.LimitReader(r, maxImageLayoutManifestSize+1))
+			if err != nil {
+				return err
+			}
+			if len(payload) > maxImageLayoutManifestSize {
+				return fmt.Errorf("manifest %s is too large", dgst)
+			}
+			if dgst.Validate() != nil || digest.FromBytes(payload) != dgst {
+				return fmt.Errorf("manifest %s does not match its digest", dgst)
+			}
+
+			manifest, _, err := distribution.UnmarshalManifest(imageLayoutMediaType(pending[dgst], payload), payload)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest %s: %v", dgst, err)
+			}
+			i.loaded[dgst] = manifest
+
+			switch manifest.(type) {
+			case *schema1.SignedManifest:
+				return fmt.Errorf("schema1 manifests cannot be imported from an image layout")
+			case *manifestlist.DeserializedManifestList:
+				for _, child := range manifest.References() {
+					if _, ok := i.loaded[child.Digest]; !ok {
+						next[child.Digest] = child.MediaType
+					}
+				}
+			default:
+				for _, ref := range manifest.References() {
+					i.blobRefs[ref.Digest] = ref
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for dgst := range pending {
+			if _, ok := i.loaded[dgst]; !ok {
+				return fmt.Errorf("manifest %s is missing from the image layout", dgst)
+			}
+		}
+		pending = next
+	}
+	return nil
+}
+
+// ingestBlobs writes the blobs referenced by the loaded manifests, which are
+// missing from the registry, to the repository.
+func (i *imageLayoutImporter) ingestBlobs(ctx context.Context) error {
+	names := make(map[string]struct{})
+	for dgst := range i.blobRefs {
+		if _, err := i.blobs.Stat(ctx, dgst); err == nil {
+			delete(i.blobRefs, dgst)
+			continue
+		} else if err != distribution.ErrBlobUnknown {
+			return err
+		}
+		names[imageLayoutBlobPath(dgst)] = struct{}{}
+	}
+
+	err := i.r.ReadFiles(names, func(name string, r io.Reader) error {
+		dgst, err := imageLayoutBlobDigest(name)
+		if err != nil {
+			return err
+		}
+		ref := i.blobRefs[dgst]
+
+		dcontext.GetLogger(ctx).Infof("importing blob %s", dgst)
+		writer, err := i.blobs.Create(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(writer, r); err != nil {
+			writer.Cancel(ctx)
+			return err
+		}
+		if _, err := writer.Commit(ctx, distribution.Descriptor{
+			MediaType: ref.MediaType,
+			Digest:    dgst,
+			Size:      ref.Size,
+		}); err != nil {
+			writer.Cancel(ctx)
+			return fmt.Errorf("failed to import blob %s: %v", dgst, err)
+		}
+
+		delete(i.blobRefs, dgst)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// blobs with URLs may be left out of a layout, as they are out of a
+	// registry
+	for dgst, ref := range i.blobRefs {
+		if len(ref.URLs) == 0 {
+			return fmt.Errorf("blob %s is missing from the image layout", dgst)
+		}
+	}
+	return nil
+}
+
+// putManifest puts the loaded manifest with the given digest in the
+// repository, after the manifests it references.
+func (i *imageLayoutImporter) putManifest(ctx context.Context, dgst digest.Digest) error {
+	if _, ok := i.put[dgst]; ok {
+		return nil
+	}
+
+	manifest := i.loaded[dgst]
+	if _, ok := manifest.(*manifestlist.DeserializedManifestList); ok {
+		for _, child := range manifest.References() {
+			if err := i.putManifest(ctx, child.Digest); err != nil {
+				return err
+			}
+		}
+	}
+
+	put, err := i.manifests.Put(ctx, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to import manifest %s: %v", dgst, err)
+	}
+	if put != dgst {
+		return fmt.Errorf("manifest %s was imported as %s", dgst, put)
+	}
+
+	i.put[dgst] = struct{}{}
+	return nil
+}
+
+// readImageLayoutIndex checks the version of the layout and returns its
+// index.json.
+func readImageLayoutIndex(r imageLayoutReader) (v1.Index, error) {
+	var layout v1.ImageLayout
+	var index v1.Index
+	found := make(map[string]bool)
+
+	err := r.ReadFiles(map[string]struct{}{v1.ImageLayoutFile: {}, "index.json": {}}, func(name string, r io.Reader) error {
+		found[name] = true
+		decoder := json.NewDecoder(io.LimitReader(r, maxImageLayoutManifestSize))
+		if name == v1.ImageLayoutFile {
+			return decoder.Decode(&layout)
+		}
+		return decoder.Decode(&index)
+	})
+	if err != nil {
+		return index, fmt.Errorf("failed to read image layout: %v", err)
+	}
+
+	if !found[v1.ImageLayoutFile] || !found["index.json"] {
+		return index, fmt.Errorf("not an image layout: %s or index.json missing", v1.ImageLayoutFile)
+	}
+	if layout.Version != v1.ImageLayoutVersion {
+		return index, fmt.Errorf("unsupported image layout version %q", layout.Version)
+	}
+	return index, nil
+}
+
+// imageLayoutMediaType returns the media type of a manifest, from its
+// descriptor or else from its payload. Image manifests may omit their media
+// type.
+func imageLayoutMediaType(mediaType string, payload []byte) string {
+	if mediaType != "" {
+		return mediaType
+	}
+
+	var versioned struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(payload, &versioned); err == nil && versioned.MediaType != "" {
+		return versioned.MediaType
+	}
+	return v1.MediaTypeImageManifest
+}
+
+// imageLayoutBlobPath returns the path of a blob in an image layout.
+func imageLayoutBlobPath(dgst digest.Digest) string {
+	return path.Join("blobs", dgst.Algorithm().String(), dgst.Hex())
+}
+
+// imageLayoutBlobDigest returns the digest of the blob at a path of an image
+// layout.
+func imageLayoutBlobDigest(name string) (digest.Digest, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != "blobs" {
+		return "", fmt.Errorf("invalid blob path %s", name)
+	}
+
+	dgst := digest.NewDigestFromHex(parts[1], parts[2])
+	return dgst, dgst.Validate()
+}
+
+// imageLayoutWriter writes the files of an image layout.
+type imageLayoutWriter interface {
+	// WriteFile writes the size bytes read from r to the file at name.
+	WriteFile(name string, size int64, r io.Reader) error
+
+	// Close completes the layout.
+	Close() error
+}
+
+// newImageLayoutWriter returns a writer of a tar archive if target ends with
+// .tar, and of a directory otherwise.
+func newImageLayoutWriter(target string) (imageLayoutWriter, error) {
+	if strings.HasSuffix(target, ".tar") {
+		file, err := os.Create(target)
+		if err != nil {
+			return nil, err
+		}
+		return &tarImageLayoutWriter{file: file, tw: tar.NewWriter(file)}, nil
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, err
+	}
+	return dirImageLayoutWriter(target), nil
+}
+
+// dirImageLayoutWriter writes an image layout to a directory.
+type dirImageLayoutWriter string
+
+func (dir dirImageLayoutWriter) WriteFile(name string, size int64, r io.Reader) error {
+	filePath := filepath.Join(string(dir), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if n != size {
+		file.Close()
+		return fmt.Errorf("unexpected size of %s: %d != %d", name, n, size)
+	}
+	return file.Close()
+}
+
+func (dir dirImageLayoutWriter) Close() error {
+	return nil
+}
+
+// tarImageLayoutWriter writes an image layout to a tar archive.
+type tarImageLayoutWriter struct {
+	file *os.File
+	tw   *tar.Writer
+}
+
+func (w *tarImageLayoutWriter) WriteFile(name string, size int64, r io.Reader) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarImageLayoutWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// imageLayoutReader reads the files of an image layout.
+type imageLayoutReader interface {
+	// ReadFiles calls fn with the content of the files of the layout whose
+	// names are in names, in any order. Missing files are ignored.
+	ReadFiles(names map[string]struct{}, fn func(name string, r io.Reader) error) error
+
+	// Close releases the layout.
+	Close() error
+}
+
+// newImageLayoutReader returns a reader of the directory or tar archive at
+// source.
+func newImageLayoutReader(source string) (imageLayoutReader, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return dirImageLayoutReader(source), nil
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &tarImageLayoutReader{file: file}, nil
+}
+
+// dirImageLayoutReader reads an image layout from a directory.
+type dirImageLayoutReader string
+
+func (dir dirImageLayoutReader) ReadFiles(names map[string]struct{}, fn func(name string, r io.Reader) error) error {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		file, err := os.Open(filepath.Join(string(dir), filepath.FromSlash(name)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		err = fn(name, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dir dirImageLayoutReader) Close() error {
+	return nil
+}
+
+// tarImageLayoutReader reads an image layout from a tar archive, going
+// through the whole archive for every set of files read.
+type tarImageLayoutReader struct {
+	file *os.File
+}
+
+func (r *tarImageLayoutReader) ReadFiles(names map[string]struct{}, fn func(name string, r io.Reader) error) error {
+	if len(names) == 0 {
+		return nil
+	}
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r.file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		if _, ok := names[name]; !ok {
+			continue
+		}
+
+		if err := fn(name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *tarImageLayoutReader) Close() error {
+	return r.file.Close()
+}
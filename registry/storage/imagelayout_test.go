@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func parseImageLayoutReference(t *testing.T, s string) reference.Named {
+	ref, err := reference.Parse(s)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", s, err)
+	}
+	return ref.(reference.Named)
+}
+
+func TestImageLayoutExportImport(t *testing.T) {
+	ctx := context.Background()
+
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "exported")
+	blobs := repo.Blobs(ctx)
+	manifests := makeManifestService(t, repo)
+
+	// a docker schema2 image
+	layer, err := blobs.Put(ctx, v1.MediaTypeImageLayer, []byte("docker layer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema2Manifest, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{layer.Digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema2Digest, err := manifests.Put(ctx, schema2Manifest)
+	if err != nil {
+		t.Fatalf("failed to put manifest: %v", err)
+	}
+
+	// an OCI image with annotations in an OCI index
+	ociLayer, err := blobs.Put(ctx, v1.MediaTypeImageLayerGzip, []byte("oci layer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ociLayer.MediaType = v1.MediaTypeImageLayerGzip
+	builder := ocischema.NewManifestBuilder(blobs, []byte("{}"), map[string]string{"hot": "potato"})
+	if err := builder.AppendReference(ociLayer); err != nil {
+		t.Fatal(err)
+	}
+	ociManifest, err := builder.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ociDigest, err := manifests.Put(ctx, ociManifest)
+	if err != nil {
+		t.Fatalf("failed to put manifest: %v", err)
+	}
+	_, ociPayload, err := ociManifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{{
+		Descriptor: distribution.Descriptor{
+			MediaType:   v1.MediaTypeImageManifest,
+			Digest:      ociDigest,
+			Size:        int64(len(ociPayload)),
+			Annotations: map[string]string{"lettuce": "wrap"},
+		},
+		Platform: manifestlist.PlatformSpec{Architecture: "amd64", OS: "linux"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := manifests.Put(ctx, index)
+	if err != nil {
+		t.Fatalf("failed to put index: %v", err)
+	}
+
+	if err := repo.Tags(ctx).Tag(ctx, "schema2", distribution.Descriptor{Digest: schema2Digest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "oci", distribution.Descriptor{Digest: indexDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "imagelayout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// every tag is exported without a tag in the reference
+	layoutDir := filepath.Join(dir, "layout")
+	if err := ExportImageLayout(ctx, registry, parseImageLayoutReference(t, "exported"), layoutDir); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(layoutDir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layoutIndex v1.Index
+	if err := json.Unmarshal(content, &layoutIndex); err != nil {
+		t.Fatal(err)
+	}
+	refNames := make(map[string]digest.Digest)
+	for _, desc := range layoutIndex.Manifests {
+		refNames[desc.Annotations[v1.AnnotationRefName]] = desc.Digest
+	}
+	expected := map[string]digest.Digest{"schema2": schema2Digest, "oci": indexDigest}
+	if !reflect.DeepEqual(refNames, expected) {
+		t.Fatalf("unexpected index.json manifests: %v != %v", refNames, expected)
+	}
+	if _, err := os.Stat(filepath.Join(layoutDir, "oci-layout")); err != nil {
+		t.Fatalf("missing oci-layout: %v", err)
+	}
+
+	importedDriver := inmemory.New()
+	imported := createRegistry(t, importedDriver)
+	if err := ImportImageLayout(ctx, imported, layoutDir, parseImageLayoutReference(t, "imported"), ImportOpts{}); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	importedRepo := makeRepository(t, imported, "imported")
+	tags, err := importedRepo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"oci", "schema2"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	importedManifest, err := makeManifestService(t, importedRepo).Get(ctx, ociDigest)
+	if err != nil {
+		t.Fatalf("failed to get imported manifest: %v", err)
+	}
+	if annotation := importedManifest.(*ocischema.DeserializedManifest).Annotations["hot"]; annotation != "potato" {
+		t.Fatalf("annotation not preserved: %q", annotation)
+	}
+	importedIndex, err := makeManifestService(t, importedRepo).Get(ctx, indexDigest)
+	if err != nil {
+		t.Fatalf("failed to get imported index: %v", err)
+	}
+	if annotation := importedIndex.References()[0].Annotations["lettuce"]; annotation != "wrap" {
+		t.Fatalf("annotation not preserved: %q", annotation)
+	}
+
+	// a single tag is exported to a tar archive, and imported under another
+	layoutTar := filepath.Join(dir, "layout.tar")
+	if err := ExportImageLayout(ctx, registry, parseImageLayoutReference(t, "exported:schema2"), layoutTar); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	if err := ImportImageLayout(ctx, imported, layoutTar, parseImageLayoutReference(t, "renamed:latest"), ImportOpts{}); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	desc, err := makeRepository(t, imported, "renamed").Tags(ctx).Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("failed to get tag: %v", err)
+	}
+	if desc.Digest != schema2Digest {
+		t.Fatalf("unexpected digest: %s != %s", desc.Digest, schema2Digest)
+	}
+
+	// an existing tag is only overwritten with another manifest with Force
+	if err := ImportImageLayout(ctx, imported, layoutTar, parseImageLayoutReference(t, "renamed:latest"), ImportOpts{}); err != nil {
+		t.Fatalf("failed to import the manifest of an existing tag again: %v", err)
+	}
+
+	ociTar := filepath.Join(dir, "oci.tar")
+	if err := ExportImageLayout(ctx, registry, parseImageLayoutReference(t, "exported:oci"), ociTar); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	if err := ImportImageLayout(ctx, imported, ociTar, parseImageLayoutReference(t, "renamed:latest"), ImportOpts{}); err == nil {
+		t.Fatalf("expected an error importing over an existing tag")
+	}
+	if err := ImportImageLayout(ctx, imported, ociTar, parseImageLayoutReference(t, "renamed:latest"), ImportOpts{Force: true}); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	desc, err = makeRepository(t, imported, "renamed").Tags(ctx).Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("failed to get tag: %v", err)
+	}
+	if desc.Digest != indexDigest {
+		t.Fatalf("unexpected digest: %s != %s", desc.Digest, indexDigest)
+	}
+
+	checkReport, err := Check(ctx, importedDriver, imported, CheckOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkReport.Findings) != 0 {
+		t.Fatalf("unexpected findings: %v", checkReport.Findings)
+	}
+}
+
+func TestImageLayoutImportMissingBlob(t *testing.T) {
+	ctx := context.Background()
+
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "exported")
+
+	layer, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageLayer, []byte("layer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := testutil.MakeSchema2Manifest(repo, []digest.Digest{layer.Digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := makeManifestService(t, repo).Put(ctx, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "imagelayout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ExportImageLayout(ctx, registry, parseImageLayoutReference(t, "exported:latest"), dir); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, filepath.FromSlash(imageLayoutBlobPath(layer.Digest)))); err != nil {
+		t.Fatal(err)
+	}
+
+	imported := createRegistry(t, inmemory.New())
+	if err := ImportImageLayout(ctx, imported, dir, parseImageLayoutReference(t, "imported"), ImportOpts{}); err == nil {
+		t.Fatalf("expected an error importing a layout with a missing blob")
+	}
+
+	if _, err := makeRepository(t, imported, "imported").Tags(ctx).Get(ctx, "latest"); err == nil {
+		t.Fatalf("expected no tag after a failed import")
+	}
+}
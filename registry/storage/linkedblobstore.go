@@ -42,6 +42,10 @@ type linkedBlobStore struct {
 
 	// linkDirectoryPathSpec locates the root directories in which one might find links
 	linkDirectoryPathSpec pathSpec
+
+	// beforeLink, if set, is called before blobs are linked into the
+	// repository.
+	beforeLink func(ctx context.Context) error
 }
 
 var _ distribution.BlobStore = &linkedBlobStore{}
@@ -237,7 +241,7 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 	if err != nil {
 		return err
 	}
-	return lbs.driver.Walk(ctx, rootPath, func(fileInfo driver.FileInfo) error {
+	return lbs.blobStore.linkDriver().Walk(ctx, rootPath, func(fileInfo driver.FileInfo) error {
 		// exit early if directory...
 		if fileInfo.IsDir() {
 			return nil
@@ -338,6 +342,12 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 	// Don't make duplicate links.
 	seenDigests := make(map[digest.Digest]struct{}, len(dgsts))
 
+	if lbs.beforeLink != nil {
+		if err := lbs.beforeLink(ctx); err != nil {
+			return err
+		}
+	}
+
 	// only use the first link
 	linkPathFn := lbs.linkPathFns[0]
 
@@ -423,7 +433,7 @@ func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (er
 			return err
 		}
 
-		err = lbs.blobStore.driver.Delete(ctx, blobLinkPath)
+		err = lbs.blobStore.linkDriver().Delete(ctx, blobLinkPath)
 		if err != nil {
 			switch err := err.(type) {
 			case driver.PathNotFoundError:
@@ -0,0 +1,428 @@
+// Package bolt provides a metadata store backed by an embedded bbolt
+// database.
+//
+// Files are kept in a single bucket keyed by their path, so the files of a
+// directory are stored next to each other and directories exist only as long
+// as they hold files.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	bolt "go.etcd.io/bbolt"
+)
+
+const driverName = "bolt"
+
+// filesBucket holds every file, keyed by path. Values are the modification
+// time of the file, in nanoseconds, followed by its content.
+var filesBucket = []byte("files")
+
+// headerSize is the size of the modification time preceding file contents.
+const headerSize = 8
+
+type store struct {
+	db *bolt.DB
+}
+
+// baseEmbed allows us to hide the Base embed.
+type baseEmbed struct {
+	base.Base
+}
+
+// Store is a metadata store implementation backed by a bbolt database.
+type Store struct {
+	baseEmbed // embedded, hidden base driver.
+	db        *bolt.DB
+}
+
+var _ driver.StorageDriver = &Store{}
+
+// New opens, creating it if needed, the bbolt database at path. The database
+// is locked for as long as it is open, so that no other process can open it,
+// even read-only.
+func New(path string) (*Store, error) {
+	db, err := open(path, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(filesBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newStore(db), nil
+}
+
+// NewReadOnly opens the existing bbolt database at path for reading. Any
+// number of processes may open it read-only at the same time, but not while
+// it is opened by New.
+func NewReadOnly(path string) (*Store, error) {
+	// bbolt would create the missing file
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("unable to open metadata database %s: %v", path, err)
+	}
+
+	db, err := open(path, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(filesBucket) == nil {
+			return fmt.Errorf("metadata database %s is empty", path)
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newStore(db), nil
+}
+
+// open opens the bbolt database at path, explaining the failure to lock it.
+func open(path string, options *bolt.Options) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, options)
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("unable to open metadata database %s: it is in use by another process, such as a running registry", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open metadata database %s: %v", path, err)
+	}
+	return db, nil
+}
+
+func newStore(db *bolt.DB) *Store {
+	return &Store{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: &store{db: db},
+			},
+		},
+		db: db,
+	}
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Implement the storagedriver.StorageDriver interface.
+
+func (s *store) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (s *store) GetContent(ctx context.Context, path string) ([]byte, error) {
+	var content []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(filesBucket).Get([]byte(path))
+		if value == nil {
+			return driver.PathNotFoundError{Path: path}
+		}
+
+		content = append([]byte{}, value[headerSize:]...)
+		return nil
+	})
+	return content, err
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (s *store) PutContent(ctx context.Context, path string, content []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, path, content)
+	})
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (s *store) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, driver.InvalidOffsetError{Path: path, Offset: offset}
+	}
+
+	content, err := s.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return ioutil.NopCloser(bytes.NewReader(content[offset:])), nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" when closed.
+func (s *store) Writer(ctx context.Context, path string, append bool) (driver.FileWriter, error) {
+	w := &writer{
+		ctx:   ctx,
+		store: s,
+		path:  path,
+	}
+
+	if append {
+		content, err := s.GetContent(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		w.buf.Write(content)
+	}
+
+	return w, nil
+}
+
+// Stat returns info about the provided path.
+func (s *store) Stat(ctx context.Context, path string) (driver.FileInfo, error) {
+	fi := driver.FileInfoFields{
+		Path: path,
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(filesBucket).Cursor()
+
+		key, value := c.Seek([]byte(path))
+		if key != nil && string(key) == path {
+			fi.Size = int64(len(value) - headerSize)
+			fi.ModTime = modTime(value)
+			return nil
+		}
+
+		// a directory exists as long as files are stored under it
+		prefix := dirPrefix(path)
+		key, value = c.Seek([]byte(prefix))
+		if key == nil || !strings.HasPrefix(string(key), prefix) {
+			return driver.PathNotFoundError{Path: path}
+		}
+
+		fi.IsDir = true
+		fi.ModTime = modTime(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.FileInfoInternal{FileInfoFields: fi}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (s *store) List(ctx context.Context, path string) ([]string, error) {
+	var children []string
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(filesBucket).Cursor()
+		prefix := dirPrefix(path)
+
+		for key, _ := c.Seek([]byte(prefix)); key != nil && strings.HasPrefix(string(key), prefix); {
+			name := string(key[len(prefix):])
+			i := strings.IndexByte(name, '/')
+			if i < 0 {
+				// a file, possibly followed by siblings sharing its name
+				// as a prefix, such as "b-c" after "b"
+				children = append(children, prefix+name)
+				key, _ = c.Next()
+				continue
+			}
+
+			name = name[:i]
+			children = append(children, prefix+name)
+
+			// skip over the files of the child directory, '0' sorting
+			// right after '/'.
+			key, _ = c.Seek([]byte(prefix + name + "0"))
+		}
+
+		if len(children) == 0 && path != "/" {
+			return driver.PathNotFoundError{Path: path}
+		}
+		return nil
+	})
+
+	// files of directories whose names are prefixes of others, such as
+	// "b" and "b-c", are not stored in the order of the directories.
+	sort.Strings(children)
+
+	return children, err
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object.
+func (s *store) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(filesBucket)
+
+		value := b.Get([]byte(sourcePath))
+		if value == nil {
+			return driver.PathNotFoundError{Path: sourcePath}
+		}
+
+		if err := b.Put([]byte(destPath), append([]byte{}, value...)); err != nil {
+			return err
+		}
+		return b.Delete([]byte(sourcePath))
+	})
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+func (s *store) Delete(ctx context.Context, path string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(filesBucket)
+
+		var keys [][]byte
+		if b.Get([]byte(path)) != nil {
+			keys = append(keys, []byte(path))
+		}
+
+		c := b.Cursor()
+		prefix := dirPrefix(path)
+		for key, _ := c.Seek([]byte(prefix)); key != nil && strings.HasPrefix(string(key), prefix); key, _ = c.Next() {
+			keys = append(keys, append([]byte{}, key...))
+		}
+
+		if len(keys) == 0 {
+			return driver.PathNotFoundError{Path: path}
+		}
+
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at the given path.
+// May return an UnsupportedMethodErr in certain StorageDriver implementations.
+func (s *store) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "", driver.ErrUnsupportedMethod{}
+}
+
+// SetModTime sets the modification time of the file at "path".
+func (s *store) SetModTime(ctx context.Context, path string, modTime time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(filesBucket)
+		value := b.Get([]byte(path))
+		if value == nil {
+			return driver.PathNotFoundError{Path: path}
+		}
+
+		stamped := append([]byte{}, value...)
+		binary.BigEndian.PutUint64(stamped, uint64(modTime.UnixNano()))
+		return b.Put([]byte(path), stamped)
+	})
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file and directory
+func (s *store) Walk(ctx context.Context, path string, f driver.WalkFn) error {
+	return driver.WalkFallback(ctx, s, path, f)
+}
+
+// put stores content at path, stamped with the current time.
+func put(tx *bolt.Tx, path string, content []byte) error {
+	value := make([]byte, headerSize+len(content))
+	binary.BigEndian.PutUint64(value, uint64(time.Now().UnixNano()))
+	copy(value[headerSize:], content)
+
+	return tx.Bucket(filesBucket).Put([]byte(path), value)
+}
+
+// modTime returns the modification time of a stored value.
+func modTime(value []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+}
+
+// dirPrefix returns the prefix of the paths of the files under the directory.
+func dirPrefix(path string) string {
+	if strings.HasSuffix(path, "/") {
+		return path
+	}
+	return path + "/"
+}
+
+type writer struct {
+	ctx       context.Context
+	store     *store
+	path      string
+	buf       bytes.Buffer
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	return w.buf.Write(p)
+}
+
+func (w *writer) Size() int64 {
+	return int64(w.buf.Len())
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if w.cancelled {
+		return nil
+	}
+	return w.store.PutContent(w.ctx, w.path, w.buf.Bytes())
+}
+
+func (w *writer) Cancel() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+
+	err := w.store.Delete(w.ctx, w.path)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+func (w *writer) Commit() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	w.committed = true
+
+	return w.store.PutContent(w.ctx, w.path, w.buf.Bytes())
+}
@@ -0,0 +1,263 @@
+package bolt
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+func newTestStore(t *testing.T) *Store {
+	dir, err := ioutil.TempDir("", "bolt-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+		os.RemoveAll(dir)
+	})
+	return s
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	files := map[string]string{
+		"/a/b/link":       "ab",
+		"/a/b-c/link":     "abc",
+		"/a/bc/d/link":    "abcd",
+		"/a/bcd":          "bcd",
+		"/ab/current/tag": "x",
+		"/e/b":            "eb",
+		"/e/b-c":          "ebc",
+		"/e/b0/link":      "eb0",
+	}
+	for path, content := range files {
+		if err := s.PutContent(ctx, path, []byte(content)); err != nil {
+			t.Fatalf("unexpected error putting %s: %v", path, err)
+		}
+	}
+
+	for path, content := range files {
+		got, err := s.GetContent(ctx, path)
+		if err != nil {
+			t.Fatalf("unexpected error getting %s: %v", path, err)
+		}
+		if string(got) != content {
+			t.Errorf("unexpected content of %s: expected %q, got %q", path, content, got)
+		}
+	}
+
+	if _, err := s.GetContent(ctx, "/a/b"); err == nil {
+		t.Errorf("expected error getting the content of a directory")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Errorf("unexpected error getting the content of a directory: %v", err)
+	}
+
+	children, err := s.List(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/a/b", "/a/b-c", "/a/bc", "/a/bcd"}
+	if !reflect.DeepEqual(children, expected) {
+		t.Errorf("unexpected children of /a: expected %v, got %v", expected, children)
+	}
+
+	// files whose names are prefixes of their siblings' are not skipped
+	children, err = s.List(ctx, "/e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"/e/b", "/e/b-c", "/e/b0"}
+	if !reflect.DeepEqual(children, expected) {
+		t.Errorf("unexpected children of /e: expected %v, got %v", expected, children)
+	}
+
+	if _, err := s.List(ctx, "/missing"); err == nil {
+		t.Errorf("expected error listing a missing directory")
+	}
+
+	fi, err := s.Stat(ctx, "/a/bc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("expected /a/bc to be a directory")
+	}
+
+	fi, err = s.Stat(ctx, "/a/bcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.IsDir() || fi.Size() != 3 {
+		t.Errorf("unexpected file info of /a/bcd: directory %t, size %d", fi.IsDir(), fi.Size())
+	}
+
+	var walked []string
+	err = s.Walk(ctx, "/a", func(fileInfo storagedriver.FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"/a/b", "/a/b/link", "/a/b-c", "/a/b-c/link", "/a/bc", "/a/bc/d", "/a/bc/d/link", "/a/bcd"}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("unexpected walk of /a: expected %v, got %v", expected, walked)
+	}
+
+	if err := s.Delete(ctx, "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a/b-c/link", "/a/bc/d/link", "/a/bcd"} {
+		if _, err := s.GetContent(ctx, path); err != nil {
+			t.Errorf("expected %s to survive deleting /a/b: %v", path, err)
+		}
+	}
+	if _, err := s.Stat(ctx, "/a/b"); err == nil {
+		t.Errorf("expected /a/b to be deleted")
+	}
+
+	if err := s.Delete(ctx, "/a/b"); err == nil {
+		t.Errorf("expected error deleting a missing path")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Errorf("unexpected error deleting a missing path: %v", err)
+	}
+}
+
+func TestStoreWriter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	w, err := s.Writer(ctx, "/file", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello ")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = s.Writer(ctx, "/file", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := s.Reader(ctx, "/file", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "world" {
+		t.Errorf("unexpected content read at offset 6: %q", content)
+	}
+}
+
+func TestStoreSetModTime(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	if err := s.PutContent(ctx, "/a/link", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.SetModTime(ctx, "/a/link", modTime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := s.Stat(ctx, "/a/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(modTime) {
+		t.Errorf("unexpected modification time: %v", fi.ModTime())
+	}
+	content, err := s.GetContent(ctx, "/a/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a" {
+		t.Errorf("unexpected content: %q", content)
+	}
+
+	if _, ok := s.SetModTime(ctx, "/b/link", modTime).(storagedriver.PathNotFoundError); !ok {
+		t.Errorf("expected PathNotFoundError setting the modification time of a missing file")
+	}
+}
+
+func TestStoreReadOnly(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "bolt-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metadata.db")
+
+	if _, err := NewReadOnly(path); err == nil {
+		t.Fatal("expected error opening a missing database read-only")
+	}
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutContent(ctx, "/a/link", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// read-only stores may be opened at the same time
+	r1, err := NewReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := NewReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	content, err := r2.GetContent(ctx, "/a/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a" {
+		t.Fatalf("unexpected content: %q", content)
+	}
+
+	if err := r1.PutContent(ctx, "/b/link", []byte("b")); err == nil {
+		t.Fatal("expected error writing to a read-only store")
+	}
+}
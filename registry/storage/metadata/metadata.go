@@ -0,0 +1,51 @@
+// Package metadata provides stores for the repository metadata of a
+// registry: its tags, and the links of its manifest revisions and layers.
+//
+// Every link is a tiny file read through the storage driver, which costs a
+// round trip on object stores. A metadata store keeps these files in a
+// database instead, leaving blobs and uploads to the storage driver. Stores
+// are storage drivers themselves, so that the storage package reads and
+// writes links through the same paths and errors whichever holds them.
+package metadata
+
+import (
+	"fmt"
+	"io"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/metadata/bolt"
+)
+
+// Store is a storage driver holding repository metadata.
+type Store interface {
+	storagedriver.StorageDriver
+	io.Closer
+}
+
+// Create opens the metadata store described by the parameters of the
+// metadata storage configuration.
+func Create(parameters map[string]interface{}) (Store, error) {
+	return create(parameters, false)
+}
+
+// CreateReadOnly opens the metadata store described by the parameters of the
+// metadata storage configuration for reading only.
+func CreateReadOnly(parameters map[string]interface{}) (Store, error) {
+	return create(parameters, true)
+}
+
+func create(parameters map[string]interface{}, readOnly bool) (Store, error) {
+	switch database := parameters["database"]; database {
+	case "bolt":
+		path, ok := parameters["path"].(string)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("the bolt metadata database requires a path")
+		}
+		if readOnly {
+			return bolt.NewReadOnly(path)
+		}
+		return bolt.New(path)
+	default:
+		return nil, fmt.Errorf("unknown metadata database %v", database)
+	}
+}
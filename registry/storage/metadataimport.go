@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// ImportMetadata copies the tags and the manifest revision and layer links of
+// every repository from the storage driver into the metadata store, then
+// rebuilds the repository index and the tag reverse index of the imported
+// repositories. The imported files keep their modification time. Uploads are
+// left in the storage driver. The registry should not accept pushes while
+// this runs.
+func ImportMetadata(ctx context.Context, storageDriver driver.StorageDriver, store driver.StorageDriver) error {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	var imported int
+	err = storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			if path.Base(fileInfo.Path()) == "_uploads" {
+				return driver.ErrSkipDir
+			}
+			return nil
+		}
+
+		content, err := storageDriver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", fileInfo.Path(), err)
+		}
+		if err := store.PutContent(ctx, fileInfo.Path(), content); err != nil {
+			return fmt.Errorf("failed to import %s: %v", fileInfo.Path(), err)
+		}
+		if err := setModTime(ctx, store, fileInfo.Path(), fileInfo.ModTime()); err != nil {
+			return fmt.Errorf("failed to import %s: %v", fileInfo.Path(), err)
+		}
+
+		imported++
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	emit("%d files imported", imported)
+
+	registry, err := NewRegistry(ctx, storageDriver, MetadataStore(store))
+	if err != nil {
+		return err
+	}
+
+	if err := RebuildRepositoryIndex(ctx, registry); err != nil {
+		return err
+	}
+
+	return RebuildTagReverseIndex(ctx, registry)
+}
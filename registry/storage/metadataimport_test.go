@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestImportMetadata(t *testing.T) {
+	env := setupFS(t)
+
+	named, err := reference.WithName("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := env.registry.Repository(env.ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(env.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dgst digest.Digest
+	err = manifests.(distribution.ManifestEnumerator).Enumerate(env.ctx, func(d digest.Digest) error {
+		dgst = d
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(env.ctx).Tag(env.ctx, "latest", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+
+	store := inmemory.New()
+	if err := ImportMetadata(env.ctx, env.driver, store); err != nil {
+		t.Fatalf("unexpected error importing metadata: %v", err)
+	}
+
+	registry, err := NewRegistry(env.ctx, env.driver, MetadataStore(store), BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider()), EnableRedirect, EnableSchema1)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	// the links and tags must now be read from the metadata store alone
+	for _, repo := range env.expected {
+		if err := env.driver.Delete(env.ctx, "/docker/registry/v2/repositories/"+repo+"/_manifests"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := make([]string, 50)
+	n, _ := registry.Repositories(env.ctx, p, "")
+	if !testEq(p, env.expected, n) || n != len(env.expected) {
+		t.Errorf("unexpected catalog after import: expected %v, got %v", env.expected, p[:n])
+	}
+
+	repo, err = registry.Repository(env.ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := repo.Tags(env.ctx).Get(env.ctx, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting imported tag: %v", err)
+	}
+	if desc.Digest != dgst {
+		t.Errorf("unexpected digest of imported tag: expected %s, got %s", dgst, desc.Digest)
+	}
+
+	tags, err := repo.Tags(env.ctx).Lookup(env.ctx, distribution.Descriptor{Digest: dgst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != "latest" {
+		t.Errorf("unexpected tags of imported manifest: %v", tags)
+	}
+
+	if err := repo.Tags(env.ctx).Tag(env.ctx, "new", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+	tagPath, err := pathFor(manifestTagCurrentPathSpec{name: "test", tag: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(env.ctx, tagPath); err != nil {
+		t.Errorf("expected new tag in the metadata store: %v", err)
+	}
+	if _, err := env.driver.Stat(env.ctx, tagPath); err == nil {
+		t.Errorf("expected new tag not to be written to the storage driver")
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
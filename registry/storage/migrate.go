@@ -0,0 +1,469 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// MigrateOpts contains options for the storage migration
+type MigrateOpts struct {
+	// Parallelism is the number of files copied concurrently.
+	Parallelism int
+
+	// Checkpoint is the path of a local file recording the blobs verified on
+	// the destination, so that an interrupted migration resumes without
+	// verifying them again.
+	Checkpoint string
+
+	// Prune removes the tags, links and repositories of the destination
+	// which are missing from the source, so that a second pass before
+	// cutover also carries deletions.
+	Prune bool
+}
+
+// MigrateReport counts the files handled by a storage migration.
+type MigrateReport struct {
+	BlobsCopied  int
+	BlobsSkipped int
+	FilesCopied  int
+	FilesSkipped int
+	FilesPruned  int
+}
+
+// migrator holds the state of a migration.
+type migrator struct {
+	from, to           driver.StorageDriver
+	fromLinks, toLinks driver.StorageDriver
+	opts               MigrateOpts
+	checkpoint         *migrationCheckpoint
+
+	mu      sync.Mutex
+	sources map[string]struct{}
+	report  MigrateReport
+}
+
+// Migrate copies the blobs, repositories, tags and links of the source
+// registry to the destination registry. Blobs are copied first and verified
+// against their digest on the destination, then the repository metadata is
+// copied from the link driver of the source, which is its metadata store if
+// it has one, to the link driver of the destination. Uploads are not copied.
+//
+// Blobs already verified on the destination and metadata files holding the
+// same content are skipped, so running Migrate again copies only what
+// changed since the previous pass. The source registry should be in
+// read-only mode for the last pass.
+func Migrate(ctx context.Context, fromDriver driver.StorageDriver, from distribution.Namespace, toDriver driver.StorageDriver, to distribution.Namespace, opts MigrateOpts) (MigrateReport, error) {
+	fromLinks, toLinks := linkDriverOf(from), linkDriverOf(to)
+	if fromLinks == nil || toLinks == nil {
+		return MigrateReport{}, fmt.Errorf("unable to convert Namespace to a storage registry")
+	}
+
+	if opts.Parallelism < 1 {
+		opts.Parallelism = 1
+	}
+
+	checkpoint, err := openMigrationCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return MigrateReport{}, fmt.Errorf("failed to open checkpoint: %v", err)
+	}
+	defer checkpoint.Close()
+
+	m := &migrator{
+		from:       fromDriver,
+		to:         toDriver,
+		fromLinks:  fromLinks,
+		toLinks:    toLinks,
+		opts:       opts,
+		checkpoint: checkpoint,
+		sources:    make(map[string]struct{}),
+	}
+
+	blobsRoot, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return m.report, err
+	}
+
+	// blobs go first, so that the links copied next never dangle
+	if err := m.walkFiles(ctx, m.from, blobsRoot, nil, m.migrateBlob); err != nil {
+		return m.report, fmt.Errorf("failed to migrate blobs: %v", err)
+	}
+
+	root := path.Join(storagePathRoot, storagePathVersion)
+	skipDir := func(dirPath string) bool {
+		return dirPath == blobsRoot || path.Base(dirPath) == "_uploads"
+	}
+
+	err = m.walkFiles(ctx, m.fromLinks, root, func(dirPath string) bool {
+		if skipDir(dirPath) {
+			return true
+		}
+		m.addSource(dirPath)
+		return false
+	}, m.migrateFile)
+	if err != nil {
+		return m.report, fmt.Errorf("failed to migrate repositories: %v", err)
+	}
+
+	if opts.Prune {
+		if err := m.prune(ctx, root, skipDir); err != nil {
+			return m.report, fmt.Errorf("failed to prune repositories: %v", err)
+		}
+	}
+
+	return m.report, nil
+}
+
+// walkFiles walks root in d, calling fn on every file from up to
+// Parallelism goroutines. Directories for which skipDir returns true are not
+// walked.
+func (m *migrator) walkFiles(ctx context.Context, d driver.StorageDriver, root string, skipDir func(string) bool, fn func(context.Context, driver.FileInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	files := make(chan driver.FileInfo)
+	errs := make(chan error, m.opts.Parallelism)
+
+	var wg sync.WaitGroup
+	for i := 0; i < m.opts.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileInfo := range files {
+				if err := fn(ctx, fileInfo); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	err := d.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			if skipDir != nil && skipDir(fileInfo.Path()) {
+				return driver.ErrSkipDir
+			}
+			return nil
+		}
+
+		select {
+		case files <- fileInfo:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+	close(errs)
+
+	// the error of the first failed copy explains the cancellation of the
+	// walk and of the other copies
+	for err := range errs {
+		return err
+	}
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBlob copies the blob of a data file to the destination unless it is
+// already there, and verifies its digest.
+func (m *migrator) migrateBlob(ctx context.Context, fileInfo driver.FileInfo) error {
+	dataPath := fileInfo.Path()
+	dgst, err := digestFromPath(dataPath)
+	if err != nil {
+		return fmt.Errorf("invalid blob path %s: %v", dataPath, err)
+	}
+	if !dgst.Algorithm().Available() {
+		return fmt.Errorf("unsupported digest algorithm of %s", dgst)
+	}
+
+	if m.checkpoint.verified(dgst) {
+		m.count(&m.report.BlobsSkipped)
+		return nil
+	}
+
+	fromInfo, err := m.from.Stat(ctx, dataPath)
+	if err != nil {
+		return err
+	}
+
+	// blobs are immutable, so a blob of the same size on the destination
+	// only needs to be verified
+	toInfo, err := m.to.Stat(ctx, dataPath)
+	if err == nil && toInfo.Size() == fromInfo.Size() {
+		verified, err := verifyBlobData(ctx, m.to, dataPath, dgst)
+		if err != nil {
+			return err
+		}
+		if verified {
+			m.count(&m.report.BlobsSkipped)
+			return m.checkpoint.add(dgst)
+		}
+	} else if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	dcontext.GetLogger(ctx).Infof("copying blob %s", dgst)
+	if err := copyFile(ctx, m.from, m.to, dataPath); err != nil {
+		return fmt.Errorf("failed to copy blob %s: %v", dgst, err)
+	}
+
+	verified, err := verifyBlobData(ctx, m.to, dataPath, dgst)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return fmt.Errorf("blob %s does not match its digest on the destination", dgst)
+	}
+
+	m.count(&m.report.BlobsCopied)
+	return m.checkpoint.add(dgst)
+}
+
+// migrateFile copies a metadata file to the destination unless the
+// destination holds the same content. The copy keeps the modification time of
+// the source, which dates the pushes described by Describe, when the
+// destination can set it.
+func (m *migrator) migrateFile(ctx context.Context, fileInfo driver.FileInfo) error {
+	filePath := fileInfo.Path()
+	m.addSource(filePath)
+
+	content, err := m.fromLinks.GetContent(ctx, filePath)
+	if err != nil {
+		return err
+	}
+
+	existing, err := m.toLinks.GetContent(ctx, filePath)
+	if err == nil && bytes.Equal(existing, content) {
+		m.count(&m.report.FilesSkipped)
+		return nil
+	} else if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	if err := m.toLinks.PutContent(ctx, filePath, content); err != nil {
+		return err
+	}
+	if err := setModTime(ctx, m.toLinks, filePath, fileInfo.ModTime()); err != nil {
+		return err
+	}
+
+	m.count(&m.report.FilesCopied)
+	return nil
+}
+
+// prune removes the files and directories of the destination under root
+// which were not found in the source.
+func (m *migrator) prune(ctx context.Context, root string, skipDir func(string) bool) error {
+	var stale []string
+	err := m.toLinks.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() && skipDir(fileInfo.Path()) {
+			return driver.ErrSkipDir
+		}
+
+		if _, ok := m.sources[fileInfo.Path()]; ok {
+			return nil
+		}
+
+		stale = append(stale, fileInfo.Path())
+		if fileInfo.IsDir() {
+			return driver.ErrSkipDir
+		}
+		// returning ErrSkipDir for a file would end the walk
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	for _, stalePath := range stale {
+		dcontext.GetLogger(ctx).Infof("removing %s", stalePath)
+		if err := m.toLinks.Delete(ctx, stalePath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+		m.report.FilesPruned++
+	}
+	return nil
+}
+
+// addSource records a path found in the source.
+func (m *migrator) addSource(sourcePath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources[sourcePath] = struct{}{}
+}
+
+// count increments a counter of the report.
+func (m *migrator) count(counter *int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*counter++
+}
+
+// setModTime sets the modification time of the file at filePath in d, unless
+// d cannot set it.
+func setModTime(ctx context.Context, d driver.StorageDriver, filePath string, modTime time.Time) error {
+	setter, ok := d.(driver.ModTimeSetter)
+	if !ok {
+		return nil
+	}
+
+	err := setter.SetModTime(ctx, filePath, modTime)
+	if _, ok := err.(driver.ErrUnsupportedMethod); ok {
+		return nil
+	}
+	return err
+}
+
+// copyFile streams the file at filePath from one driver to the other.
+func copyFile(ctx context.Context, from, to driver.StorageDriver, filePath string) error {
+	reader, err := from.Reader(ctx, filePath, 0)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := to.Writer(ctx, filePath, false)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Cancel()
+		writer.Close()
+		return err
+	}
+	if err := writer.Commit(); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// verifyBlobData returns whether the data file at dataPath matches dgst.
+func verifyBlobData(ctx context.Context, d driver.StorageDriver, dataPath string, dgst digest.Digest) (bool, error) {
+	reader, err := d.Reader(ctx, dataPath, 0)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(verifier, reader); err != nil {
+		return false, err
+	}
+	return verifier.Verified(), nil
+}
+
+// migrationCheckpoint records the blobs verified on the destination in a
+// local file, one digest per line.
+type migrationCheckpoint struct {
+	mu    sync.Mutex
+	file  *os.File
+	blobs map[digest.Digest]struct{}
+}
+
+// openMigrationCheckpoint reads the blobs recorded in the checkpoint file at
+// checkpointPath, creating it if missing. The checkpoint is only kept in
+// memory if checkpointPath is empty.
+func openMigrationCheckpoint(checkpointPath string) (*migrationCheckpoint, error) {
+	c := &migrationCheckpoint{blobs: make(map[digest.Digest]struct{})}
+	if checkpointPath == "" {
+		return c, nil
+	}
+
+	file, err := os.OpenFile(checkpointPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// the last line may have been cut short by an interruption
+		dgst, err := digest.Parse(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			continue
+		}
+		c.blobs[dgst] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// start on a new line if the last one was cut short
+	if info, err := file.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	} else if info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if last[0] != '\n' {
+			if _, err := file.Write([]byte("\n")); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+
+	c.file = file
+	return c, nil
+}
+
+// verified returns whether the blob is recorded in the checkpoint.
+func (c *migrationCheckpoint) verified(dgst digest.Digest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.blobs[dgst]
+	return ok
+}
+
+// add records the blob in the checkpoint.
+func (c *migrationCheckpoint) add(dgst digest.Digest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blobs[dgst] = struct{}{}
+	if c.file == nil {
+		return nil
+	}
+	_, err := fmt.Fprintln(c.file, dgst)
+	return err
+}
+
+// Close closes the checkpoint file.
+func (c *migrationCheckpoint) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	fromDriver := inmemory.New()
+	from := createRegistry(t, fromDriver)
+	fromRepo := makeRepository(t, from, "migrated")
+
+	image1 := uploadRandomSchema2Image(t, fromRepo)
+	image2 := uploadRandomSchema2Image(t, fromRepo)
+	if err := fromRepo.Tags(ctx).Tag(ctx, "first", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag: %v", err)
+	}
+	if err := fromRepo.Tags(ctx).Tag(ctx, "second", distribution.Descriptor{Digest: image2.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag: %v", err)
+	}
+
+	// the destination keeps its links in a metadata store
+	toDriver := inmemory.New()
+	to := createRegistry(t, toDriver, MetadataStore(inmemory.New()))
+
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "checkpoint")
+
+	opts := MigrateOpts{Parallelism: 4, Checkpoint: checkpoint}
+	report, err := Migrate(ctx, fromDriver, from, toDriver, to, opts)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	blobs := allBlobs(t, from)
+	if report.BlobsCopied != len(blobs) || report.BlobsSkipped != 0 {
+		t.Fatalf("unexpected blob counts: %+v", report)
+	}
+	if !reflect.DeepEqual(allBlobs(t, to), blobs) {
+		t.Fatalf("blobs not migrated")
+	}
+
+	toRepo := makeRepository(t, to, "migrated")
+	tags, err := toRepo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"first", "second"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+	if _, err := makeManifestService(t, toRepo).Get(ctx, image1.manifestDigest); err != nil {
+		t.Fatalf("failed to get migrated manifest: %v", err)
+	}
+
+	// the copies keep the modification times which date the last push
+	fromDescription, err := from.(distribution.RepositoryDescriber).Describe(ctx, fromRepo.Named())
+	if err != nil {
+		t.Fatalf("failed to describe: %v", err)
+	}
+	toDescription, err := to.(distribution.RepositoryDescriber).Describe(ctx, toRepo.Named())
+	if err != nil {
+		t.Fatalf("failed to describe: %v", err)
+	}
+	if !toDescription.LastPush.Equal(fromDescription.LastPush) {
+		t.Fatalf("unexpected last push: %v != %v", toDescription.LastPush, fromDescription.LastPush)
+	}
+
+	checkReport, err := Check(ctx, toDriver, to, CheckOpts{})
+	if err != nil {
+		t.Fatalf("failed to check: %v", err)
+	}
+	if len(checkReport.Findings) != 0 {
+		t.Fatalf("unexpected findings: %v", checkReport.Findings)
+	}
+
+	// the second pass carries the changes made since the first one
+	image3 := uploadRandomSchema2Image(t, fromRepo)
+	if err := fromRepo.Tags(ctx).Tag(ctx, "first", distribution.Descriptor{Digest: image3.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag: %v", err)
+	}
+	if err := fromRepo.Tags(ctx).Untag(ctx, "second"); err != nil {
+		t.Fatalf("failed to untag: %v", err)
+	}
+
+	opts.Prune = true
+	report, err = Migrate(ctx, fromDriver, from, toDriver, to, opts)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if report.BlobsCopied != len(allBlobs(t, from))-len(blobs) || report.BlobsSkipped != len(blobs) {
+		t.Fatalf("unexpected blob counts: %+v", report)
+	}
+	if report.FilesPruned == 0 {
+		t.Fatalf("expected the removed tag to be pruned: %+v", report)
+	}
+
+	tags, err = toRepo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"first"}) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+	desc, err := toRepo.Tags(ctx).Get(ctx, "first")
+	if err != nil {
+		t.Fatalf("failed to get tag: %v", err)
+	}
+	if desc.Digest != image3.manifestDigest {
+		t.Fatalf("unexpected tag digest: %s != %s", desc.Digest, image3.manifestDigest)
+	}
+}
+
+func TestMigrateRecopiesCorruptBlobs(t *testing.T) {
+	ctx := context.Background()
+
+	fromDriver := inmemory.New()
+	from := createRegistry(t, fromDriver)
+	image := uploadRandomSchema2Image(t, makeRepository(t, from, "corrupt"))
+
+	toDriver := inmemory.New()
+	to := createRegistry(t, toDriver)
+
+	if _, err := Migrate(ctx, fromDriver, from, toDriver, to, MigrateOpts{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	// without a checkpoint, blobs on the destination are verified again
+	dataPath, err := pathFor(blobDataPathSpec{digest: image.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := toDriver.GetContent(ctx, dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[0] ^= 0xff
+	if err := toDriver.PutContent(ctx, dataPath, content); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Migrate(ctx, fromDriver, from, toDriver, to, MigrateOpts{})
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if report.BlobsCopied != 1 {
+		t.Fatalf("expected the corrupt blob to be copied again: %+v", report)
+	}
+
+	if _, err := makeManifestService(t, makeRepository(t, to, "corrupt")).Get(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed to get migrated manifest: %v", err)
+	}
+}
@@ -34,6 +34,8 @@ const (
 //							-> current/link
 // 							-> index
 //								-> <algorithm>/<hex digest>/link
+// 						tagsbydigest/<algorithm>/<hex digest>
+//							-> <tag>/link
 // 					-> _layers/
 // 						<layer links to blob store>
 // 					-> _uploads/<id>
@@ -42,6 +44,12 @@ const (
 // 						hashstates/<algorithm>/<offset>
 //			-> blob/<algorithm>
 //				<split directory content addressable storage>
+//			-> repositoryindex/
+//				_complete
+//				<name>/_repository
+//			-> credentials/<id>
+//				data
+//				lastused
 //
 // The storage backend layout is broken up into a content-addressable blob
 // store and repositories. The content-addressable blob store holds most data
@@ -84,6 +92,13 @@ const (
 // 	manifestTagIndexEntryPathSpec:         <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/
 // 	manifestTagIndexEntryLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/link
 //
+//	Tag reverse index:
+//
+// 	manifestTagReverseIndexPathSpec:          <root>/v2/repositories/<name>/_manifests/tagsbydigest/
+// 	manifestTagReverseIndexCompletePathSpec:  <root>/v2/repositories/<name>/_manifests/tagsbydigest/complete
+// 	manifestTagReverseIndexEntriesPathSpec:   <root>/v2/repositories/<name>/_manifests/tagsbydigest/<algorithm>/<hex digest>/
+// 	manifestTagReverseIndexEntryLinkPathSpec: <root>/v2/repositories/<name>/_manifests/tagsbydigest/<algorithm>/<hex digest>/<tag>/link
+//
 // 	Blobs:
 //
 // 	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
@@ -95,6 +110,16 @@ const (
 // 	uploadStartedAtPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/startedat
 // 	uploadHashStatePathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/hashstates/<algorithm>/<offset>
 //
+//	Repository index:
+//
+// 	repositoryIndexPathSpec:          <root>/v2/repositoryindex/
+// 	repositoryIndexCompletePathSpec:  <root>/v2/repositoryindex/_complete
+// 	repositoryIndexEntryPathSpec:     <root>/v2/repositoryindex/<name>/_repository
+//
+//	Credentials:
+//
+// 	credentialsPathSpec:  <root>/v2/credentials/
+//
 //	Blob Store:
 //
 //	blobsPathSpec:                  <root>/v2/blobs/
@@ -193,6 +218,42 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, path.Join(components...)), nil
+	case manifestTagReverseIndexPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "tagsbydigest")...), nil
+	case manifestTagReverseIndexCompletePathSpec:
+		root, err := pathFor(manifestTagReverseIndexPathSpec(v))
+
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, "complete"), nil
+	case manifestTagReverseIndexEntriesPathSpec:
+		root, err := pathFor(manifestTagReverseIndexPathSpec{
+			name: v.name,
+		})
+
+		if err != nil {
+			return "", err
+		}
+
+		components, err := digestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...)), nil
+	case manifestTagReverseIndexEntryLinkPathSpec:
+		root, err := pathFor(manifestTagReverseIndexEntriesPathSpec{
+			name:     v.name,
+			revision: v.revision,
+		})
+
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, v.tag, "link"), nil
 	case layerLinkPathSpec:
 		components, err := digestPathComponents(v.digest, false)
 		if err != nil {
@@ -242,6 +303,14 @@ func pathFor(spec pathSpec) (string, error) {
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
 	case repositoriesRootPathSpec:
 		return path.Join(repoPrefix...), nil
+	case repositoryIndexPathSpec:
+		return path.Join(append(rootPrefix, "repositoryindex")...), nil
+	case repositoryIndexCompletePathSpec:
+		return path.Join(append(rootPrefix, "repositoryindex", "_complete")...), nil
+	case repositoryIndexEntryPathSpec:
+		return path.Join(append(rootPrefix, "repositoryindex", v.name, "_repository")...), nil
+	case credentialsPathSpec:
+		return path.Join(append(rootPrefix, "credentials")...), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
@@ -338,6 +407,44 @@ type manifestTagIndexEntryLinkPathSpec struct {
 
 func (manifestTagIndexEntryLinkPathSpec) pathSpec() {}
 
+// manifestTagReverseIndexPathSpec describes the root of the index mapping
+// manifest revisions to the tags currently pointing at them.
+type manifestTagReverseIndexPathSpec struct {
+	name string
+}
+
+func (manifestTagReverseIndexPathSpec) pathSpec() {}
+
+// manifestTagReverseIndexCompletePathSpec describes the file marking the tag
+// reverse index of a repository as complete. Until it is present, the index
+// may be missing tags created before it was introduced and must not be used
+// for lookups.
+type manifestTagReverseIndexCompletePathSpec struct {
+	name string
+}
+
+func (manifestTagReverseIndexCompletePathSpec) pathSpec() {}
+
+// manifestTagReverseIndexEntriesPathSpec describes the directory holding the
+// tags currently pointing at a revision.
+type manifestTagReverseIndexEntriesPathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (manifestTagReverseIndexEntriesPathSpec) pathSpec() {}
+
+// manifestTagReverseIndexEntryLinkPathSpec describes the link recording that
+// the tag points at the revision. The contents of this file should just be
+// the digest of the revision.
+type manifestTagReverseIndexEntryLinkPathSpec struct {
+	name     string
+	revision digest.Digest
+	tag      string
+}
+
+func (manifestTagReverseIndexEntryLinkPathSpec) pathSpec() {}
+
 // layersPathSpec contains the path for the layers inside a repo
 type layersPathSpec struct {
 	name string
@@ -436,6 +543,45 @@ type repositoriesRootPathSpec struct {
 
 func (repositoriesRootPathSpec) pathSpec() {}
 
+// repositoryIndexPathSpec describes the root of the repository index, which
+// mirrors the names of the repositories of the registry.
+type repositoryIndexPathSpec struct{}
+
+func (repositoryIndexPathSpec) pathSpec() {}
+
+// repositoryIndexCompletePathSpec describes the file marking the repository
+// index as holding every repository. The contents of this file should be the
+// time at which the index was completed.
+type repositoryIndexCompletePathSpec struct{}
+
+func (repositoryIndexCompletePathSpec) pathSpec() {}
+
+// repositoryIndexEntryPathSpec describes the file recording that the
+// repository exists. The contents of this file should be the time at which
+// the repository was indexed.
+type repositoryIndexEntryPathSpec struct {
+	name string
+}
+
+func (repositoryIndexEntryPathSpec) pathSpec() {}
+
+// credentialsPathSpec describes the directory holding robot accounts and
+// personal access tokens, one directory per credential.
+type credentialsPathSpec struct{}
+
+func (credentialsPathSpec) pathSpec() {}
+
+// CredentialsPath returns the storage driver path under which robot
+// accounts and personal access tokens are kept.
+func CredentialsPath() string {
+	p, err := pathFor(credentialsPathSpec{})
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
 // digestPathComponents provides a consistent path breakdown for a given
 // digest. For a generic digest, it will be as follows:
 //
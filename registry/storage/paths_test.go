@@ -68,6 +68,27 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tags/thetag/index/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/link",
 		},
+		{
+			spec: manifestTagReverseIndexCompletePathSpec{
+				name: "foo/bar",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tagsbydigest/complete",
+		},
+		{
+			spec: manifestTagReverseIndexEntriesPathSpec{
+				name:     "foo/bar",
+				revision: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tagsbydigest/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+		},
+		{
+			spec: manifestTagReverseIndexEntryLinkPathSpec{
+				name:     "foo/bar",
+				revision: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				tag:      "thetag",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tagsbydigest/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/thetag/link",
+		},
 
 		{
 			spec: uploadDataPathSpec{
@@ -87,6 +108,18 @@ func TestPathMapper(t *testing.T) {
 			spec:     layersPathSpec{name: "foo/bar"},
 			expected: "/docker/registry/v2/repositories/foo/bar/_layers",
 		},
+		{
+			spec:     repositoryIndexCompletePathSpec{},
+			expected: "/docker/registry/v2/repositoryindex/_complete",
+		},
+		{
+			spec:     repositoryIndexEntryPathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositoryindex/foo/bar/_repository",
+		},
+		{
+			spec:     credentialsPathSpec{},
+			expected: "/docker/registry/v2/credentials",
+		},
 	} {
 		p, err := pathFor(testcase.spec)
 		if err != nil {
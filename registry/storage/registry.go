@@ -118,6 +118,34 @@ func BlobDescriptorCacheProvider(blobDescriptorCacheProvider cache.BlobDescripto
 	}
 }
 
+// MetadataStore returns a functional option for NewRegistry. It keeps the
+// tags and the manifest revision and layer links of repositories in the
+// store, rather than in the storage driver.
+func MetadataStore(store storagedriver.StorageDriver) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobStore.metadata = store
+		return nil
+	}
+}
+
+// linkDriverOf returns the driver holding the links and tags of the
+// namespace, if it is a registry.
+func linkDriverOf(ns distribution.Namespace) storagedriver.StorageDriver {
+	if reg, ok := ns.(*registry); ok {
+		return reg.blobStore.linkDriver()
+	}
+	return nil
+}
+
+// metadataOf returns the metadata store of the namespace, if it is a
+// registry with one.
+func metadataOf(ns distribution.Namespace) storagedriver.StorageDriver {
+	if reg, ok := ns.(*registry); ok {
+		return reg.blobStore.metadata
+	}
+	return nil
+}
+
 // NewRegistry creates a new registry instance from the provided driver. The
 // resulting registry may be shared by multiple goroutines but is cheap to
 // allocate. If the Redirect option is specified, the backend blob server will
@@ -245,6 +273,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		// manifests. This instance cannot be used for blob checks.
 		linkPathFns:           manifestLinkPathFns,
 		linkDirectoryPathSpec: manifestDirectoryPathSpec,
+		beforeLink:            repo.indexRepository,
 	}
 
 	var v1Handler ManifestHandler
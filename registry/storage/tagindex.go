@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// The tag reverse index maps each manifest revision of a repository to the
+// tags currently pointing at it, so that looking up the tags of a digest
+// costs as much as the number of matching tags rather than the number of
+// tags in the repository. Entries are added before a tag is pointed at a
+// revision and removed after it has moved away, so the index may hold stale
+// entries, which lookups check against the current link of their tag.
+//
+// The index is only a hint: it misses tags moved concurrently, when an
+// update removes the entry another has just relied on, and tags moved by
+// registries predating the index. Garbage collection and manifest deletion
+// read the current link of every tag instead.
+//
+// Repositories created before the index was introduced have tags without
+// entries. The index of a repository is only used once it is marked
+// complete, either because it was started along with the first tag of the
+// repository or because it was rebuilt with RebuildTagReverseIndex.
+
+// RebuildTagReverseIndex rebuilds the tag reverse index of every repository
+// in the registry and marks it complete. Tags moved while a repository is
+// being rebuilt may be missing from its index, so the registry should not
+// accept pushes while this runs.
+func RebuildTagReverseIndex(ctx context.Context, registry distribution.Namespace) error {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	return repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		emit(repoName)
+
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+
+		ts, ok := repository.Tags(ctx).(*tagStore)
+		if !ok {
+			return fmt.Errorf("unable to convert TagService of %s into a tag store", repoName)
+		}
+
+		if err := ts.rebuildReverseIndex(ctx); err != nil {
+			return fmt.Errorf("failed to rebuild tag reverse index of %s: %v", repoName, err)
+		}
+		return nil
+	})
+}
+
+// rebuildReverseIndex adds an entry for every tag of the repository, removes
+// the entries not matching the current link of their tag and marks the index
+// complete.
+func (ts *tagStore) rebuildReverseIndex(ctx context.Context) error {
+	name := ts.repository.Named().Name()
+
+	completePath, err := pathFor(manifestTagReverseIndexCompletePathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	// lookups read every tag until the index is complete again
+	if err := ts.blobStore.linkDriver().Delete(ctx, completePath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	tags, err := ts.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return err
+		}
+	}
+
+	current := make(map[string]digest.Digest, len(tags))
+	for _, tag := range tags {
+		revision, err := ts.currentRevision(ctx, tag)
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+
+		if err := ts.addReverseIndexEntry(ctx, tag, revision); err != nil {
+			return err
+		}
+		current[tag] = revision
+	}
+
+	root, err := pathFor(manifestTagReverseIndexPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	var stale []struct {
+		tag      string
+		revision digest.Digest
+	}
+	err = ts.blobStore.linkDriver().Walk(ctx, root, func(fileInfo storagedriver.FileInfo) error {
+		// entries are <algorithm>/<hex digest>/<tag>/link
+		parts := strings.Split(strings.TrimPrefix(fileInfo.Path(), root+"/"), "/")
+		if fileInfo.IsDir() || len(parts) != 4 || parts[3] != "link" {
+			return nil
+		}
+
+		tag := parts[2]
+		revision := digest.NewDigestFromHex(parts[0], parts[1])
+		if current[tag] != revision {
+			stale = append(stale, struct {
+				tag      string
+				revision digest.Digest
+			}{tag, revision})
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	for _, entry := range stale {
+		if err := ts.removeReverseIndexEntry(ctx, entry.tag, entry.revision); err != nil {
+			return err
+		}
+	}
+
+	return ts.blobStore.linkDriver().PutContent(ctx, completePath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// startReverseIndex marks the reverse index of a repository without any tag
+// as complete, since all of its tags will be added to the index.
+func (ts *tagStore) startReverseIndex(ctx context.Context) error {
+	name := ts.repository.Named().Name()
+
+	tagsPath, err := pathFor(manifestTagsPathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	if _, err := ts.blobStore.linkDriver().Stat(ctx, tagsPath); err == nil {
+		return nil
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		return err
+	}
+
+	completePath, err := pathFor(manifestTagReverseIndexCompletePathSpec{name: name})
+	if err != nil {
+		return err
+	}
+
+	return ts.blobStore.linkDriver().PutContent(ctx, completePath, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// reverseIndexComplete returns whether the reverse index of the repository
+// holds all of its tags.
+func (ts *tagStore) reverseIndexComplete(ctx context.Context) (bool, error) {
+	completePath, err := pathFor(manifestTagReverseIndexCompletePathSpec{
+		name: ts.repository.Named().Name(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := ts.blobStore.linkDriver().Stat(ctx, completePath); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// addReverseIndexEntry records that the tag points at the revision.
+func (ts *tagStore) addReverseIndexEntry(ctx context.Context, tag string, revision digest.Digest) error {
+	entryPath, err := pathFor(manifestTagReverseIndexEntryLinkPathSpec{
+		name:     ts.repository.Named().Name(),
+		revision: revision,
+		tag:      tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ts.blobStore.link(ctx, entryPath, revision)
+}
+
+// removeReverseIndexEntry removes the record of the tag pointing at the
+// revision, if any.
+func (ts *tagStore) removeReverseIndexEntry(ctx context.Context, tag string, revision digest.Digest) error {
+	entryPath, err := pathFor(manifestTagReverseIndexEntryLinkPathSpec{
+		name:     ts.repository.Named().Name(),
+		revision: revision,
+		tag:      tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ts.blobStore.linkDriver().Delete(ctx, path.Dir(entryPath)); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"path"
 	"sort"
+	"strings"
 
 	"github.com/distribution/distribution/v3"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -12,6 +14,10 @@ import (
 
 var _ distribution.TagService = &tagStore{}
 
+// errFinishedWalk is returned from the walk function once enough tags have
+// been collected, ending the walk early.
+var errFinishedWalk = errors.New("finished walk")
+
 // tagStore provides methods to manage manifest tags in a backend storage driver.
 // This implementation uses the same on-disk layout as the (now deleted) tag
 // store.  This provides backward compatibility with current registry deployments
@@ -33,7 +39,7 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 		return tags, err
 	}
 
-	entries, err := ts.blobStore.driver.List(ctx, pathSpec)
+	entries, err := ts.blobStore.linkDriver().List(ctx, pathSpec)
 	if err != nil {
 		switch err := err.(type) {
 		case storagedriver.PathNotFoundError:
@@ -55,6 +61,113 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 	return tags, nil
 }
 
+// List returns at most limit tags sorting after last, or every tag after
+// last if limit is negative. Drivers which can start a walk part way through
+// are walked from last onwards, stopping once no tag left to walk can sort
+// among the tags found; otherwise every tag is listed.
+func (ts *tagStore) List(ctx context.Context, limit int, last string) ([]string, error) {
+	if limit == 0 {
+		return []string{}, nil
+	}
+
+	if limit > 0 {
+		if walker, ok := ts.blobStore.linkDriver().(storagedriver.StartAfterWalker); ok {
+			tags, err := ts.walkTags(ctx, walker, limit, last)
+			if _, unsupported := err.(storagedriver.ErrUnsupportedMethod); !unsupported {
+				return tags, err
+			}
+		}
+	}
+
+	all, err := ts.All(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+
+	tags := []string{}
+	for _, tag := range all {
+		if tag > last {
+			tags = append(tags, tag)
+		}
+	}
+	if limit > 0 && len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	return tags, nil
+}
+
+// walkTags returns the first limit tags sorting after last, walking the tags
+// directory from last onwards.
+//
+// Paths are walked in key order, in which a tag sorts as though followed by
+// a slash, so "v1.0" is walked before "v1" although it sorts after it. The
+// walk therefore collects the smallest tags seen so far and only stops once
+// every tag left to walk is known to sort after all of them.
+func (ts *tagStore) walkTags(ctx context.Context, walker storagedriver.StartAfterWalker, limit int, last string) ([]string, error) {
+	tags := []string{}
+
+	root, err := pathFor(manifestTagPathSpec{
+		name: ts.repository.Named().Name(),
+	})
+	if err != nil {
+		return tags, err
+	}
+
+	startAfter := ""
+	if last != "" {
+		startAfter = path.Join(root, last)
+	}
+
+	err = walker.WalkStartAfter(ctx, root, startAfter, func(fileInfo storagedriver.FileInfo) error {
+		tag := strings.TrimPrefix(fileInfo.Path(), root+"/")
+		if !fileInfo.IsDir() || strings.Contains(tag, "/") {
+			return nil
+		}
+
+		if len(tags) == limit && tag+"/" > walkBound(tags[limit-1])+"/" {
+			return errFinishedWalk
+		}
+
+		// the driver may walk paths sorting before last
+		if tag > last {
+			i := sort.SearchStrings(tags, tag)
+			if i < limit {
+				tags = append(tags[:i], append([]string{tag}, tags[i:]...)...)
+				if len(tags) > limit {
+					tags = tags[:limit]
+				}
+			}
+		}
+
+		// there is no need to walk the contents of the tag directory
+		return storagedriver.ErrSkipDir
+	})
+
+	if err != nil && !errors.Is(err, errFinishedWalk) {
+		switch err := err.(type) {
+		case storagedriver.PathNotFoundError:
+			return tags, distribution.ErrRepositoryUnknown{Name: ts.repository.Named().Name()}
+		default:
+			return tags, err
+		}
+	}
+
+	return tags, nil
+}
+
+// walkBound returns the shortest prefix of tag followed by a character
+// sorting before a slash, or tag itself. Any tag walked after the bound sorts
+// after tag.
+func walkBound(tag string) string {
+	for i := 1; i < len(tag); i++ {
+		if tag[i] < '/' {
+			return tag[:i]
+		}
+	}
+	return tag
+}
+
 // Tag tags the digest with the given tag, updating the the store to point at
 // the current tag. The digest must point to a manifest.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
@@ -67,6 +180,23 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 		return err
 	}
 
+	// An unreadable current link leaves a stale reverse index entry
+	// behind at worst, which lookups ignore.
+	previous, err := ts.blobStore.readlink(ctx, currentPath)
+	if err != nil {
+		previous = ""
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			if err := ts.startReverseIndex(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Add the repository to the catalog before its first tag
+	if err := ts.repository.indexRepository(ctx); err != nil {
+		return err
+	}
+
 	lbs := ts.linkedBlobStore(ctx, tag)
 
 	// Link into the index
@@ -74,8 +204,22 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 		return err
 	}
 
+	// Add to the reverse index before overwriting the current link, so
+	// that lookups never miss the tag.
+	if err := ts.addReverseIndexEntry(ctx, tag, desc.Digest); err != nil {
+		return err
+	}
+
 	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	if err := ts.blobStore.link(ctx, currentPath, desc.Digest); err != nil {
+		return err
+	}
+
+	if previous != "" && previous != desc.Digest {
+		return ts.removeReverseIndexEntry(ctx, tag, previous)
+	}
+
+	return nil
 }
 
 // resolve the current revision for name and tag.
@@ -112,7 +256,27 @@ func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 		return err
 	}
 
-	return ts.blobStore.driver.Delete(ctx, tagPath)
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	// As in Tag, a revision which can't be read leaves a stale reverse
+	// index entry behind at worst.
+	revision, _ := ts.blobStore.readlink(ctx, currentPath)
+
+	if err := ts.blobStore.linkDriver().Delete(ctx, tagPath); err != nil {
+		return err
+	}
+
+	if revision != "" {
+		return ts.removeReverseIndexEntry(ctx, tag, revision)
+	}
+
+	return nil
 }
 
 // linkedBlobStore returns the linkedBlobStore for the named tag, allowing one
@@ -135,9 +299,65 @@ func (ts *tagStore) linkedBlobStore(ctx context.Context, tag string) *linkedBlob
 	}
 }
 
-// Lookup recovers a list of tags which refer to this digest.  When a manifest is deleted by
-// digest, tag entries which point to it need to be recovered to avoid dangling tags.
+// Lookup recovers a list of tags which refer to this digest, using the tag
+// reverse index once it is complete. As the index may miss tags, callers which
+// must not leave dangling tags behind read the current link of every tag.
 func (ts *tagStore) Lookup(ctx context.Context, desc distribution.Descriptor) ([]string, error) {
+	complete, err := ts.reverseIndexComplete(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !complete {
+		return ts.lookupAll(ctx, desc)
+	}
+
+	entriesPath, err := pathFor(manifestTagReverseIndexEntriesPathSpec{
+		name:     ts.repository.Named().Name(),
+		revision: desc.Digest,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ts.blobStore.linkDriver().List(ctx, entriesPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var tags []string
+	for _, entry := range entries {
+		tag := path.Base(entry)
+
+		// the entry may be stale if it could not be removed when the
+		// tag was moved or deleted, so check it against the tag.
+		tagDigest, err := ts.currentRevision(ctx, tag)
+		if err != nil {
+			switch err.(type) {
+			case storagedriver.PathNotFoundError:
+				continue
+			}
+			return nil, err
+		}
+
+		if tagDigest == desc.Digest {
+			tags = append(tags, tag)
+		}
+	}
+
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+// lookupAll recovers the tags which refer to the digest by reading the
+// current revision of every tag, for repositories without a complete reverse
+// index.
+func (ts *tagStore) lookupAll(ctx context.Context, desc distribution.Descriptor) ([]string, error) {
 	allTags, err := ts.All(ctx)
 	switch err.(type) {
 	case distribution.ErrRepositoryUnknown:
@@ -151,13 +371,7 @@ func (ts *tagStore) Lookup(ctx context.Context, desc distribution.Descriptor) ([
 
 	var tags []string
 	for _, tag := range allTags {
-		tagLinkPathSpec := manifestTagCurrentPathSpec{
-			name: ts.repository.Named().Name(),
-			tag:  tag,
-		}
-
-		tagLinkPath, _ := pathFor(tagLinkPathSpec)
-		tagDigest, err := ts.blobStore.readlink(ctx, tagLinkPath)
+		tagDigest, err := ts.currentRevision(ctx, tag)
 		if err != nil {
 			switch err.(type) {
 			case storagedriver.PathNotFoundError:
@@ -174,6 +388,19 @@ func (ts *tagStore) Lookup(ctx context.Context, desc distribution.Descriptor) ([
 	return tags, nil
 }
 
+// currentRevision reads the current link of the tag.
+func (ts *tagStore) currentRevision(ctx context.Context, tag string) (digest.Digest, error) {
+	tagLinkPath, err := pathFor(manifestTagCurrentPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return ts.blobStore.readlink(ctx, tagLinkPath)
+}
+
 func (ts *tagStore) ManifestDigests(ctx context.Context, tag string) ([]digest.Digest, error) {
 	var tagLinkPath = func(name string, dgst digest.Digest) (string, error) {
 		return pathFor(manifestTagIndexEntryLinkPathSpec{
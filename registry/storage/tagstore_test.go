@@ -2,13 +2,16 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/manifest"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/reference"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	digest "github.com/opencontainers/go-digest"
 )
@@ -166,6 +169,128 @@ func TestTagStoreAll(t *testing.T) {
 
 }
 
+func TestTagStoreList(t *testing.T) {
+	env := testTagStore(t)
+	tagStore := env.ts
+	ctx := env.ctx
+
+	if _, err := tagStore.List(ctx, 10, ""); err == nil {
+		t.Fatalf("expected error listing tags of an unknown repository")
+	} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("unexpected error listing tags of an unknown repository: %v", err)
+	}
+
+	alpha := "abcdefghijklmnopqrstuvwxyz"
+	for i := 0; i < len(alpha); i++ {
+		tag := alpha[i]
+		desc := distribution.Descriptor{Digest: "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"}
+		err := tagStore.Tag(ctx, string(tag), desc)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		limit    int
+		last     string
+		expected []string
+	}{
+		{limit: -1, last: "w", expected: []string{"x", "y", "z"}},
+		{limit: 3, last: "", expected: []string{"a", "b", "c"}},
+		{limit: 3, last: "c", expected: []string{"d", "e", "f"}},
+		{limit: 3, last: "cc", expected: []string{"d", "e", "f"}},
+		{limit: 3, last: "y", expected: []string{"z"}},
+		{limit: 3, last: "z", expected: []string{}},
+		{limit: 0, last: "", expected: []string{}},
+	} {
+		tags, err := tagStore.List(ctx, tc.limit, tc.last)
+		if err != nil {
+			t.Fatalf("unexpected error listing %d tags after %q: %v", tc.limit, tc.last, err)
+		}
+		if !reflect.DeepEqual(tags, tc.expected) {
+			t.Errorf("unexpected tags listing %d tags after %q: expected %v, got %v", tc.limit, tc.last, tc.expected, tags)
+		}
+	}
+}
+
+// keyOrderDriver walks the entries of a directory in the order object
+// stores list keys in, where an entry sorts as though followed by a slash.
+type keyOrderDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d keyOrderDriver) WalkStartAfter(ctx context.Context, path, startAfter string, f storagedriver.WalkFn) error {
+	children, err := d.List(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i]+"/" < children[j]+"/"
+	})
+
+	for _, child := range children {
+		if child <= startAfter {
+			continue
+		}
+
+		fi, err := d.Stat(ctx, child)
+		if err != nil {
+			return err
+		}
+
+		if err := f(fi); err != nil && !errors.Is(err, storagedriver.ErrSkipDir) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestTagStoreListKeyOrder(t *testing.T) {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, keyOrderDriver{inmemory.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagStore := repo.Tags(ctx)
+
+	// walked as v1-rc, v1.0, v1, v10, v2
+	for _, tag := range []string{"v1", "v1-rc", "v1.0", "v10", "v2"} {
+		desc := distribution.Descriptor{Digest: "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"}
+		if err := tagStore.Tag(ctx, tag, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		limit    int
+		last     string
+		expected []string
+	}{
+		{limit: 1, last: "", expected: []string{"v1"}},
+		{limit: 2, last: "", expected: []string{"v1", "v1-rc"}},
+		{limit: 2, last: "v1", expected: []string{"v1-rc", "v1.0"}},
+		{limit: 2, last: "v1-rc", expected: []string{"v1.0", "v10"}},
+		{limit: 2, last: "v10", expected: []string{"v2"}},
+		{limit: -1, last: "v1.0", expected: []string{"v10", "v2"}},
+	} {
+		tags, err := tagStore.List(ctx, tc.limit, tc.last)
+		if err != nil {
+			t.Fatalf("unexpected error listing %d tags after %q: %v", tc.limit, tc.last, err)
+		}
+		if !reflect.DeepEqual(tags, tc.expected) {
+			t.Errorf("unexpected tags listing %d tags after %q: expected %v, got %v", tc.limit, tc.last, tc.expected, tags)
+		}
+	}
+}
+
 func TestTagLookup(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
@@ -307,6 +432,104 @@ func TestTagIndexes(t *testing.T) {
 	}
 }
 
+func TestTagReverseIndex(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	reg, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoRef, _ := reference.WithName("a/b")
+	repo, err := reg.Repository(ctx, repoRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagStore := repo.Tags(ctx)
+
+	descA := distribution.Descriptor{Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+	desc0 := distribution.Descriptor{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"}
+
+	lookup := func(desc distribution.Descriptor, expected ...string) {
+		t.Helper()
+		tags, err := tagStore.Lookup(ctx, desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags) != len(expected) || (len(tags) > 0 && !reflect.DeepEqual(tags, expected)) {
+			t.Fatalf("unexpected tags looking up %s: expected %v, got %v", desc.Digest, expected, tags)
+		}
+	}
+
+	for _, tag := range []string{"a", "b", "c"} {
+		if err := tagStore.Tag(ctx, tag, descA); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	completePath, err := pathFor(manifestTagReverseIndexCompletePathSpec{name: "a/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, completePath); err != nil {
+		t.Fatalf("expected the reverse index of a new repository to be complete: %v", err)
+	}
+	lookup(descA, "a", "b", "c")
+
+	// moving and removing tags updates the index
+	if err := tagStore.Tag(ctx, "b", desc0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tagStore.Untag(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	lookup(descA, "a")
+	lookup(desc0, "b")
+
+	// stale entries are ignored
+	stalePath, err := pathFor(manifestTagReverseIndexEntryLinkPathSpec{name: "a/b", revision: desc0.Digest, tag: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, stalePath, []byte(desc0.Digest)); err != nil {
+		t.Fatal(err)
+	}
+	lookup(desc0, "b")
+
+	// without the index, as in repositories created before it, lookups
+	// read every tag
+	indexPath, err := pathFor(manifestTagReverseIndexPathSpec{name: "a/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, indexPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := tagStore.Tag(ctx, "c", desc0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, completePath); err == nil {
+		t.Fatalf("expected the reverse index of an existing repository to be incomplete")
+	}
+	lookup(descA, "a")
+	lookup(desc0, "b", "c")
+
+	if err := d.PutContent(ctx, stalePath, []byte(desc0.Digest)); err != nil {
+		t.Fatal(err)
+	}
+	if err := RebuildTagReverseIndex(ctx, reg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, completePath); err != nil {
+		t.Fatalf("expected the rebuilt reverse index to be complete: %v", err)
+	}
+	if _, err := d.Stat(ctx, stalePath); err == nil {
+		t.Fatalf("expected stale entries to be removed by the rebuild")
+	}
+	lookup(descA, "a")
+	lookup(desc0, "b", "c")
+}
+
 func digestMap(dgsts []digest.Digest) map[digest.Digest]struct{} {
 	set := make(map[digest.Digest]struct{})
 	for _, dgst := range dgsts {
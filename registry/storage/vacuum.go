@@ -26,6 +26,18 @@ func NewVacuum(ctx context.Context, driver driver.StorageDriver) Vacuum {
 type Vacuum struct {
 	driver driver.StorageDriver
 	ctx    context.Context
+
+	// metadata holds the links and tags of repositories in place of driver,
+	// if set.
+	metadata driver.StorageDriver
+}
+
+// linkDriver returns the driver holding the links and tags of repositories.
+func (v Vacuum) linkDriver() driver.StorageDriver {
+	if v.metadata != nil {
+		return v.metadata
+	}
+	return v.driver
 }
 
 // RemoveBlob removes a blob from the filesystem
@@ -60,7 +72,7 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 			return err
 		}
 
-		_, err = v.driver.Stat(v.ctx, tagsPath)
+		_, err = v.linkDriver().Stat(v.ctx, tagsPath)
 		if err != nil {
 			switch err := err.(type) {
 			case driver.PathNotFoundError:
@@ -70,22 +82,33 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 			}
 		}
 		dcontext.GetLogger(v.ctx).Infof("deleting manifest tag reference: %s", tagsPath)
-		err = v.driver.Delete(v.ctx, tagsPath)
+		err = v.linkDriver().Delete(v.ctx, tagsPath)
 		if err != nil {
 			return err
 		}
 	}
 
+	// remove the tag reverse index entries left behind for the manifest
+	reverseIndexPath, err := pathFor(manifestTagReverseIndexEntriesPathSpec{name: name, revision: dgst})
+	if err != nil {
+		return err
+	}
+	if err := v.linkDriver().Delete(v.ctx, reverseIndexPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
 	manifestPath, err := pathFor(manifestRevisionPathSpec{name: name, revision: dgst})
 	if err != nil {
 		return err
 	}
 	dcontext.GetLogger(v.ctx).Infof("deleting manifest: %s", manifestPath)
-	return v.driver.Delete(v.ctx, manifestPath)
+	return v.linkDriver().Delete(v.ctx, manifestPath)
 }
 
 // RemoveRepository removes a repository directory from the
-// filesystem
+// filesystem, keeping the repositories nested under its name
 func (v Vacuum) RemoveRepository(repoName string) error {
 	rootForRepository, err := pathFor(repositoriesRootPathSpec{})
 	if err != nil {
@@ -93,10 +116,23 @@ func (v Vacuum) RemoveRepository(repoName string) error {
 	}
 	repoDir := path.Join(rootForRepository, repoName)
 	dcontext.GetLogger(v.ctx).Infof("Deleting repo: %s", repoDir)
-	err = v.driver.Delete(v.ctx, repoDir)
-	if err != nil {
-		return err
+
+	drivers := []driver.StorageDriver{v.driver}
+	if v.metadata != nil {
+		drivers = append(drivers, v.metadata)
 	}
 
-	return nil
+	found := false
+	for _, d := range drivers {
+		removed, err := removeRepositoryFiles(v.ctx, d, repoDir)
+		if err != nil {
+			return err
+		}
+		found = found || removed
+	}
+	if !found {
+		return driver.PathNotFoundError{Path: repoDir}
+	}
+
+	return removeRepositoryIndexEntry(v.ctx, v.linkDriver(), repoName)
 }
@@ -24,6 +24,11 @@ type TagService interface {
 	// All returns the set of tags managed by this tag service
 	All(ctx context.Context) ([]string, error)
 
+	// List returns at most limit tags managed by this tag service, in
+	// lexical order, starting after last. If limit is negative, all the
+	// tags after last are returned.
+	List(ctx context.Context, limit int, last string) ([]string, error)
+
 	// Lookup returns the set of tags referencing the given digest.
 	Lookup(ctx context.Context, digest Descriptor) ([]string, error)
 }